@@ -0,0 +1,204 @@
+/*
+Copyright 2022 huo.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Schedule is a parsed cron schedule, each field is a bit set of the values it matches
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// a day matches either field when both are restricted, like cron does
+	domStar, dowStar bool
+}
+
+// ParseSchedule parses the five fields of a cron schedule. A field is a list of values, ranges
+// like 1-5 or *, each one optionally followed by a step like */15
+func ParseSchedule(spec string) (*Schedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, found %d", len(fields))
+	}
+
+	s := &Schedule{
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}
+	for i, f := range []struct {
+		name     string
+		bits     *uint64
+		min, max int
+	}{
+		{"minute", &s.minute, 0, 59},
+		{"hour", &s.hour, 0, 23},
+		{"day of month", &s.dom, 1, 31},
+		{"month", &s.month, 1, 12},
+		{"day of week", &s.dow, 0, 7},
+	} {
+		bits, err := parseField(fields[i], f.min, f.max)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.name, err)
+		}
+		*f.bits = bits
+	}
+
+	// 7 is another Sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	return s, nil
+}
+
+func parseField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step, stepped := 1, false
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			part, stepped = part[:i], true
+		}
+
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			switch {
+			case len(bounds) == 2:
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			case !stepped:
+				hi = lo
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of the range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+// Next returns the first time matching the schedule after t, in t's location. It is zero if
+// nothing matches within five years, like the 30th of February
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = after(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc))
+		case !s.dayMatches(t):
+			t = after(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc))
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = after(t, time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc))
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// after makes sure the search moves forward, time.Date may go back across a DST change
+func after(t, next time.Time) time.Time {
+	if next.After(t) {
+		return next
+	}
+	return t.Add(time.Minute)
+}
+
+// Location returns the time zone of the schedules
+func (h *HibernationSpec) Location() (*time.Location, error) {
+	return loadLocation(h.TimeZone)
+}
+
+// Location returns the time zone of the schedule
+func (w *MaintenanceWindow) Location() (*time.Location, error) {
+	return loadLocation(w.TimeZone)
+}
+
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// validate makes sure the time zone is known and the schedules parse
+func (h *HibernationSpec) validate(path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if _, err := h.Location(); err != nil {
+		allErrs = append(allErrs, field.Invalid(path.Child("timeZone"), h.TimeZone, err.Error()))
+	}
+
+	for i, w := range h.Windows {
+		p := path.Child("windows").Index(i)
+		if _, err := ParseSchedule(w.Sleep); err != nil {
+			allErrs = append(allErrs, field.Invalid(p.Child("sleep"), w.Sleep, err.Error()))
+		}
+		if _, err := ParseSchedule(w.Wake); err != nil {
+			allErrs = append(allErrs, field.Invalid(p.Child("wake"), w.Wake, err.Error()))
+		}
+	}
+
+	return allErrs
+}
+
+// validate makes sure the window opens on a valid schedule for a while
+func (w *MaintenanceWindow) validate(path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if _, err := ParseSchedule(w.Start); err != nil {
+		allErrs = append(allErrs, field.Invalid(path.Child("start"), w.Start, err.Error()))
+	}
+	if w.Duration.Duration < time.Minute {
+		allErrs = append(allErrs, field.Invalid(path.Child("duration"), w.Duration.String(), "must be at least 1m"))
+	}
+	if _, err := w.Location(); err != nil {
+		allErrs = append(allErrs, field.Invalid(path.Child("timeZone"), w.TimeZone, err.Error()))
+	}
+
+	return allErrs
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2022 huo.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestParseSchedule(t *testing.T) {
+	cases := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{spec: "0 20 * * 1-5"},
+		{spec: "*/15 8-18 1,15 * 0,7"},
+		{spec: "30 6 * 1-12/3 *"},
+		{spec: "0 20 * *", wantErr: true},
+		{spec: "60 20 * * *", wantErr: true},
+		{spec: "0 20 0 * *", wantErr: true},
+		{spec: "0 20 * * 5-1", wantErr: true},
+		{spec: "*/0 * * * *", wantErr: true},
+		{spec: "0 20 * * mon", wantErr: true},
+	}
+
+	for _, c := range cases {
+		if _, err := ParseSchedule(c.spec); (err != nil) != c.wantErr {
+			t.Errorf("ParseSchedule(%q) error = %v, wantErr %v", c.spec, err, c.wantErr)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	cases := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{
+			name: "later the same day",
+			spec: "0 20 * * 1-5",
+			from: time.Date(2022, 6, 1, 9, 30, 0, 0, time.UTC),
+			want: time.Date(2022, 6, 1, 20, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "strictly after",
+			spec: "0 20 * * 1-5",
+			from: time.Date(2022, 6, 1, 20, 0, 0, 0, time.UTC),
+			want: time.Date(2022, 6, 2, 20, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "over the weekend",
+			spec: "0 8 * * 1-5",
+			from: time.Date(2022, 6, 3, 20, 0, 0, 0, time.UTC),
+			want: time.Date(2022, 6, 6, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "steps",
+			spec: "*/20 * * * *",
+			from: time.Date(2022, 6, 1, 9, 41, 30, 0, time.UTC),
+			want: time.Date(2022, 6, 1, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "day of month or of week",
+			spec: "0 0 13 * 5",
+			from: time.Date(2022, 6, 4, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2022, 6, 10, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "sunday as 7",
+			spec: "0 0 * * 7",
+			from: time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2022, 6, 5, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "next year",
+			spec: "0 0 1 1 *",
+			from: time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "time zone",
+			spec: "0 8 * * *",
+			from: time.Date(2022, 6, 1, 9, 0, 0, 0, paris),
+			want: time.Date(2022, 6, 2, 6, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "skipped by daylight saving time",
+			spec: "30 2 * * *",
+			from: time.Date(2022, 3, 27, 0, 0, 0, 0, paris),
+			want: time.Date(2022, 3, 28, 2, 30, 0, 0, paris),
+		},
+		{
+			name: "never",
+			spec: "0 0 30 2 *",
+			from: time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s, err := ParseSchedule(c.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Next(c.from); !got.Equal(c.want) {
+				t.Errorf("next %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestValidateHibernation(t *testing.T) {
+	cases := []struct {
+		name    string
+		spec    HibernationSpec
+		wantErr bool
+	}{
+		{
+			name: "nights",
+			spec: HibernationSpec{TimeZone: "Europe/Paris", Windows: []HibernationWindow{{Sleep: "0 20 * * *", Wake: "0 8 * * *"}}},
+		},
+		{
+			name: "asleep",
+			spec: HibernationSpec{Asleep: true},
+		},
+		{
+			name:    "unknown time zone",
+			spec:    HibernationSpec{TimeZone: "Europe/Fufu"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid schedule",
+			spec:    HibernationSpec{Windows: []HibernationWindow{{Sleep: "20:00", Wake: "0 8 * * *"}}},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			errs := c.spec.validate(field.NewPath("spec").Child("hibernation"))
+			if (len(errs) > 0) != c.wantErr {
+				t.Errorf("errors %v, want error %v", errs, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateMaintenanceWindow(t *testing.T) {
+	cases := []struct {
+		name    string
+		window  MaintenanceWindow
+		wantErr bool
+	}{
+		{
+			name:   "saturday nights",
+			window: MaintenanceWindow{Start: "0 2 * * 6", Duration: metav1.Duration{Duration: 2 * time.Hour}, TimeZone: "Europe/Paris"},
+		},
+		{
+			name:    "no duration",
+			window:  MaintenanceWindow{Start: "0 2 * * 6"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid start",
+			window:  MaintenanceWindow{Start: "saturday", Duration: metav1.Duration{Duration: time.Hour}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown time zone",
+			window:  MaintenanceWindow{Start: "0 2 * * 6", Duration: metav1.Duration{Duration: time.Hour}, TimeZone: "Europe/Fufu"},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			errs := c.window.validate(field.NewPath("spec").Child("maintenanceWindows").Index(0))
+			if (len(errs) > 0) != c.wantErr {
+				t.Errorf("errors %v, want error %v", errs, c.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2022 huo.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Suffixes of the Fufu's children given to the naming templates
+const (
+	SuffixDeploy    = "deploy"
+	SuffixCanary    = "canary"
+	SuffixBlue      = "blue"
+	SuffixGreen     = "green"
+	SuffixService   = "svc"
+	SuffixHpa       = "hpa"
+	SuffixPdb       = "pdb"
+	SuffixNetpol    = "netpol"
+	SuffixConfigMap = "nginx"
+	SuffixMonitor   = "monitor"
+	SuffixRules     = "rules"
+	SuffixSleeping  = "sleeping"
+)
+
+// DefaultNamingTemplate names the children like the Fufu, suffixed by their kind
+const DefaultNamingTemplate = "{{.Name}}-{{.Suffix}}"
+
+var suffixes = []string{SuffixDeploy, SuffixCanary, SuffixBlue, SuffixGreen, SuffixService, SuffixHpa,
+	SuffixPdb, SuffixNetpol, SuffixConfigMap, SuffixMonitor, SuffixRules, SuffixSleeping}
+
+// the Deployments of a Fufu are of the same kind, they need different names
+var deploySuffixes = []string{SuffixDeploy, SuffixCanary, SuffixBlue, SuffixGreen, SuffixSleeping}
+
+// namingTemplates keeps the naming templates parsed, by their text, the names being rendered
+// for every child on each reconcile. Few distinct templates are used across the Fufus
+var namingTemplates sync.Map
+
+// parseNaming parses the naming template text once
+func parseNaming(text string) (*template.Template, error) {
+	if tmpl, ok := namingTemplates.Load(text); ok {
+		return tmpl.(*template.Template), nil
+	}
+
+	tmpl, err := template.New("naming").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	namingTemplates.Store(text, tmpl)
+	return tmpl, nil
+}
+
+// ChildName renders the name of the Fufu's child with the given suffix
+func (r *Fufu) ChildName(suffix string) (string, error) {
+	text := r.Spec.Naming.Template
+	if override, ok := r.Spec.Naming.Overrides[suffix]; ok {
+		text = override
+	}
+	if text == "" {
+		text = DefaultNamingTemplate
+	}
+
+	tmpl, err := parseNaming(text)
+	if err != nil {
+		return "", err
+	}
+
+	var name bytes.Buffer
+	if err := tmpl.Execute(&name, struct{ Name, Namespace, Suffix string }{r.Name, r.Namespace, suffix}); err != nil {
+		return "", err
+	}
+	return TruncateName(name.String()), nil
+}
+
+// TruncateName keeps a name within the length of a DNS label, a long name is cut and suffixed
+// with a hash of it so that names sharing a prefix don't collide
+func TruncateName(name string) string {
+	if len(name) <= validation.DNS1035LabelMaxLength {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	hash := hex.EncodeToString(sum[:])[:8]
+	prefix := strings.TrimRight(name[:validation.DNS1035LabelMaxLength-len(hash)-1], "-.")
+
+	return prefix + "-" + hash
+}
+
+// validate makes sure the templates render valid and distinct names for the Fufu, the default
+// one is left to the API server like before
+func (n *NamingSpec) validate(fufu *Fufu, path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if n.Template == "" && len(n.Overrides) == 0 {
+		return nil
+	}
+
+	unknown := []string{}
+	for suffix := range n.Overrides {
+		if !contains(suffixes, suffix) {
+			unknown = append(unknown, suffix)
+		}
+	}
+	sort.Strings(unknown)
+	for _, suffix := range unknown {
+		allErrs = append(allErrs, field.NotSupported(path.Child("overrides"), suffix, suffixes))
+	}
+
+	names := map[string]string{}
+	for _, suffix := range suffixes {
+		p := path.Child("template")
+		if _, ok := n.Overrides[suffix]; ok {
+			p = path.Child("overrides").Key(suffix)
+		}
+
+		name, err := fufu.ChildName(suffix)
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(p, n.Template, err.Error()))
+			continue
+		}
+		for _, msg := range validation.IsDNS1035Label(name) {
+			allErrs = append(allErrs, field.Invalid(p, name, msg))
+		}
+
+		if contains(deploySuffixes, suffix) {
+			if other, ok := names[name]; ok {
+				allErrs = append(allErrs, field.Invalid(p, name, fmt.Sprintf("the %s and %s Deployments need different names", other, suffix)))
+			}
+			names[name] = suffix
+		}
+	}
+
+	return allErrs
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+/*
+Copyright 2022 huo.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestChildName(t *testing.T) {
+	long := strings.Repeat("fufu", 20)
+
+	cases := []struct {
+		name   string
+		fufu   string
+		naming NamingSpec
+		suffix string
+		want   string
+	}{
+		{
+			name:   "default",
+			fufu:   "fufu",
+			suffix: SuffixService,
+			want:   "fufu-svc",
+		},
+		{
+			name:   "template",
+			fufu:   "fufu",
+			naming: NamingSpec{Template: "cat-{{.Name}}-{{.Suffix}}"},
+			suffix: SuffixDeploy,
+			want:   "cat-fufu-deploy",
+		},
+		{
+			name:   "override",
+			fufu:   "fufu",
+			naming: NamingSpec{Template: "cat-{{.Name}}-{{.Suffix}}", Overrides: map[string]string{SuffixService: "{{.Name}}"}},
+			suffix: SuffixService,
+			want:   "fufu",
+		},
+		{
+			name:   "template kept for the others",
+			fufu:   "fufu",
+			naming: NamingSpec{Template: "cat-{{.Name}}-{{.Suffix}}", Overrides: map[string]string{SuffixService: "{{.Name}}"}},
+			suffix: SuffixHpa,
+			want:   "cat-fufu-hpa",
+		},
+		{
+			name:   "long name",
+			fufu:   long,
+			suffix: SuffixService,
+			want:   TruncateName(long + "-svc"),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fufu := &Fufu{ObjectMeta: metav1.ObjectMeta{Name: c.fufu, Namespace: "default"}}
+			fufu.Spec.Naming = c.naming
+
+			name, err := fufu.ChildName(c.suffix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != c.want {
+				t.Errorf("name %q, want %q", name, c.want)
+			}
+		})
+	}
+}
+
+func TestChildNameParsedOnce(t *testing.T) {
+	text := "parsed-{{.Name}}-{{.Suffix}}"
+	names := map[string]bool{}
+	for _, name := range []string{"tabby", "calico"} {
+		fufu := &Fufu{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+		fufu.Spec.Naming.Template = text
+		for i := 0; i < 2; i++ {
+			child, err := fufu.ChildName(SuffixService)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names[child] = true
+		}
+	}
+
+	if _, ok := namingTemplates.Load(text); !ok {
+		t.Errorf("template %q not kept parsed", text)
+	}
+	if len(names) != 2 || !names["parsed-tabby-svc"] || !names["parsed-calico-svc"] {
+		t.Errorf("names %v rendered from the parsed template", names)
+	}
+}
+
+func TestTruncateName(t *testing.T) {
+	short := "fufu-svc"
+	if got := TruncateName(short); got != short {
+		t.Errorf("short name truncated to %q", got)
+	}
+
+	prefix := strings.Repeat("a", 60)
+	deploy, svc := TruncateName(prefix+"-deploy"), TruncateName(prefix+"-svc")
+	for _, name := range []string{deploy, svc} {
+		if len(name) > validation.DNS1035LabelMaxLength {
+			t.Errorf("%q is %d characters long", name, len(name))
+		}
+		if msgs := validation.IsDNS1035Label(name); len(msgs) > 0 {
+			t.Errorf("%q is not a DNS label: %v", name, msgs)
+		}
+	}
+	if deploy == svc {
+		t.Errorf("names sharing a long prefix collide: %q", deploy)
+	}
+	if TruncateName(prefix+"-deploy") != deploy {
+		t.Errorf("truncation is not stable")
+	}
+}
+
+func TestValidateNaming(t *testing.T) {
+	cases := []struct {
+		name    string
+		naming  NamingSpec
+		wantErr bool
+	}{
+		{
+			name: "default",
+		},
+		{
+			name:   "template",
+			naming: NamingSpec{Template: "{{.Namespace}}-{{.Name}}-{{.Suffix}}"},
+		},
+		{
+			name:   "service named like the fufu",
+			naming: NamingSpec{Overrides: map[string]string{SuffixService: "{{.Name}}"}},
+		},
+		{
+			name:    "unparsable template",
+			naming:  NamingSpec{Template: "{{.Name"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown field",
+			naming:  NamingSpec{Template: "{{.Color}}-{{.Suffix}}"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid name",
+			naming:  NamingSpec{Template: "{{.Name}}_{{.Suffix}}"},
+			wantErr: true,
+		},
+		{
+			name:    "deployments named alike",
+			naming:  NamingSpec{Template: "{{.Name}}"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown suffix",
+			naming:  NamingSpec{Overrides: map[string]string{"ingress": "{{.Name}}"}},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fufu := &Fufu{ObjectMeta: metav1.ObjectMeta{Name: "fufu", Namespace: "default"}}
+			fufu.Spec.Naming = c.naming
+
+			errs := c.naming.validate(fufu, field.NewPath("spec").Child("naming"))
+			if (len(errs) > 0) != c.wantErr {
+				t.Errorf("errors %v, want error %v", errs, c.wantErr)
+			}
+		})
+	}
+}
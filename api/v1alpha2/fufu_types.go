@@ -17,7 +17,9 @@ limitations under the License.
 package v1alpha2
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
@@ -28,30 +30,555 @@ type FufuSpec struct {
 	// Important: Run "make" to regenerate code after modifying this file
 
 	// Foo is an example field of Fufu. Edit fufu_types.go to remove/update
-	Color  string         `json:"color,required"`
-	Weight string         `json:"weight,required"`
-	Age    int            `json:"age,required"`
-	Info   AdditionalInfo `json:"info,omitempty"`
+	Color  string `json:"color,required"`
+	Weight string `json:"weight,required"`
+	// Age is static, prefer BirthDate which keeps the age up to date
+	Age  int            `json:"age,omitempty"`
+	Info AdditionalInfo `json:"info,omitempty"`
+
+	// BirthDate, like 2016-04-01, takes precedence over Age
+	// +kubebuilder:validation:Format=date
+	BirthDate string `json:"birthDate,omitempty"`
+
+	// Probes tunes the HTTP probes generated for the web container
+	Probes Probes `json:"probes,omitempty"`
+
+	// Autoscaling sets the bounds of the generated HPA
+	Autoscaling AutoscalingSpec `json:"autoscaling,omitempty"`
+
+	// Replicas is the number of pods once autoscaling is disabled, default to the autoscaling
+	// floor. It is ignored while the HPA scales the Fufu. Set by kubectl scale or by an
+	// autoscaler targeting the Fufu through the scale subresource
+	// +kubebuilder:validation:Minimum=0
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// DisruptionBudget overrides the PodDisruptionBudget derived from the autoscaling floor
+	DisruptionBudget *DisruptionBudgetSpec `json:"disruptionBudget,omitempty"`
+
+	// NetworkPolicy restricts the traffic of the Fufu's pods, no policy is generated if empty
+	NetworkPolicy *NetworkPolicySpec `json:"networkPolicy,omitempty"`
+
+	// Monitoring exposes nginx's metrics to prometheus
+	Monitoring MonitoringSpec `json:"monitoring,omitempty"`
+
+	// Nginx customizes the configuration of the web container
+	Nginx NginxSpec `json:"nginx,omitempty"`
+
+	// Service customizes the Service exposing the Fufu
+	Service ServiceSpec `json:"service,omitempty"`
+
+	// ClassName references the FufuClass providing the defaults, the default class is used if empty
+	ClassName string `json:"className,omitempty"`
+
+	// Template customizes the Fufu's pods
+	Template PodTemplate `json:"template,omitempty"`
+
+	// RolloutStrategy tells how a new pod template replaces the running one, the Deployment
+	// rolls all the pods at once if empty
+	RolloutStrategy RolloutStrategy `json:"rolloutStrategy,omitempty"`
+
+	// RevisionHistoryLimit is the number of ControllerRevisions kept to roll back to, default to 10
+	// +kubebuilder:validation:Minimum=0
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+
+	// AdoptionPolicy tells what to do with a Deployment, Service or HPA already having the name
+	// of one of the Fufu's, default to IfLabelled
+	AdoptionPolicy AdoptionPolicy `json:"adoptionPolicy,omitempty"`
+
+	// Naming tells how the Fufu's children are named
+	Naming NamingSpec `json:"naming,omitempty"`
+
+	// CommonLabels are added to the Fufu's children and pods, next to the app.kubernetes.io
+	// labels. The part-of and version ones can be overridden, the selectors are left untouched
+	CommonLabels map[string]string `json:"commonLabels,omitempty"`
+	// CommonAnnotations are added to the Fufu's children and pods
+	CommonAnnotations map[string]string `json:"commonAnnotations,omitempty"`
+	// PropagatedPrefixes copy the Fufu's own labels and annotations with a key starting with
+	// one of them to its children and pods, like cost.example.com/
+	PropagatedPrefixes []string `json:"propagatedPrefixes,omitempty"`
+
+	// Hibernation puts the Fufu to sleep on a schedule: its pods are scaled to zero and its
+	// Service serves a placeholder page meanwhile
+	Hibernation *HibernationSpec `json:"hibernation,omitempty"`
+
+	// MaintenanceWindows hold the changes rolling the pods, like a new image, page content or
+	// nginx configuration, back until one of them opens. The other changes apply at once. The
+	// changes roll at once if empty
+	MaintenanceWindows []MaintenanceWindow `json:"maintenanceWindows,omitempty"`
+}
+
+// NamingSpec renders the names of the Fufu's children with text/templates given the Fufu's
+// .Name and .Namespace, and the .Suffix of the child: deploy, svc, hpa, pdb, netpol, nginx,
+// canary, blue, green, monitor or rules. A name over 63 characters is truncated and suffixed
+// with a hash of the full name
+type NamingSpec struct {
+	// Template of the names, default to {{.Name}}-{{.Suffix}}
+	Template string `json:"template,omitempty"`
+	// Overrides replace the template of the children by suffix, like svc: "{{.Name}}"
+	Overrides map[string]string `json:"overrides,omitempty"`
+}
+
+// AdoptionPolicy decides whether the Fufu takes over an object it did not create
+// +kubebuilder:validation:Enum=IfLabelled;Never;Force
+type AdoptionPolicy string
+
+const (
+	// AdoptIfLabelled adopts the objects without controller labelled with FufuLabel set to the
+	// Fufu's name
+	AdoptIfLabelled AdoptionPolicy = "IfLabelled"
+	// AdoptNever leaves alone all the objects not created by the Fufu
+	AdoptNever AdoptionPolicy = "Never"
+	// AdoptForce takes the objects over, from another controller too. A Deployment selecting
+	// other pods than the Fufu's is recreated, its selector being immutable
+	AdoptForce AdoptionPolicy = "Force"
+)
+
+// PodTemplate describes the pods running the Fufu's page
+type PodTemplate struct {
+	// Image of the web container, default to nginx
+	Image string `json:"image,omitempty"`
+	// InitImage renders the page before nginx starts, default to alpine
+	InitImage string `json:"initImage,omitempty"`
+	// Resources of the web container
+	Resources    corev1.ResourceRequirements `json:"resources,omitempty"`
+	NodeSelector map[string]string           `json:"nodeSelector,omitempty"`
+	Tolerations  []corev1.Toleration         `json:"tolerations,omitempty"`
+}
+
+// RolloutStrategy describes how the changes of the pod template are rolled out
+type RolloutStrategy struct {
+	// Canary runs the new template in a second -canary Deployment next to the stable one,
+	// growing its share of the replicas step by step before promoting it
+	Canary *CanaryStrategy `json:"canary,omitempty"`
+	// BlueGreen runs the new template in the idle one of the -blue and -green Deployments
+	// and switches the Service over once it is available
+	BlueGreen *BlueGreenStrategy `json:"blueGreen,omitempty"`
+	// AutoRollback restores the pods of the last revision that became available when the Fufu
+	// is Degraded, the changes that don't shape the pods (autoscaling, replicas, service,
+	// network policy, disruption budget, rollout, hibernation, windows) are kept
+	AutoRollback bool `json:"autoRollback,omitempty"`
+}
+
+// BlueGreenStrategy describes how the preview colour replaces the active one
+type BlueGreenStrategy struct {
+	// AutoPromote switches the Service as soon as the preview is available, default to true.
+	// Otherwise the promote annotation does
+	AutoPromote *bool `json:"autoPromote,omitempty"`
+	// ScaleDownDelaySeconds keeps the previous colour running after the switch, ready to
+	// switch back, default to 30
+	// +kubebuilder:validation:Minimum=0
+	ScaleDownDelaySeconds *int32 `json:"scaleDownDelaySeconds,omitempty"`
+}
+
+// CanaryStrategy describes the steps of a canary rollout. The nginx configuration lives in a
+// single ConfigMap, a change of it reaches the stable pods as well when they restart
+type CanaryStrategy struct {
+	// +kubebuilder:validation:MinItems=1
+	Steps []CanaryStep `json:"steps"`
+	// ProgressDeadlineSeconds aborts the rollout when a step's pods are not ready in time, default to 600
+	// +kubebuilder:validation:Minimum=1
+	ProgressDeadlineSeconds *int32 `json:"progressDeadlineSeconds,omitempty"`
+}
+
+// CanaryStep sets the share of the pods running the new template, traffic follows as the
+// stable and canary pods are behind the same Service
+type CanaryStep struct {
+	// Weight is the percentage of the pods running the new template, the canary runs at most
+	// as many pods as the stable Deployment so any weight above 50 is a half
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=99
+	Weight int32 `json:"weight"`
+	// Pause before the next step, once all the step's pods are ready
+	Pause *metav1.Duration `json:"pause,omitempty"`
+}
+
+// ServiceSpec describes the Service of the Fufu
+type ServiceSpec struct {
+	// Type default to LoadBalancer, ClusterIP is enough for the members of a FufuHousehold
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	Type corev1.ServiceType `json:"type,omitempty"`
 }
 
 type AdditionalInfo struct {
 	Breed      string `json:"breed,omitempty"`
 	Vaccinated bool   `json:"vaccinated,omitempty"`
+	// Sex picks the pronouns used on the page, they/them if empty
+	// +kubebuilder:validation:Enum=male;female
+	Sex string `json:"sex,omitempty"`
+	// Vaccinations records the shots given to the Fufu, the controller warns when one is past due
+	Vaccinations []Vaccination `json:"vaccinations,omitempty"`
+}
+
+// Vaccination is a shot of a vaccine, it has to be renewed once its validity period is over
+type Vaccination struct {
+	// Name of the vaccine, the latest administration of each vaccine is tracked
+	Name string `json:"name"`
+	// Date of administration, like 2022-05-20
+	// +kubebuilder:validation:Format=date
+	Date string `json:"date"`
+	// ValidityMonths is the number of months the vaccination protects the Fufu
+	// +kubebuilder:validation:Minimum=1
+	ValidityMonths int32 `json:"validityMonths"`
+}
+
+// Probes gathers the settings of the probes on the web container, the controller
+// generates a sensible default for any probe left empty
+type Probes struct {
+	Liveness  *ProbeSpec `json:"liveness,omitempty"`
+	Readiness *ProbeSpec `json:"readiness,omitempty"`
+	Startup   *ProbeSpec `json:"startup,omitempty"`
+}
+
+// ProbeSpec describes an HTTP GET probe against the web container's port
+type ProbeSpec struct {
+	// Disabled removes the probe from the web container
+	Disabled bool `json:"disabled,omitempty"`
+	// Path to request on the web container, default to /healthz, or / for the readiness probe
+	// unless basic auth is enabled
+	Path string `json:"path,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+}
+
+// AutoscalingSpec describes the HPA generated for the Fufu, unset fields fall back to
+// 2 to 5 replicas with a 60% CPU target
+type AutoscalingSpec struct {
+	// Enabled generates the HPA, default to true. The Fufu is sized by spec.replicas otherwise
+	Enabled *bool `json:"enabled,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	MaxReplicas int32 `json:"maxReplicas,omitempty"`
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	TargetCPUUtilizationPercentage *int32 `json:"targetCPUUtilizationPercentage,omitempty"`
+}
+
+// HibernationSpec schedules the periods the Fufu sleeps
+type HibernationSpec struct {
+	// TimeZone of the schedules, like Europe/Paris, default to UTC
+	TimeZone string `json:"timeZone,omitempty"`
+	// Windows are the periods the Fufu sleeps, it is asleep as long as one of them says so
+	Windows []HibernationWindow `json:"windows,omitempty"`
+	// Asleep puts the Fufu to sleep whatever the windows, until it is unset
+	Asleep bool `json:"asleep,omitempty"`
+}
+
+// HibernationWindow runs from a sleep to the following wake. Both are cron schedules made
+// of the minute, hour, day of month, month and day of week, like "0 20 * * 1-5"
+type HibernationWindow struct {
+	Sleep string `json:"sleep"`
+	Wake  string `json:"wake"`
 }
 
+// MaintenanceWindow opens on a cron schedule, like "0 2 * * 6", and stays open for Duration
+type MaintenanceWindow struct {
+	Start    string          `json:"start"`
+	Duration metav1.Duration `json:"duration"`
+	// TimeZone of the schedule, like Europe/Paris, default to UTC
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// DisruptionBudgetSpec describes the PodDisruptionBudget protecting the Fufu's pods
+type DisruptionBudgetSpec struct {
+	// MinAvailable is an absolute number or a percentage of pods, default to
+	// one less than the autoscaling floor
+	MinAvailable *intstr.IntOrString `json:"minAvailable,omitempty"`
+}
+
+// NetworkPolicySpec lists the sources allowed to reach the web port, everything else is denied
+type NetworkPolicySpec struct {
+	// AllowedNamespaces are the names of the namespaces whose pods are allowed
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+	// AllowedPods select the pods allowed in the Fufu's namespace
+	AllowedPods []metav1.LabelSelector `json:"allowedPods,omitempty"`
+	// ScrapingNamespaces are the names of the namespaces allowed to scrape the metrics port
+	// when monitoring is enabled, like the one of Prometheus. Any namespace may if empty
+	ScrapingNamespaces []string `json:"scrapingNamespaces,omitempty"`
+	// DenyEgress restricts the outgoing traffic to DNS, the page is rendered by the controller
+	DenyEgress bool `json:"denyEgress,omitempty"`
+}
+
+// MonitoringSpec adds an nginx-prometheus-exporter sidecar to the Fufu's pods, along with
+// a ServiceMonitor and a PrometheusRule when the prometheus-operator is installed
+type MonitoringSpec struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// ExporterImage default to nginx/nginx-prometheus-exporter:0.10.0
+	ExporterImage string `json:"exporterImage,omitempty"`
+	// Interval between two scrapes, default to 30s
+	// +kubebuilder:validation:Pattern=`^([0-9]+(ms|s|m|h))+$`
+	Interval string `json:"interval,omitempty"`
+}
+
+// NginxSpec is rendered into the server block of the web container
+type NginxSpec struct {
+	// Gzip compresses the text responses
+	Gzip bool `json:"gzip,omitempty"`
+	// CacheMaxAge sets the expiry of the page in nginx's time format, like 1h or 7d
+	// +kubebuilder:validation:Pattern=`^(([0-9]+(ms|s|m|h|d|w|M|y))+|epoch|max|off)$`
+	CacheMaxAge string `json:"cacheMaxAge,omitempty"`
+	// ErrorPages replace nginx's default error pages
+	ErrorPages []ErrorPage `json:"errorPages,omitempty"`
+	// BasicAuth protects the whole site but the health check
+	BasicAuth *BasicAuth `json:"basicAuth,omitempty"`
+	// ExtraLocations are added next to the page's location
+	ExtraLocations []NginxLocation `json:"extraLocations,omitempty"`
+}
+
+// ErrorPage is served instead of nginx's default page for the code
+type ErrorPage struct {
+	// +kubebuilder:validation:Minimum=400
+	// +kubebuilder:validation:Maximum=599
+	Code int32 `json:"code"`
+	// Content is the HTML of the page
+	Content string `json:"content"`
+}
+
+// BasicAuth refers to the credentials of the site
+type BasicAuth struct {
+	// SecretName is a secret in the Fufu's namespace holding an htpasswd file under the "auth" key
+	SecretName string `json:"secretName"`
+	// Realm default to "Fufu"
+	Realm string `json:"realm,omitempty"`
+}
+
+// NginxLocation either returns a response or proxies the requests matching the path
+type NginxLocation struct {
+	// Path is the prefix of the location, like /api
+	Path string `json:"path"`
+	// Return answers the requests directly, like "301 https://example.com"
+	Return string `json:"return,omitempty"`
+	// ProxyPass forwards the requests to the http(s) URL
+	ProxyPass string `json:"proxyPass,omitempty"`
+	// AddHeaders are added to the responses
+	AddHeaders map[string]string `json:"addHeaders,omitempty"`
+}
+
+const (
+	// ConditionReady is true when all the desired pods of the Fufu are ready to serve
+	ConditionReady = "Ready"
+	// ConditionVaccinationOverdue is true when a vaccination's validity period is over
+	ConditionVaccinationOverdue = "VaccinationOverdue"
+	// ConditionDegraded is true when the rollout of the pods is stuck, the message tells why
+	ConditionDegraded = "Degraded"
+	// ConditionOwnershipConflict is true when an object the Fufu manages exists but can't be
+	// adopted, the message tells which one and why
+	ConditionOwnershipConflict = "OwnershipConflict"
+	// ConditionHibernating is true while the Fufu sleeps, the message tells until when
+	ConditionHibernating = "Hibernating"
+	// ConditionRolloutPending is true while changes wait for a maintenance window, the message
+	// tells which ones and when the window opens. The reason InvalidWindows tells the windows
+	// are ignored
+	ConditionRolloutPending = "RolloutPending"
+)
+
+// FufuLabel set to the Fufu's name marks the objects belonging to it
+const FufuLabel = "cat.huozj.io/fufu"
+
+// RollbackToAnnotation set to a revision number, as listed in the Fufu's ControllerRevisions,
+// restores the spec recorded by that revision. The controller removes it once handled
+const RollbackToAnnotation = "cat.huozj.io/rollback-to"
+
+// PromoteAnnotation set to "true" switches a blue-green Fufu to its preview colour once
+// available, the controller removes it once handled
+const PromoteAnnotation = "cat.huozj.io/promote"
+
+// RolloutNowAnnotation set to "true" rolls the changes out without waiting for a maintenance
+// window, the controller removes it once handled
+const RolloutNowAnnotation = "cat.huozj.io/rollout-now"
+
 // FufuStatus defines the observed state of Fufu
 type FufuStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
+	// ObservedGeneration is the generation of the spec the children were last reconciled with
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// ExternalIP is the first address of the LoadBalancer, an IP or else a hostname. Kept for
+	// compatibility, prefer Endpoints
 	ExternalIP string `json:"externalIP,omitempty"`
-	Replicas   int32  `json:"replicas,omitempty"`
+	// Endpoints are the addresses the Fufu's page is reachable at through the LoadBalancer
+	Endpoints []Endpoint `json:"endpoints,omitempty"`
+	// Resources are the names of the Fufu's children, the previous ones until the children
+	// named after a new naming strategy are serving
+	Resources *ResourceNames `json:"resources,omitempty"`
+	Replicas  int32          `json:"replicas,omitempty"`
+	// Selector matches the pods of the Fufu, for the autoscalers using the scale subresource
+	Selector string `json:"selector,omitempty"`
+	// ReadyReplicas is the number of pods passing the readiness probe
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+	// Age is computed from the birth date
+	Age *AgeStatus `json:"age,omitempty"`
+	// NextVaccinationDue is the earliest due date among the Fufu's vaccinations
+	NextVaccinationDue *metav1.Time `json:"nextVaccinationDue,omitempty"`
+	// Canary reports the progress of the last canary rollout
+	Canary *CanaryStatus `json:"canary,omitempty"`
+	// BlueGreen reports the colours of a blue-green Fufu
+	BlueGreen *BlueGreenStatus `json:"blueGreen,omitempty"`
+	// CurrentRevision is the name of the ControllerRevision recording the applied spec
+	CurrentRevision string `json:"currentRevision,omitempty"`
+	// LastAvailableRevision is the last revision whose pods all became available
+	LastAvailableRevision string `json:"lastAvailableRevision,omitempty"`
+	// Autoscaling mirrors the status of the HPA
+	Autoscaling *AutoscalingStatus `json:"autoscaling,omitempty"`
+	// PodIssues summarizes why the Fufu's pods don't run, empty when they all do
+	PodIssues []PodIssue `json:"podIssues,omitempty"`
+	// Hibernation tells if the Fufu sleeps and when its windows change that
+	Hibernation *HibernationStatus `json:"hibernation,omitempty"`
+	// Maintenance tells when the maintenance windows open and the changes waiting for them
+	Maintenance *MaintenanceStatus `json:"maintenance,omitempty"`
+
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// CanaryPhase is the stage of a canary rollout
+// +kubebuilder:validation:Enum=Progressing;Paused;Promoting;Promoted;Aborted
+type CanaryPhase string
+
+const (
+	// CanaryProgressing waits for the pods of the current step to be ready
+	CanaryProgressing CanaryPhase = "Progressing"
+	// CanaryPaused waits for the pause of the current step to be over
+	CanaryPaused CanaryPhase = "Paused"
+	// CanaryPromoting rolls the new template out to the stable Deployment
+	CanaryPromoting CanaryPhase = "Promoting"
+	CanaryPromoted  CanaryPhase = "Promoted"
+	// CanaryAborted leaves the stable Deployment as it was until the template changes again
+	CanaryAborted CanaryPhase = "Aborted"
+)
+
+// CanaryStatus is the progress of a canary rollout
+type CanaryStatus struct {
+	// Revision is the hash of the pod template rolled out
+	Revision string      `json:"revision"`
+	Phase    CanaryPhase `json:"phase"`
+	// Step is the index of the current step
+	Step          int32 `json:"step"`
+	Weight        int32 `json:"weight,omitempty"`
+	Replicas      int32 `json:"replicas,omitempty"`
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+	// StepStartedAt is when the current step started, its pods have to be ready before the deadline
+	StepStartedAt *metav1.Time `json:"stepStartedAt,omitempty"`
+	// StepReadyAt is when all the pods of the current step became ready, the pause starts then
+	StepReadyAt *metav1.Time `json:"stepReadyAt,omitempty"`
+	Message     string       `json:"message,omitempty"`
+}
+
+// ResourceNames are the names of the children of a Fufu, the optional ones are empty unless
+// the spec asks for them
+type ResourceNames struct {
+	ConfigMap               string `json:"configMap"`
+	Deployment              string `json:"deployment"`
+	Service                 string `json:"service"`
+	HorizontalPodAutoscaler string `json:"horizontalPodAutoscaler,omitempty"`
+	PodDisruptionBudget     string `json:"podDisruptionBudget,omitempty"`
+	NetworkPolicy           string `json:"networkPolicy,omitempty"`
+	ServiceMonitor          string `json:"serviceMonitor,omitempty"`
+	PrometheusRule          string `json:"prometheusRule,omitempty"`
+	Placeholder             string `json:"placeholder,omitempty"`
+}
+
+// Endpoint is an address of the LoadBalancer, either an IP or a hostname
+type Endpoint struct {
+	IP       string `json:"ip,omitempty"`
+	Hostname string `json:"hostname,omitempty"`
+	Port     int32  `json:"port"`
+	// URL of the Fufu's page
+	URL string `json:"url"`
+}
+
+// HibernationStatus is the state of a Fufu scheduled to sleep
+type HibernationStatus struct {
+	Asleep bool `json:"asleep"`
+	// WakingUp is true once awake while the placeholder still serves, until the pods are available
+	WakingUp bool `json:"wakingUp,omitempty"`
+	// NextTransition is when a window next wakes the Fufu up or puts it to sleep
+	NextTransition *metav1.Time `json:"nextTransition,omitempty"`
+}
+
+// MaintenanceStatus is the state of a Fufu with maintenance windows
+type MaintenanceStatus struct {
+	InWindow bool `json:"inWindow"`
+	// NextWindow is when a window next opens
+	NextWindow *metav1.Time `json:"nextWindow,omitempty"`
+	// PendingChanges roll the pods once a window opens
+	PendingChanges []string `json:"pendingChanges,omitempty"`
+}
+
+// AutoscalingStatus is the scaling activity of the Fufu's HPA
+type AutoscalingStatus struct {
+	CurrentReplicas int32 `json:"currentReplicas"`
+	DesiredReplicas int32 `json:"desiredReplicas"`
+	// Metrics compares the current values of the metrics to their targets
+	Metrics []MetricStatus `json:"metrics,omitempty"`
+	// LastScaleTime is when the HPA last changed the number of replicas
+	LastScaleTime *metav1.Time `json:"lastScaleTime,omitempty"`
+	// Conditions are the HPA's: AbleToScale, ScalingActive and ScalingLimited
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// MetricStatus is a metric watched by the HPA, values are formatted like in kubectl get hpa
+type MetricStatus struct {
+	Name    string `json:"name"`
+	Current string `json:"current,omitempty"`
+	Target  string `json:"target"`
+}
+
+// PodIssue gathers the pods failing for the same reason
+type PodIssue struct {
+	// Reason like ImagePullBackOff, CrashLoopBackOff, OOMKilled or Unschedulable
+	Reason string `json:"reason"`
+	// Container failing, empty when the pod itself is
+	Container string `json:"container,omitempty"`
+	// Count is the number of pods having the issue
+	Count int32 `json:"count"`
+	// Message is the latest one reported, from the newest pod
+	Message string `json:"message,omitempty"`
+}
+
+// BlueGreenStatus tells which colour serves the Fufu
+type BlueGreenStatus struct {
+	// ActiveColor is selected by the Service, none until the first Deployment is available
+	ActiveColor string `json:"activeColor,omitempty"`
+	// ActiveRevision is the hash of the active colour's pod template
+	ActiveRevision string `json:"activeRevision,omitempty"`
+	// PreviewColor runs the new template until promoted, or the previous one after a switch
+	PreviewColor    string `json:"previewColor,omitempty"`
+	PreviewRevision string `json:"previewRevision,omitempty"`
+	// ScaleDownAt is when the previous colour is scaled down
+	ScaleDownAt *metav1.Time `json:"scaleDownAt,omitempty"`
+	Message     string       `json:"message,omitempty"`
+}
+
+// AgeStatus is the age of the Fufu in complete years and months
+type AgeStatus struct {
+	Years  int32 `json:"years"`
+	Months int32 `json:"months"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:subresource:scale:specpath=.spec.replicas,statuspath=.status.replicas,selectorpath=.status.selector
 //+kubebuilder:printcolumn:name="Color",type=string,JSONPath=`.spec.color`
 //+kubebuilder:printcolumn:name="Replicas",type=string,JSONPath=`.status.replicas`
+//+kubebuilder:printcolumn:name="Current",type=integer,JSONPath=`.status.autoscaling.currentReplicas`
+//+kubebuilder:printcolumn:name="Desired",type=integer,JSONPath=`.status.autoscaling.desiredReplicas`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Class",type=string,JSONPath=`.spec.className`,priority=1
+//+kubebuilder:printcolumn:name="Canary",type=string,JSONPath=`.status.canary.phase`,priority=1
+//+kubebuilder:printcolumn:name="Active",type=string,JSONPath=`.status.blueGreen.activeColor`,priority=1
+//+kubebuilder:printcolumn:name="Revision",type=string,JSONPath=`.status.currentRevision`,priority=1
+//+kubebuilder:printcolumn:name="Asleep",type=boolean,JSONPath=`.status.hibernation.asleep`,priority=1
 //+kubebuilder:printcolumn:name="ExternalIP",type=string,JSONPath=`.status.externalIP`
 
 // Fufu is the Schema for the fufus API
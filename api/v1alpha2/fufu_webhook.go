@@ -0,0 +1,203 @@
+/*
+Copyright 2022 huo.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apivalidation "k8s.io/apimachinery/pkg/api/validation"
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var fufulog = logf.Log.WithName("fufu-resource")
+
+func (r *Fufu) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/validate-cat-huozj-io-v1alpha2-fufu,mutating=false,failurePolicy=fail,sideEffects=None,groups=cat.huozj.io,resources=fufus,verbs=create;update,versions=v1alpha2,name=vfufu.kb.io,admissionReviewVersions=v1
+
+var _ webhook.Validator = &Fufu{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Fufu) ValidateCreate() error {
+	fufulog.Info("validate create", "name", r.Name)
+
+	return r.validateFufu()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Fufu) ValidateUpdate(old runtime.Object) error {
+	fufulog.Info("validate update", "name", r.Name)
+
+	return r.validateFufu()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Fufu) ValidateDelete() error {
+	return nil
+}
+
+func (r *Fufu) validateFufu() error {
+	var allErrs field.ErrorList
+	allErrs = append(allErrs, r.Spec.Nginx.validate(field.NewPath("spec").Child("nginx"))...)
+	allErrs = append(allErrs, r.Spec.Naming.validate(r, field.NewPath("spec").Child("naming"))...)
+	allErrs = append(allErrs, r.Spec.validateCommonMetadata(field.NewPath("spec"))...)
+	if r.Spec.Hibernation != nil {
+		allErrs = append(allErrs, r.Spec.Hibernation.validate(field.NewPath("spec").Child("hibernation"))...)
+	}
+	for i := range r.Spec.MaintenanceWindows {
+		allErrs = append(allErrs, r.Spec.MaintenanceWindows[i].validate(field.NewPath("spec").Child("maintenanceWindows").Index(i))...)
+	}
+
+	if r.Spec.RolloutStrategy.Canary != nil && r.Spec.RolloutStrategy.BlueGreen != nil {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec").Child("rolloutStrategy"), "canary and blueGreen are mutually exclusive"))
+	}
+
+	if r.Spec.BirthDate != "" {
+		p := field.NewPath("spec").Child("birthDate")
+		if birth, err := time.Parse("2006-01-02", r.Spec.BirthDate); err != nil {
+			allErrs = append(allErrs, field.Invalid(p, r.Spec.BirthDate, "must be a date like 2016-04-01"))
+		} else if birth.After(time.Now()) {
+			allErrs = append(allErrs, field.Invalid(p, r.Spec.BirthDate, "must not be in the future"))
+		}
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(GroupVersion.WithKind("Fufu").GroupKind(), r.Name, allErrs)
+}
+
+// labels the controller relies on, they can't be set by the common labels
+var reservedLabels = []string{"app", "app.kubernetes.io/name", "app.kubernetes.io/instance", "app.kubernetes.io/managed-by"}
+
+// validateCommonMetadata makes sure the labels and annotations propagated to the children
+// are valid, and leave the controller's own labels alone
+func (s *FufuSpec) validateCommonMetadata(path *field.Path) field.ErrorList {
+	allErrs := metav1validation.ValidateLabels(s.CommonLabels, path.Child("commonLabels"))
+	for _, key := range reservedLabels {
+		if _, ok := s.CommonLabels[key]; ok {
+			allErrs = append(allErrs, field.Forbidden(path.Child("commonLabels").Key(key), "is set by the controller"))
+		}
+	}
+	allErrs = append(allErrs, apivalidation.ValidateAnnotations(s.CommonAnnotations, path.Child("commonAnnotations"))...)
+
+	for i, prefix := range s.PropagatedPrefixes {
+		if prefix == "" {
+			allErrs = append(allErrs, field.Required(path.Child("propagatedPrefixes").Index(i), "would propagate all the labels and annotations"))
+		}
+	}
+
+	return allErrs
+}
+
+var (
+	// paths already served by the generated configuration
+	reservedPaths = []string{"/", "/healthz", "/_errors/"}
+	returnRegexp  = regexp.MustCompile(`^[1-5][0-9]{2}( .+)?$`)
+	headerRegexp  = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+)
+
+// validate makes sure the spec renders into a configuration accepted by nginx, user input
+// can't break out of the directive it's written in
+func (n *NginxSpec) validate(path *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	codes := map[int32]bool{}
+	for i, page := range n.ErrorPages {
+		p := path.Child("errorPages").Index(i)
+		if codes[page.Code] {
+			allErrs = append(allErrs, field.Duplicate(p.Child("code"), page.Code))
+		}
+		codes[page.Code] = true
+		if page.Code < 400 || page.Code > 599 {
+			allErrs = append(allErrs, field.Invalid(p.Child("code"), page.Code, "must be an error status code between 400 and 599"))
+		}
+	}
+
+	if n.BasicAuth != nil {
+		p := path.Child("basicAuth")
+		for _, msg := range validation.IsDNS1123Subdomain(n.BasicAuth.SecretName) {
+			allErrs = append(allErrs, field.Invalid(p.Child("secretName"), n.BasicAuth.SecretName, msg))
+		}
+		allErrs = append(allErrs, validateDirectiveValue(p.Child("realm"), n.BasicAuth.Realm)...)
+	}
+
+	paths := map[string]bool{}
+	for i, loc := range n.ExtraLocations {
+		p := path.Child("extraLocations").Index(i)
+
+		switch {
+		case !strings.HasPrefix(loc.Path, "/"):
+			allErrs = append(allErrs, field.Invalid(p.Child("path"), loc.Path, "must start with /"))
+		case strings.ContainsAny(loc.Path, " \t"):
+			allErrs = append(allErrs, field.Invalid(p.Child("path"), loc.Path, "must not contain spaces"))
+		case paths[loc.Path]:
+			allErrs = append(allErrs, field.Duplicate(p.Child("path"), loc.Path))
+		}
+		for _, reserved := range reservedPaths {
+			if loc.Path == reserved {
+				allErrs = append(allErrs, field.Invalid(p.Child("path"), loc.Path, "is reserved by the generated configuration"))
+			}
+		}
+		paths[loc.Path] = true
+		allErrs = append(allErrs, validateDirectiveValue(p.Child("path"), loc.Path)...)
+
+		switch {
+		case (loc.Return == "") == (loc.ProxyPass == ""):
+			allErrs = append(allErrs, field.Required(p, "exactly one of return and proxyPass must be set"))
+		case loc.Return != "" && !returnRegexp.MatchString(loc.Return):
+			allErrs = append(allErrs, field.Invalid(p.Child("return"), loc.Return, "must start with a status code"))
+		case loc.ProxyPass != "" && !strings.HasPrefix(loc.ProxyPass, "http://") && !strings.HasPrefix(loc.ProxyPass, "https://"):
+			allErrs = append(allErrs, field.Invalid(p.Child("proxyPass"), loc.ProxyPass, "must be an http(s) URL"))
+		}
+		allErrs = append(allErrs, validateDirectiveValue(p.Child("return"), loc.Return)...)
+		allErrs = append(allErrs, validateDirectiveValue(p.Child("proxyPass"), loc.ProxyPass)...)
+
+		for k, v := range loc.AddHeaders {
+			if !headerRegexp.MatchString(k) {
+				allErrs = append(allErrs, field.Invalid(p.Child("addHeaders").Key(k), k, "must be a valid header name"))
+			}
+			allErrs = append(allErrs, validateDirectiveValue(p.Child("addHeaders").Key(k), v)...)
+		}
+	}
+
+	return allErrs
+}
+
+// validateDirectiveValue rejects the characters ending a directive or a block
+func validateDirectiveValue(path *field.Path, value string) field.ErrorList {
+	if strings.ContainsAny(value, "\";{}\\\n\r") {
+		return field.ErrorList{field.Invalid(path, value, `must not contain any of ";{}\ or line breaks`)}
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2022 huo.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateNginx(t *testing.T) {
+	cases := []struct {
+		name    string
+		nginx   NginxSpec
+		wantErr bool
+	}{
+		{
+			name: "empty spec",
+		},
+		{
+			name: "full spec",
+			nginx: NginxSpec{
+				Gzip:        true,
+				CacheMaxAge: "1h",
+				ErrorPages:  []ErrorPage{{Code: 404, Content: "<h1>Fufu is hiding</h1>"}},
+				BasicAuth:   &BasicAuth{SecretName: "fufu-htpasswd", Realm: "Fufu's home"},
+				ExtraLocations: []NginxLocation{
+					{Path: "/repo", Return: "301 https://github.com/ZhengjunHUO/kubebuilder"},
+					{Path: "/api/", ProxyPass: "http://api.default.svc", AddHeaders: map[string]string{"X-Cat": "fufu"}},
+				},
+			},
+		},
+		{
+			name:    "duplicated error page",
+			nginx:   NginxSpec{ErrorPages: []ErrorPage{{Code: 404}, {Code: 404}}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid secret name",
+			nginx:   NginxSpec{BasicAuth: &BasicAuth{SecretName: "Fufu_Secret"}},
+			wantErr: true,
+		},
+		{
+			name:    "realm breaking out of the directive",
+			nginx:   NginxSpec{BasicAuth: &BasicAuth{SecretName: "fufu", Realm: `fufu"; autoindex on; "`}},
+			wantErr: true,
+		},
+		{
+			name:    "reserved path",
+			nginx:   NginxSpec{ExtraLocations: []NginxLocation{{Path: "/healthz", Return: "200"}}},
+			wantErr: true,
+		},
+		{
+			name:    "relative path",
+			nginx:   NginxSpec{ExtraLocations: []NginxLocation{{Path: "repo", Return: "200"}}},
+			wantErr: true,
+		},
+		{
+			name:    "both return and proxy_pass",
+			nginx:   NginxSpec{ExtraLocations: []NginxLocation{{Path: "/repo", Return: "200", ProxyPass: "http://fufu"}}},
+			wantErr: true,
+		},
+		{
+			name:    "return without status code",
+			nginx:   NginxSpec{ExtraLocations: []NginxLocation{{Path: "/repo", Return: "https://github.com"}}},
+			wantErr: true,
+		},
+		{
+			name:    "header opening a block",
+			nginx:   NginxSpec{ExtraLocations: []NginxLocation{{Path: "/repo", Return: "204", AddHeaders: map[string]string{"X-Cat": "}"}}}},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fufu := &Fufu{
+				ObjectMeta: metav1.ObjectMeta{Name: "fufu"},
+				Spec:       FufuSpec{Nginx: c.nginx},
+			}
+			if err := fufu.ValidateCreate(); (err != nil) != c.wantErr {
+				t.Errorf("ValidateCreate() error = %v, wantErr %v", err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateCommonMetadata(t *testing.T) {
+	cases := []struct {
+		name    string
+		spec    FufuSpec
+		wantErr bool
+	}{
+		{
+			name: "empty spec",
+		},
+		{
+			name: "full spec",
+			spec: FufuSpec{
+				CommonLabels:       map[string]string{"team": "cats", "app.kubernetes.io/part-of": "pets"},
+				CommonAnnotations:  map[string]string{"description": "orange cat"},
+				PropagatedPrefixes: []string{"cost.example.com/"},
+			},
+		},
+		{
+			name:    "reserved label",
+			spec:    FufuSpec{CommonLabels: map[string]string{"app.kubernetes.io/instance": "fufu"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid label value",
+			spec:    FufuSpec{CommonLabels: map[string]string{"team": "orange cats"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid annotation key",
+			spec:    FufuSpec{CommonAnnotations: map[string]string{"orange cat": "fufu"}},
+			wantErr: true,
+		},
+		{
+			name:    "empty prefix",
+			spec:    FufuSpec{PropagatedPrefixes: []string{""}},
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fufu := &Fufu{ObjectMeta: metav1.ObjectMeta{Name: "fufu"}, Spec: c.spec}
+			if err := fufu.ValidateCreate(); (err != nil) != c.wantErr {
+				t.Errorf("ValidateCreate() error = %v, wantErr %v", err, c.wantErr)
+			}
+		})
+	}
+}
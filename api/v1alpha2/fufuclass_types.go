@@ -0,0 +1,60 @@
+/*
+Copyright 2022 huo.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultClassAnnotation set to "true" marks the FufuClass used by the Fufus without className,
+// like ingressclass.kubernetes.io/is-default-class
+const DefaultClassAnnotation = "fufuclass.cat.huozj.io/is-default-class"
+
+// FufuClassSpec defines the defaults applied to the Fufus of the class, the fields set in
+// the Fufu's spec take precedence
+type FufuClassSpec struct {
+	Template    PodTemplate     `json:"template,omitempty"`
+	Service     ServiceSpec     `json:"service,omitempty"`
+	Autoscaling AutoscalingSpec `json:"autoscaling,omitempty"`
+	Naming      NamingSpec      `json:"naming,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:printcolumn:name="Default",type=string,JSONPath=`.metadata.annotations.fufuclass\.cat\.huozj\.io/is-default-class`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// FufuClass is the Schema for the fufuclasses API
+type FufuClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec FufuClassSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// FufuClassList contains a list of FufuClass
+type FufuClassList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []FufuClass `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&FufuClass{}, &FufuClassList{})
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2022 huo.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FufuHouseholdSpec defines the desired state of FufuHousehold
+type FufuHouseholdSpec struct {
+	// Selector picks the member Fufus in the household's namespace
+	Selector metav1.LabelSelector `json:"selector"`
+	// Title of the index page
+	Title string `json:"title,omitempty"`
+	// ServiceType of the household's entrypoint, default to LoadBalancer
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	ServiceType corev1.ServiceType `json:"serviceType,omitempty"`
+	// Ingress exposes the entrypoint through an Ingress as well
+	Ingress *HouseholdIngress `json:"ingress,omitempty"`
+}
+
+// HouseholdIngress describes the Ingress routing to the household's entrypoint
+type HouseholdIngress struct {
+	ClassName *string `json:"className,omitempty"`
+	// Host matched by the Ingress, any host if empty
+	Host string `json:"host,omitempty"`
+}
+
+// FufuHouseholdStatus defines the observed state of FufuHousehold
+type FufuHouseholdStatus struct {
+	MemberCount  int32 `json:"memberCount,omitempty"`
+	ReadyMembers int32 `json:"readyMembers,omitempty"`
+	// Members are sorted by name
+	Members []HouseholdMember `json:"members,omitempty"`
+}
+
+// HouseholdMember is a Fufu selected by the household
+type HouseholdMember struct {
+	Name string `json:"name"`
+	// Path of the Fufu's page behind the household's entrypoint
+	Path string `json:"path"`
+	// Service the path is proxied to
+	Service string `json:"service,omitempty"`
+	Ready   bool   `json:"ready"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Members",type=integer,JSONPath=`.status.memberCount`
+//+kubebuilder:printcolumn:name="Ready",type=integer,JSONPath=`.status.readyMembers`
+
+// FufuHousehold is the Schema for the fufuhouseholds API
+type FufuHousehold struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   FufuHouseholdSpec   `json:"spec,omitempty"`
+	Status FufuHouseholdStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// FufuHouseholdList contains a list of FufuHousehold
+type FufuHouseholdList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []FufuHousehold `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&FufuHousehold{}, &FufuHouseholdList{})
+}
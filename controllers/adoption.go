@@ -0,0 +1,177 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrutil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appsv1 "k8s.io/api/apps/v1"
+	asv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+const (
+	// adoptionRetryInterval is how often a conflicting object is checked again, in case its
+	// controller let it go
+	adoptionRetryInterval = time.Minute
+	// recreateDelay leaves the time to a Deployment adopted by force to be deleted
+	recreateDelay = 5 * time.Second
+)
+
+// adoptChildren makes sure the Deployment, Service and HPA named after the Fufu are its own
+// before they are reconciled, adopting the pre-existing ones the policy allows. The children
+// are left alone as long as it returns a delay to retry after
+func (r *FufuReconciler) adoptChildren(fufu *catv1alpha2.Fufu, ctx context.Context) (time.Duration, error) {
+	loggr := log.FromContext(ctx)
+
+	deploy := r.createDeploy(fufu)
+	type child struct {
+		kind     string
+		obj      client.Object
+		name     string
+		selector *metav1.LabelSelector
+	}
+	children := []child{
+		{"Deployment", &appsv1.Deployment{}, deploy.Name, deploy.Spec.Selector},
+		{"Service", &corev1.Service{}, r.createSvc(fufu).Name, nil},
+	}
+	if autoscaled(fufu) {
+		children = append(children, child{"HorizontalPodAutoscaler", &asv2.HorizontalPodAutoscaler{}, r.createHpa(fufu).Name, nil})
+	}
+
+	var retry time.Duration
+	var reason string
+	var conflicts []string
+	for _, c := range children {
+		if err := r.Get(ctx, types.NamespacedName{Name: c.name, Namespace: fufu.Namespace}, c.obj); err != nil {
+			if err = client.IgnoreNotFound(err); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if metav1.IsControlledBy(c.obj, fufu) {
+			continue
+		}
+
+		recreate, why, message := adoption(fufu, c.kind, c.obj, c.selector)
+		switch {
+		case why != "":
+			if reason == "" {
+				reason = why
+			}
+			conflicts = append(conflicts, message)
+			retry = adoptionRetryInterval
+		case recreate:
+			loggr.Info(fmt.Sprintf("Recreate %s %s to adopt it ...", c.kind, c.name))
+			if err := r.Delete(ctx, c.obj); err != nil {
+				return 0, client.IgnoreNotFound(err)
+			}
+			r.Recorder.Eventf(fufu, corev1.EventTypeNormal, "adopted", "%s %s deleted to be recreated, its selector can't be changed", c.kind, c.name)
+			if retry == 0 {
+				retry = recreateDelay
+			}
+		default:
+			message := fmt.Sprintf("Adopted %s %s", c.kind, c.name)
+			if owner := metav1.GetControllerOf(c.obj); owner != nil {
+				message = fmt.Sprintf("Took %s %s over from %s %s", c.kind, c.name, owner.Kind, owner.Name)
+			}
+			loggr.Info(message)
+			c.obj.SetOwnerReferences(withoutController(c.obj.GetOwnerReferences()))
+			if err := ctrutil.SetControllerReference(fufu, c.obj, r.Scheme); err != nil {
+				return 0, err
+			}
+			if err := r.Update(ctx, c.obj); err != nil {
+				return 0, err
+			}
+			r.Recorder.Event(fufu, corev1.EventTypeNormal, "adopted", message)
+		}
+	}
+
+	cond := metav1.Condition{
+		Type:               catv1alpha2.ConditionOwnershipConflict,
+		Status:             metav1.ConditionFalse,
+		Reason:             "NoConflict",
+		Message:            "The Fufu owns all its objects",
+		ObservedGeneration: fufu.Generation,
+	}
+	if len(conflicts) > 0 {
+		cond.Status, cond.Reason, cond.Message = metav1.ConditionTrue, reason, strings.Join(conflicts, "; ")
+	}
+
+	prev := meta.FindStatusCondition(fufu.Status.Conditions, catv1alpha2.ConditionOwnershipConflict)
+	if cond.Status == metav1.ConditionTrue && (prev == nil || prev.Status != cond.Status || prev.Message != cond.Message) {
+		loggr.Info(fmt.Sprintf("Adoption refused: %s", cond.Message))
+		r.Recorder.Event(fufu, corev1.EventTypeWarning, "adoption-refused", cond.Message)
+	}
+	meta.SetStatusCondition(&fufu.Status.Conditions, cond)
+
+	return retry, nil
+}
+
+// adoption tells whether the Fufu may take over obj, not controlled by it, according to its
+// adoption policy: if not, why and the message explaining the conflict. A Deployment selecting
+// other pods than the Fufu's has to be recreated, only adopting by force does
+func adoption(fufu *catv1alpha2.Fufu, kind string, obj client.Object, selector *metav1.LabelSelector) (bool, string, string) {
+	policy := fufu.Spec.AdoptionPolicy
+	if policy == "" {
+		policy = catv1alpha2.AdoptIfLabelled
+	}
+
+	// left by a deleted Fufu of the same name, not collected yet
+	owner := metav1.GetControllerOf(obj)
+	inherited := owner != nil && owner.Kind == "Fufu" && owner.Name == fufu.Name
+
+	if policy != catv1alpha2.AdoptForce && !inherited {
+		if owner != nil {
+			return false, "OwnedByOther", fmt.Sprintf("%s %s is controlled by %s %s", kind, obj.GetName(), owner.Kind, owner.Name)
+		}
+		if policy == catv1alpha2.AdoptNever {
+			return false, "AdoptionDisabled", fmt.Sprintf("%s %s was not created by the Fufu and the adoption policy is %s", kind, obj.GetName(), policy)
+		}
+		if obj.GetLabels()[catv1alpha2.FufuLabel] != fufu.Name {
+			return false, "NotLabelled", fmt.Sprintf("%s %s was not created by the Fufu, label it with %s=%s to adopt it", kind, obj.GetName(), catv1alpha2.FufuLabel, fufu.Name)
+		}
+	}
+
+	if deploy, ok := obj.(*appsv1.Deployment); ok && selector != nil && !equality.Semantic.DeepEqual(deploy.Spec.Selector, selector) {
+		if policy != catv1alpha2.AdoptForce && !inherited {
+			return false, "SelectorMismatch", fmt.Sprintf("Deployment %s selects other pods than the Fufu's and its selector can't be changed, adopt it by force to recreate it", obj.GetName())
+		}
+		return true, "", ""
+	}
+
+	return false, "", ""
+}
+
+// withoutController drops the reference to the controller of an object taken over
+func withoutController(refs []metav1.OwnerReference) []metav1.OwnerReference {
+	kept := []metav1.OwnerReference{}
+	for _, ref := range refs {
+		if ref.Controller == nil || !*ref.Controller {
+			kept = append(kept, ref)
+		}
+	}
+	return kept
+}
+
+// findFufuForLabelled maps an object labelled to be adopted to its Fufu, not owning it yet
+func (r *FufuReconciler) findFufuForLabelled(obj client.Object) []reconcile.Request {
+	name := obj.GetLabels()[catv1alpha2.FufuLabel]
+	if name == "" {
+		return nil
+	}
+
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: name, Namespace: obj.GetNamespace()}}}
+}
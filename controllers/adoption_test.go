@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+func TestAdoption(t *testing.T) {
+	controller := true
+	owned := []metav1.OwnerReference{{Kind: "Rollout", Name: "web", Controller: &controller}}
+	labelled := map[string]string{catv1alpha2.FufuLabel: "fufu"}
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "fufu-deploy"}}
+
+	svc := func(labels map[string]string, refs []metav1.OwnerReference) client.Object {
+		return &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "fufu-svc", Labels: labels, OwnerReferences: refs}}
+	}
+	deploy := func(labels map[string]string, app string) client.Object {
+		d := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "fufu-deploy", Labels: labels}}
+		d.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}}
+		return d
+	}
+
+	cases := []struct {
+		name         string
+		policy       catv1alpha2.AdoptionPolicy
+		obj          client.Object
+		wantRecreate bool
+		wantReason   string
+	}{
+		{
+			name:       "not labelled",
+			obj:        svc(nil, nil),
+			wantReason: "NotLabelled",
+		},
+		{
+			name:       "labelled for another fufu",
+			obj:        svc(map[string]string{catv1alpha2.FufuLabel: "other"}, nil),
+			wantReason: "NotLabelled",
+		},
+		{
+			name: "labelled",
+			obj:  svc(labelled, nil),
+		},
+		{
+			name:       "labelled but owned by another controller",
+			obj:        svc(labelled, owned),
+			wantReason: "OwnedByOther",
+		},
+		{
+			name: "left by a previous fufu of the same name",
+			obj:  svc(nil, []metav1.OwnerReference{{Kind: "Fufu", Name: "fufu", UID: "previous", Controller: &controller}}),
+		},
+		{
+			name:       "never",
+			policy:     catv1alpha2.AdoptNever,
+			obj:        svc(labelled, nil),
+			wantReason: "AdoptionDisabled",
+		},
+		{
+			name:   "force from another controller",
+			policy: catv1alpha2.AdoptForce,
+			obj:    svc(nil, owned),
+		},
+		{
+			name: "labelled deploy selecting the fufu's pods",
+			obj:  deploy(labelled, "fufu-deploy"),
+		},
+		{
+			name:       "labelled deploy selecting other pods",
+			obj:        deploy(labelled, "web"),
+			wantReason: "SelectorMismatch",
+		},
+		{
+			name:         "deploy selecting other pods by force",
+			policy:       catv1alpha2.AdoptForce,
+			obj:          deploy(nil, "web"),
+			wantRecreate: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fufu := &catv1alpha2.Fufu{ObjectMeta: metav1.ObjectMeta{Name: "fufu"}}
+			fufu.Spec.AdoptionPolicy = c.policy
+
+			kind := "Service"
+			if _, ok := c.obj.(*appsv1.Deployment); ok {
+				kind = "Deployment"
+			}
+
+			recreate, reason, message := adoption(fufu, kind, c.obj, selector)
+			if recreate != c.wantRecreate {
+				t.Errorf("recreate %v, want %v", recreate, c.wantRecreate)
+			}
+			if reason != c.wantReason {
+				t.Errorf("reason %q, want %q", reason, c.wantReason)
+			}
+			if (message == "") != (reason == "") {
+				t.Errorf("message %q for reason %q", message, reason)
+			}
+		})
+	}
+}
+
+func TestWithoutController(t *testing.T) {
+	controller, notController := true, false
+	refs := []metav1.OwnerReference{
+		{Kind: "Rollout", Name: "web", Controller: &controller},
+		{Kind: "ConfigMap", Name: "web", Controller: &notController},
+		{Kind: "Secret", Name: "web"},
+	}
+
+	kept := withoutController(refs)
+	if len(kept) != 2 || kept[0].Kind != "ConfigMap" || kept[1].Kind != "Secret" {
+		t.Errorf("kept %+v, want the references which are not controllers", kept)
+	}
+}
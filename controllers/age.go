@@ -0,0 +1,85 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+// ageAt returns the number of complete months lived since birth, along with the date
+// the next month is complete
+func ageAt(birth, now time.Time) (int, time.Time) {
+	months := (now.Year()-birth.Year())*12 + int(now.Month()-birth.Month())
+	// AddDate normalizes the end of the months, stick to it to stay consistent with the next date
+	for months > 0 && birth.AddDate(0, months, 0).After(now) {
+		months--
+	}
+	for !birth.AddDate(0, months+1, 0).After(now) {
+		months++
+	}
+	if months < 0 {
+		months = 0
+	}
+
+	return months, birth.AddDate(0, months+1, 0)
+}
+
+// updateAge computes Fufu's age from its birth date, it returns the delay until
+// the age changes again, 0 if the Fufu has no birth date
+func (r *FufuReconciler) updateAge(fufu *catv1alpha2.Fufu, now time.Time) (time.Duration, error) {
+	if fufu.Spec.BirthDate == "" {
+		fufu.Status.Age = nil
+		return 0, nil
+	}
+
+	birth, err := time.Parse(dateLayout, fufu.Spec.BirthDate)
+	if err != nil {
+		return 0, fmt.Errorf("invalid birth date: %w", err)
+	}
+
+	months, next := ageAt(birth, now)
+	age := &catv1alpha2.AgeStatus{
+		Years:  int32(months / 12),
+		Months: int32(months % 12),
+	}
+
+	if prev := fufu.Status.Age; prev != nil && age.Years > prev.Years {
+		r.Recorder.Eventf(fufu, corev1.EventTypeNormal, "birthday", "Happy birthday! %s is now %s old", fufu.Name, formatAge(age.Years, age.Months))
+	}
+	fufu.Status.Age = age
+
+	return next.Sub(now), nil
+}
+
+// pageAge is the age shown on the page, only the months are shown for a kitten
+func pageAge(fufu *catv1alpha2.Fufu) string {
+	if age := fufu.Status.Age; fufu.Spec.BirthDate != "" && age != nil {
+		if age.Years == 0 {
+			return formatAge(0, age.Months)
+		}
+		return formatAge(age.Years, 0)
+	}
+
+	return formatAge(int32(fufu.Spec.Age), 0)
+}
+
+func formatAge(years, months int32) string {
+	plural := func(n int32, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("%d %s", n, unit)
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+
+	switch {
+	case years > 0 && months > 0:
+		return plural(years, "year") + " and " + plural(months, "month")
+	case years > 0:
+		return plural(years, "year")
+	default:
+		return plural(months, "month")
+	}
+}
@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/record"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+func TestUpdateAge(t *testing.T) {
+	day := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	cases := []struct {
+		name        string
+		birthDate   string
+		now         time.Time
+		prev        *catv1alpha2.AgeStatus
+		want        *catv1alpha2.AgeStatus
+		wantRequeue time.Duration
+		wantEvent   bool
+	}{
+		{
+			name: "static age only",
+			now:  day(2022, time.June, 1),
+		},
+		{
+			name:        "kitten",
+			birthDate:   "2022-01-15",
+			now:         day(2022, time.June, 1),
+			want:        &catv1alpha2.AgeStatus{Years: 0, Months: 4},
+			wantRequeue: day(2022, time.June, 15).Sub(day(2022, time.June, 1)),
+		},
+		{
+			name:        "birthday",
+			birthDate:   "2016-06-01",
+			now:         day(2022, time.June, 1).Add(time.Hour),
+			prev:        &catv1alpha2.AgeStatus{Years: 5, Months: 11},
+			want:        &catv1alpha2.AgeStatus{Years: 6, Months: 0},
+			wantRequeue: day(2022, time.July, 1).Sub(day(2022, time.June, 1).Add(time.Hour)),
+			wantEvent:   true,
+		},
+		{
+			name:        "the day before the birthday",
+			birthDate:   "2016-06-01",
+			now:         day(2022, time.May, 31),
+			want:        &catv1alpha2.AgeStatus{Years: 5, Months: 11},
+			wantRequeue: 24 * time.Hour,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			r := &FufuReconciler{Recorder: recorder}
+			fufu := &catv1alpha2.Fufu{}
+			fufu.Spec.BirthDate = c.birthDate
+			fufu.Status.Age = c.prev
+
+			requeue, err := r.updateAge(fufu, c.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if requeue != c.wantRequeue {
+				t.Errorf("requeue after %v, want %v", requeue, c.wantRequeue)
+			}
+			if (fufu.Status.Age == nil) != (c.want == nil) || (c.want != nil && *fufu.Status.Age != *c.want) {
+				t.Errorf("age %+v, want %+v", fufu.Status.Age, c.want)
+			}
+			if gotEvent := len(recorder.Events) > 0; gotEvent != c.wantEvent {
+				t.Errorf("birthday event %v, want %v", gotEvent, c.wantEvent)
+			}
+		})
+	}
+}
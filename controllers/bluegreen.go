@@ -0,0 +1,266 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+const (
+	colorLabel = "cat.huozj.io/color"
+	blue       = "blue"
+	green      = "green"
+
+	defaultScaleDownDelay = 30 * time.Second
+)
+
+func colorName(fufu *catv1alpha2.Fufu, color string) string {
+	return childName(fufu, color)
+}
+
+func otherColor(color string) string {
+	if color == blue {
+		return green
+	}
+	return blue
+}
+
+// activeColor is the colour selected by the Service, empty unless the Fufu is blue-green and
+// one of its colours has been available
+func activeColor(fufu *catv1alpha2.Fufu) string {
+	if fufu.Spec.RolloutStrategy.BlueGreen == nil || fufu.Status.BlueGreen == nil {
+		return ""
+	}
+	return fufu.Status.BlueGreen.ActiveColor
+}
+
+// switchingToBlueGreen tells if the first preview of a Fufu leaving its plain Deployment has
+// started, the Service stays on the plain pods until the switch
+func switchingToBlueGreen(fufu *catv1alpha2.Fufu) bool {
+	st := fufu.Status.BlueGreen
+	return fufu.Spec.RolloutStrategy.BlueGreen != nil && st != nil && st.ActiveColor == "" && st.PreviewColor != ""
+}
+
+// servingDeployName is the Deployment serving the Fufu, scaled by the HPA
+func servingDeployName(fufu *catv1alpha2.Fufu) string {
+	if color := activeColor(fufu); color != "" {
+		return colorName(fufu, color)
+	}
+	return childName(fufu, catv1alpha2.SuffixDeploy)
+}
+
+// deployRolling tells if the Deployment still replaces pods, once its controller has seen it
+func deployRolling(d *appsv1.Deployment) bool {
+	return d.Status.ObservedGeneration > 0 &&
+		(d.Status.ObservedGeneration < d.Generation || d.Status.UpdatedReplicas < d.Status.Replicas)
+}
+
+func (r *FufuReconciler) createColorDeploy(fufu *catv1alpha2.Fufu, wanted *appsv1.Deployment, color string, replicas int32) *appsv1.Deployment {
+	deploy := variantDeploy(wanted, colorName(fufu, color), colorLabel, color)
+	deploy.Spec.Replicas = &replicas
+
+	return deploy
+}
+
+// updateBlueGreen runs a new template in the preview colour, sized like the serving
+// Deployment, and makes it the active colour once available
+func (r *FufuReconciler) updateBlueGreen(fufu *catv1alpha2.Fufu, now time.Time, ctx context.Context) (time.Duration, error) {
+	loggr := log.FromContext(ctx)
+	strategy := fufu.Spec.RolloutStrategy.BlueGreen
+
+	wanted := r.createDeploy(fufu)
+	hash := hashTemplate(&wanted.Spec.Template)
+
+	st := fufu.Status.BlueGreen
+	if st == nil {
+		st = &catv1alpha2.BlueGreenStatus{}
+		fufu.Status.BlueGreen = st
+	}
+
+	// the active colour, or the plain Deployment of a Fufu switching to blue-green
+	serving := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Name: servingDeployName(fufu), Namespace: fufu.Namespace}, serving); err != nil {
+		if err = client.IgnoreNotFound(err); err != nil {
+			return 0, err
+		}
+		serving = nil
+	}
+	// the HPA, if any, scales the serving Deployment
+	replicas, _ := hpaBounds(fufu)
+	if fixed := fixedReplicas(fufu); fixed != nil {
+		replicas = *fixed
+	} else if serving != nil && serving.Spec.Replicas != nil && *serving.Spec.Replicas > 0 {
+		replicas = *serving.Spec.Replicas
+	}
+	if serving != nil {
+		r.observeDeploy(fufu, serving, ctx)
+	}
+
+	// the plain pods created by an older controller lack the stable track the Service pins
+	// during the switch, they get it before the preview goes on and the Service pins them
+	if st.ActiveColor == "" && serving != nil {
+		if serving.Spec.Template.Labels[trackLabel] != stableTrack {
+			loggr.Info("Label the plain pods as stable ...")
+			if serving.Spec.Template.Labels == nil {
+				serving.Spec.Template.Labels = map[string]string{}
+			}
+			serving.Spec.Template.Labels[trackLabel] = stableTrack
+			if err := r.Update(ctx, serving); err != nil {
+				return 0, err
+			}
+			st.PreviewColor, st.PreviewRevision = "", ""
+		}
+		if st.PreviewColor == "" && deployRolling(serving) {
+			st.Message = "Waiting for the plain pods to roll out before the preview"
+			return 0, nil
+		}
+	}
+
+	// a new template waits for the maintenance window, the preview under way goes on
+	if st.ActiveColor != "" && st.ActiveRevision != hash && st.PreviewRevision != hash && serving != nil {
+		active := r.createColorDeploy(fufu, wanted, st.ActiveColor, replicas)
+		if templateChanged(active, serving) && r.deferRollout(fufu, templateChanges(active, serving)...) {
+			wanted.Spec.Template = *serving.Spec.Template.DeepCopy()
+			hash = st.ActiveRevision
+		}
+	}
+
+	if st.ActiveColor != "" && st.ActiveRevision == hash {
+		// nothing to roll out, keep the active colour in line with the spec
+		if _, err := r.applyVariantDeploy(fufu, r.createColorDeploy(fufu, wanted, st.ActiveColor, replicas), ctx); err != nil {
+			return 0, err
+		}
+		if err := r.dropPromoteAnnotation(fufu, ctx); err != nil {
+			return 0, err
+		}
+		return r.scaleDownPrevious(fufu, now, ctx)
+	}
+
+	preview := otherColor(st.ActiveColor)
+	if st.PreviewColor != preview || st.PreviewRevision != hash {
+		loggr.Info(fmt.Sprintf("Run revision %s in %s ...", hash, preview))
+		st.PreviewColor, st.PreviewRevision, st.ScaleDownAt = preview, hash, nil
+		r.Recorder.Eventf(fufu, corev1.EventTypeNormal, "preview-started", "Preview %s runs revision %s", preview, hash)
+	}
+
+	deploy, err := r.applyVariantDeploy(fufu, r.createColorDeploy(fufu, wanted, preview, replicas), ctx)
+	if err != nil {
+		return 0, err
+	}
+	if serving == nil {
+		r.observeDeploy(fufu, deploy, ctx)
+	}
+
+	if !deployAvailable(deploy) {
+		st.Message = fmt.Sprintf("%d of %d %s pods available", deploy.Status.AvailableReplicas, replicas, preview)
+		return 0, nil
+	}
+	if strategy.AutoPromote != nil && !*strategy.AutoPromote && fufu.Annotations[catv1alpha2.PromoteAnnotation] != "true" {
+		st.Message = fmt.Sprintf("Preview %s available, waiting for the %s annotation", preview, catv1alpha2.PromoteAnnotation)
+		return 0, nil
+	}
+
+	delay := defaultScaleDownDelay
+	if strategy.ScaleDownDelaySeconds != nil {
+		delay = time.Duration(*strategy.ScaleDownDelaySeconds) * time.Second
+	}
+
+	loggr.Info(fmt.Sprintf("Switch svc to %s ...", preview))
+	st.PreviewColor, st.PreviewRevision = st.ActiveColor, st.ActiveRevision
+	st.ActiveColor, st.ActiveRevision = preview, hash
+	st.ScaleDownAt = &metav1.Time{Time: now.Add(delay)}
+	st.Message = fmt.Sprintf("Switched to %s", preview)
+	r.Recorder.Eventf(fufu, corev1.EventTypeNormal, "bluegreen-switched", "Service switched to %s", preview)
+	r.observeDeploy(fufu, deploy, ctx)
+
+	if err := r.dropPromoteAnnotation(fufu, ctx); err != nil {
+		return 0, err
+	}
+	return delay, nil
+}
+
+// scaleDownPrevious scales the previous colour down once the delay after the switch is over
+func (r *FufuReconciler) scaleDownPrevious(fufu *catv1alpha2.Fufu, now time.Time, ctx context.Context) (time.Duration, error) {
+	loggr := log.FromContext(ctx)
+
+	st := fufu.Status.BlueGreen
+	if st.ScaleDownAt == nil {
+		return 0, nil
+	}
+	if left := st.ScaleDownAt.Sub(now); left > 0 {
+		return left, nil
+	}
+
+	if st.PreviewColor != "" {
+		previous := &appsv1.Deployment{}
+		if err := r.Get(ctx, types.NamespacedName{Name: colorName(fufu, st.PreviewColor), Namespace: fufu.Namespace}, previous); err == nil {
+			loggr.Info(fmt.Sprintf("Scale %s down ...", st.PreviewColor))
+			var zero int32
+			previous.Spec.Replicas = &zero
+			if err := r.Update(ctx, previous); err != nil {
+				return 0, err
+			}
+		} else if err = client.IgnoreNotFound(err); err != nil {
+			return 0, err
+		}
+	}
+
+	// the plain Deployment is only left by a Fufu switching to blue-green
+	legacy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      childName(fufu, catv1alpha2.SuffixDeploy),
+			Namespace: fufu.Namespace,
+		},
+	}
+	if err := client.IgnoreNotFound(r.Delete(ctx, legacy)); err != nil {
+		return 0, err
+	}
+
+	st.ScaleDownAt = nil
+	r.Recorder.Event(fufu, corev1.EventTypeNormal, "previous-scaled-down", "Previous colour scaled down")
+	return 0, nil
+}
+
+// dropPromoteAnnotation removes the handled promote annotation
+func (r *FufuReconciler) dropPromoteAnnotation(fufu *catv1alpha2.Fufu, ctx context.Context) error {
+	if _, ok := fufu.Annotations[catv1alpha2.PromoteAnnotation]; !ok {
+		return nil
+	}
+
+	return r.patchAnnotation(fufu, catv1alpha2.PromoteAnnotation, nil, ctx)
+}
+
+// finishBlueGreen removes both colours once the plain Deployment of a Fufu leaving
+// blue-green has taken over
+func (r *FufuReconciler) finishBlueGreen(fufu *catv1alpha2.Fufu, ctx context.Context) error {
+	if fufu.Status.BlueGreen == nil || !meta.IsStatusConditionTrue(fufu.Status.Conditions, catv1alpha2.ConditionReady) {
+		return nil
+	}
+
+	for _, color := range []string{blue, green} {
+		deploy := &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      colorName(fufu, color),
+				Namespace: fufu.Namespace,
+			},
+		}
+		if err := client.IgnoreNotFound(r.Delete(ctx, deploy)); err != nil {
+			return err
+		}
+	}
+
+	fufu.Status.BlueGreen = nil
+	r.Recorder.Event(fufu, corev1.EventTypeNormal, "bluegreen-removed", "Blue and green Deployments removed")
+	return nil
+}
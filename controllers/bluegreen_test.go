@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+func TestBlueGreenSelection(t *testing.T) {
+	cases := []struct {
+		name          string
+		strategy      *catv1alpha2.BlueGreenStrategy
+		status        *catv1alpha2.BlueGreenStatus
+		wantDeploy    string
+		wantSvcColour string
+		wantSvcTrack  string
+	}{
+		{
+			name:       "plain deployment",
+			wantDeploy: "fufu-deploy",
+		},
+		{
+			name:       "blue-green before the preview",
+			strategy:   &catv1alpha2.BlueGreenStrategy{},
+			status:     &catv1alpha2.BlueGreenStatus{},
+			wantDeploy: "fufu-deploy",
+		},
+		{
+			name:         "switching to blue-green",
+			strategy:     &catv1alpha2.BlueGreenStrategy{},
+			status:       &catv1alpha2.BlueGreenStatus{PreviewColor: blue},
+			wantDeploy:   "fufu-deploy",
+			wantSvcTrack: stableTrack,
+		},
+		{
+			name:          "green active",
+			strategy:      &catv1alpha2.BlueGreenStrategy{},
+			status:        &catv1alpha2.BlueGreenStatus{ActiveColor: green, PreviewColor: blue},
+			wantDeploy:    "fufu-green",
+			wantSvcColour: green,
+		},
+		{
+			name:       "leaving blue-green",
+			status:     &catv1alpha2.BlueGreenStatus{ActiveColor: green},
+			wantDeploy: "fufu-deploy",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fufu := &catv1alpha2.Fufu{ObjectMeta: metav1.ObjectMeta{Name: "fufu", Namespace: "default"}}
+			fufu.Spec.RolloutStrategy.BlueGreen = c.strategy
+			fufu.Status.BlueGreen = c.status
+
+			if got := servingDeployName(fufu); got != c.wantDeploy {
+				t.Errorf("serving deploy = %s, want %s", got, c.wantDeploy)
+			}
+			svc := (&FufuReconciler{}).createSvc(fufu)
+			if got := svc.Spec.Selector[colorLabel]; got != c.wantSvcColour {
+				t.Errorf("svc selects colour %q, want %q", got, c.wantSvcColour)
+			}
+			if got := svc.Spec.Selector[trackLabel]; got != c.wantSvcTrack {
+				t.Errorf("svc selects track %q, want %q", got, c.wantSvcTrack)
+			}
+			if svc.Spec.Selector["app"] != "fufu-deploy" {
+				t.Errorf("svc should select the fufu's pods")
+			}
+		})
+	}
+}
+
+func TestPreviewOutOfStableTrack(t *testing.T) {
+	fufu := &catv1alpha2.Fufu{ObjectMeta: metav1.ObjectMeta{Name: "fufu", Namespace: "default"}}
+	fufu.Spec.RolloutStrategy.BlueGreen = &catv1alpha2.BlueGreenStrategy{}
+	fufu.Status.BlueGreen = &catv1alpha2.BlueGreenStatus{PreviewColor: blue}
+	r := &FufuReconciler{}
+
+	plain := r.createDeploy(fufu)
+	preview := r.createColorDeploy(fufu, plain, blue, 1)
+	selector := labels.SelectorFromSet(r.createSvc(fufu).Spec.Selector)
+
+	if !selector.Matches(labels.Set(plain.Spec.Template.Labels)) {
+		t.Errorf("svc should select the plain pods during the switch")
+	}
+	if selector.Matches(labels.Set(preview.Spec.Template.Labels)) {
+		t.Errorf("svc should leave the preview pods out until the switch")
+	}
+}
@@ -0,0 +1,229 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrutil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+const (
+	// trackLabel tells the canary pods apart, the stable Deployment's selector matches them
+	// too but their ReplicaSet is owned by the canary Deployment so it is left alone
+	trackLabel  = "cat.huozj.io/track"
+	canaryTrack = "canary"
+	// stableTrack marks the pods of the plain Deployment, the Service pins them while a Fufu
+	// switches to blue-green
+	stableTrack = "stable"
+
+	defaultProgressDeadline = 600 * time.Second
+)
+
+func canaryName(fufu *catv1alpha2.Fufu) string {
+	return childName(fufu, catv1alpha2.SuffixCanary)
+}
+
+func hashTemplate(tmpl *corev1.PodTemplateSpec) string {
+	raw, _ := json.Marshal(tmpl)
+	sum := sha256.Sum256(raw)
+
+	return hex.EncodeToString(sum[:])[:10]
+}
+
+// canaryReplicas sizes the canary so it runs weight percent of all the pods, the stable
+// Deployment keeps the replicas set by the HPA. The canary never outgrows the stable pods,
+// a weight of 99 would otherwise run 99 canary pods for each stable one
+func canaryReplicas(stable, weight int32) int32 {
+	replicas := (stable*weight + (100 - weight) - 1) / (100 - weight)
+	if replicas > stable {
+		replicas = stable
+	}
+	if replicas < 1 {
+		replicas = 1
+	}
+
+	return replicas
+}
+
+// updateCanary rolls the template of wanted out through the canary Deployment instead of
+// updating had, the stable one, and promotes it once all the steps are done
+func (r *FufuReconciler) updateCanary(fufu *catv1alpha2.Fufu, wanted, had *appsv1.Deployment, now time.Time, ctx context.Context) (time.Duration, error) {
+	loggr := log.FromContext(ctx)
+	strategy := fufu.Spec.RolloutStrategy.Canary
+
+	hash := hashTemplate(&wanted.Spec.Template)
+	st := fufu.Status.Canary
+	if st == nil || st.Revision != hash {
+		loggr.Info(fmt.Sprintf("Start canary rollout of revision %s ...", hash))
+		st = &catv1alpha2.CanaryStatus{
+			Revision:      hash,
+			Phase:         catv1alpha2.CanaryProgressing,
+			StepStartedAt: &metav1.Time{Time: now},
+		}
+		fufu.Status.Canary = st
+		r.Recorder.Eventf(fufu, corev1.EventTypeNormal, "canary-started", "Canary rollout of revision %s started", hash)
+	}
+
+	switch {
+	case st.Phase == catv1alpha2.CanaryAborted:
+		return 0, nil
+	case st.Phase == catv1alpha2.CanaryPromoting || int(st.Step) >= len(strategy.Steps):
+		return 0, r.promoteCanary(fufu, wanted, had, ctx)
+	}
+
+	step := strategy.Steps[st.Step]
+	var stable int32 = 1
+	if had.Spec.Replicas != nil {
+		stable = *had.Spec.Replicas
+	}
+	canary, err := r.applyVariantDeploy(fufu, r.createCanaryDeploy(fufu, wanted, canaryReplicas(stable, step.Weight)), ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	st.Weight = step.Weight
+	st.Replicas = *canary.Spec.Replicas
+	st.ReadyReplicas = canary.Status.ReadyReplicas
+
+	if !deployAvailable(canary) {
+		deadline := defaultProgressDeadline
+		if strategy.ProgressDeadlineSeconds != nil {
+			deadline = time.Duration(*strategy.ProgressDeadlineSeconds) * time.Second
+		}
+
+		left := st.StepStartedAt.Add(deadline).Sub(now)
+		if left <= 0 {
+			return 0, r.abortCanary(fufu, fmt.Sprintf("Step %d not ready after %s", st.Step+1, deadline), ctx)
+		}
+		st.Phase = catv1alpha2.CanaryProgressing
+		st.Message = fmt.Sprintf("Step %d: %d of %d canary pods ready", st.Step+1, st.ReadyReplicas, st.Replicas)
+		return left, nil
+	}
+
+	if st.StepReadyAt == nil {
+		st.StepReadyAt = &metav1.Time{Time: now}
+	}
+	if step.Pause != nil {
+		if left := st.StepReadyAt.Add(step.Pause.Duration).Sub(now); left > 0 {
+			st.Phase = catv1alpha2.CanaryPaused
+			st.Message = fmt.Sprintf("Step %d: paused for %s", st.Step+1, left.Round(time.Second))
+			return left, nil
+		}
+	}
+
+	st.Step++
+	st.StepStartedAt, st.StepReadyAt = &metav1.Time{Time: now}, nil
+	st.Phase = catv1alpha2.CanaryProgressing
+	st.Message = fmt.Sprintf("Step %d of %d done", st.Step, len(strategy.Steps))
+	r.Recorder.Eventf(fufu, corev1.EventTypeNormal, "canary-step", "Canary running %d%% of the pods is ready", step.Weight)
+
+	// the next step starts right away
+	return time.Second, nil
+}
+
+func (r *FufuReconciler) createCanaryDeploy(fufu *catv1alpha2.Fufu, wanted *appsv1.Deployment, replicas int32) *appsv1.Deployment {
+	canary := variantDeploy(wanted, canaryName(fufu), trackLabel, canaryTrack)
+	canary.Spec.Replicas = &replicas
+
+	return canary
+}
+
+// promoteCanary rolls the new template out to the stable Deployment, the canary is removed
+// by finishCanary once the stable pods are all updated
+func (r *FufuReconciler) promoteCanary(fufu *catv1alpha2.Fufu, wanted, had *appsv1.Deployment, ctx context.Context) error {
+	loggr := log.FromContext(ctx)
+
+	loggr.Info("Promote canary, update deploy ...")
+	// the canary pods stay up meanwhile, keep the stable ones scaled as they were
+	wanted.Spec.Replicas = had.Spec.Replicas
+	markApplied(wanted, had)
+	ctrutil.SetControllerReference(fufu, wanted, r.Scheme)
+	if err := r.Update(ctx, wanted); err != nil {
+		return err
+	}
+
+	fufu.Status.Canary.Phase = catv1alpha2.CanaryPromoting
+	fufu.Status.Canary.Message = "Rolling the new template out to the stable pods"
+	r.childUpdated(fufu, false, "deploy-updated", "Deployment updated with the canary's template")
+	return nil
+}
+
+func (r *FufuReconciler) abortCanary(fufu *catv1alpha2.Fufu, reason string, ctx context.Context) error {
+	loggr := log.FromContext(ctx)
+
+	loggr.Info(fmt.Sprintf("Abort canary: %s", reason))
+	if err := r.deleteCanaryDeploy(fufu, ctx); err != nil {
+		return err
+	}
+
+	fufu.Status.Canary.Phase = catv1alpha2.CanaryAborted
+	fufu.Status.Canary.Message = reason
+	fufu.Status.Canary.Replicas, fufu.Status.Canary.ReadyReplicas = 0, 0
+	r.Recorder.Eventf(fufu, corev1.EventTypeWarning, "canary-aborted", "Canary rollout aborted: %s", reason)
+	return nil
+}
+
+// canaryInFlight tells if a canary rollout is under way, it goes on outside the maintenance
+// windows once started
+func canaryInFlight(fufu *catv1alpha2.Fufu) bool {
+	if fufu.Spec.RolloutStrategy.Canary == nil || fufu.Status.Canary == nil {
+		return false
+	}
+
+	switch fufu.Status.Canary.Phase {
+	case catv1alpha2.CanaryProgressing, catv1alpha2.CanaryPaused, catv1alpha2.CanaryPromoting:
+		return true
+	}
+	return false
+}
+
+// finishCanary cleans up once the stable Deployment follows the spec: the promoted canary
+// is removed when the stable pods are all updated, an ongoing one is dropped
+func (r *FufuReconciler) finishCanary(fufu *catv1alpha2.Fufu, had *appsv1.Deployment, ctx context.Context) error {
+	st := fufu.Status.Canary
+	if st == nil {
+		return nil
+	}
+
+	switch st.Phase {
+	case catv1alpha2.CanaryPromoting:
+		if !deployAvailable(had) {
+			return nil
+		}
+
+		if err := r.deleteCanaryDeploy(fufu, ctx); err != nil {
+			return err
+		}
+		st.Phase = catv1alpha2.CanaryPromoted
+		st.Message = "The stable pods run the new template"
+		st.Replicas, st.ReadyReplicas = 0, 0
+		r.Recorder.Eventf(fufu, corev1.EventTypeNormal, "canary-promoted", "Canary revision %s promoted", st.Revision)
+	case catv1alpha2.CanaryProgressing, catv1alpha2.CanaryPaused:
+		return r.abortCanary(fufu, "The stable Deployment follows the spec again", ctx)
+	}
+
+	return nil
+}
+
+func (r *FufuReconciler) deleteCanaryDeploy(fufu *catv1alpha2.Fufu, ctx context.Context) error {
+	canary := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      canaryName(fufu),
+			Namespace: fufu.Namespace,
+		},
+	}
+
+	return client.IgnoreNotFound(r.Delete(ctx, canary))
+}
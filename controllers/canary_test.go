@@ -0,0 +1,51 @@
+package controllers
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+func TestCanaryReplicas(t *testing.T) {
+	cases := []struct {
+		stable, weight, want int32
+	}{
+		{stable: 4, weight: 20, want: 1},
+		{stable: 4, weight: 50, want: 4},
+		{stable: 3, weight: 50, want: 3},
+		{stable: 5, weight: 25, want: 2},
+		{stable: 2, weight: 1, want: 1},
+		{stable: 1, weight: 90, want: 1},
+		{stable: 5, weight: 99, want: 5},
+		{stable: 4, weight: 60, want: 4},
+		{stable: 0, weight: 99, want: 1},
+	}
+
+	for _, c := range cases {
+		if got := canaryReplicas(c.stable, c.weight); got != c.want {
+			t.Errorf("canaryReplicas(%d, %d) = %d, want %d", c.stable, c.weight, got, c.want)
+		}
+	}
+}
+
+func TestCreateCanaryDeploy(t *testing.T) {
+	fufu := &catv1alpha2.Fufu{ObjectMeta: metav1.ObjectMeta{Name: "fufu", Namespace: "default"}}
+	r := &FufuReconciler{}
+	stable := r.createDeploy(fufu)
+
+	canary := r.createCanaryDeploy(fufu, stable, 2)
+	if canary.Name != "fufu-canary" || *canary.Spec.Replicas != 2 {
+		t.Errorf("unexpected canary %s with %d replicas", canary.Name, *canary.Spec.Replicas)
+	}
+	if canary.Spec.Template.Labels["app"] != "fufu-deploy" {
+		t.Errorf("canary pods should be selected by the service")
+	}
+	if canary.Spec.Selector.MatchLabels[trackLabel] != canaryTrack || canary.Spec.Template.Labels[trackLabel] != canaryTrack {
+		t.Errorf("canary pods should be told apart from the stable ones")
+	}
+	if stable.Spec.Template.Labels[trackLabel] != stableTrack {
+		t.Errorf("the stable template should be left unchanged")
+	}
+}
@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	corev1 "k8s.io/api/core/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+// applyClass merges the Fufu's class into its spec, the merged spec is only kept in memory
+// to generate the children and never written back
+func (r *FufuReconciler) applyClass(fufu *catv1alpha2.Fufu, ctx context.Context) error {
+	loggr := log.FromContext(ctx)
+
+	class, err := r.getClass(fufu.Spec.ClassName, ctx)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		// the Fufu is reconciled again once the class is created
+		loggr.Info(fmt.Sprintf("FufuClass %s not found, fall back to the Fufu's own spec", fufu.Spec.ClassName))
+		r.Recorder.Eventf(fufu, corev1.EventTypeWarning, "class-not-found", "FufuClass %s not found", fufu.Spec.ClassName)
+		return nil
+	}
+	if class == nil {
+		return nil
+	}
+
+	mergeClass(&fufu.Spec, &class.Spec)
+	// the default class is named in the merged spec, the children are labeled with it
+	fufu.Spec.ClassName = class.Name
+	return nil
+}
+
+// getClass returns the named class, or the default one if name is empty (nil if there is none)
+func (r *FufuReconciler) getClass(name string, ctx context.Context) (*catv1alpha2.FufuClass, error) {
+	if name != "" {
+		class := &catv1alpha2.FufuClass{}
+		if err := r.Get(ctx, types.NamespacedName{Name: name}, class); err != nil {
+			return nil, err
+		}
+		return class, nil
+	}
+
+	classes := &catv1alpha2.FufuClassList{}
+	if err := r.List(ctx, classes); err != nil {
+		return nil, err
+	}
+
+	return defaultClass(classes.Items), nil
+}
+
+// defaultClass picks the most recently created of the classes marked as default, like the
+// default IngressClass when more than one is marked
+func defaultClass(classes []catv1alpha2.FufuClass) *catv1alpha2.FufuClass {
+	var found *catv1alpha2.FufuClass
+	for i := range classes {
+		class := &classes[i]
+		if class.Annotations[catv1alpha2.DefaultClassAnnotation] != "true" {
+			continue
+		}
+		if found == nil || found.CreationTimestamp.Before(&class.CreationTimestamp) ||
+			(found.CreationTimestamp.Equal(&class.CreationTimestamp) && class.Name < found.Name) {
+			found = class
+		}
+	}
+
+	return found
+}
+
+// mergeClass fills the fields left empty in the Fufu's spec with the class' defaults
+func mergeClass(spec *catv1alpha2.FufuSpec, class *catv1alpha2.FufuClassSpec) {
+	tmpl, classTmpl := &spec.Template, &class.Template
+	if tmpl.Image == "" {
+		tmpl.Image = classTmpl.Image
+	}
+	if tmpl.InitImage == "" {
+		tmpl.InitImage = classTmpl.InitImage
+	}
+	if tmpl.Resources.Limits == nil && tmpl.Resources.Requests == nil {
+		tmpl.Resources = *classTmpl.Resources.DeepCopy()
+	}
+	if tmpl.NodeSelector == nil && classTmpl.NodeSelector != nil {
+		tmpl.NodeSelector = make(map[string]string, len(classTmpl.NodeSelector))
+		for k, v := range classTmpl.NodeSelector {
+			tmpl.NodeSelector[k] = v
+		}
+	}
+	if tmpl.Tolerations == nil {
+		tmpl.Tolerations = append(tmpl.Tolerations, classTmpl.Tolerations...)
+	}
+
+	if spec.Service.Type == "" {
+		spec.Service.Type = class.Service.Type
+	}
+
+	as, classAs := &spec.Autoscaling, &class.Autoscaling
+	if as.Enabled == nil && classAs.Enabled != nil {
+		enabled := *classAs.Enabled
+		as.Enabled = &enabled
+	}
+	if as.MinReplicas == nil && classAs.MinReplicas != nil {
+		min := *classAs.MinReplicas
+		as.MinReplicas = &min
+	}
+	if as.MaxReplicas == 0 {
+		as.MaxReplicas = classAs.MaxReplicas
+	}
+	if as.TargetCPUUtilizationPercentage == nil && classAs.TargetCPUUtilizationPercentage != nil {
+		cpu := *classAs.TargetCPUUtilizationPercentage
+		as.TargetCPUUtilizationPercentage = &cpu
+	}
+
+	naming, classNaming := &spec.Naming, &class.Naming
+	if naming.Template == "" {
+		naming.Template = classNaming.Template
+	}
+	for suffix, tmpl := range classNaming.Overrides {
+		if _, ok := naming.Overrides[suffix]; !ok {
+			if naming.Overrides == nil {
+				naming.Overrides = map[string]string{}
+			}
+			naming.Overrides[suffix] = tmpl
+		}
+	}
+}
+
+// findFufusForClass maps a FufuClass to the Fufus referencing it. The Fufus without className
+// are always enqueued: the class may have just been marked, or unmarked, as the default one
+func (r *FufuReconciler) findFufusForClass(obj client.Object) []reconcile.Request {
+	fufus := &catv1alpha2.FufuList{}
+	if err := r.List(context.Background(), fufus); err != nil {
+		return nil
+	}
+
+	reqs := []reconcile.Request{}
+	for _, fufu := range fufus.Items {
+		if fufu.Spec.ClassName == obj.GetName() || fufu.Spec.ClassName == "" {
+			reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Name: fufu.Name, Namespace: fufu.Namespace}})
+		}
+	}
+
+	return reqs
+}
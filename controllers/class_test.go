@@ -0,0 +1,144 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+func TestMergeClass(t *testing.T) {
+	int32Ptr := func(v int32) *int32 { return &v }
+	boolPtr := func(v bool) *bool { return &v }
+
+	class := catv1alpha2.FufuClassSpec{
+		Template: catv1alpha2.PodTemplate{
+			Image:        "nginx:1.23",
+			InitImage:    "alpine:3.16",
+			NodeSelector: map[string]string{"pool": "cats"},
+			Resources: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+			},
+		},
+		Service: catv1alpha2.ServiceSpec{Type: corev1.ServiceTypeClusterIP},
+		Autoscaling: catv1alpha2.AutoscalingSpec{
+			Enabled:                        boolPtr(false),
+			MinReplicas:                    int32Ptr(3),
+			MaxReplicas:                    10,
+			TargetCPUUtilizationPercentage: int32Ptr(80),
+		},
+		Naming: catv1alpha2.NamingSpec{
+			Template:  "cat-{{.Name}}-{{.Suffix}}",
+			Overrides: map[string]string{catv1alpha2.SuffixService: "{{.Name}}", catv1alpha2.SuffixHpa: "{{.Name}}-autoscaler"},
+		},
+	}
+
+	cases := []struct {
+		name string
+		spec catv1alpha2.FufuSpec
+		want catv1alpha2.FufuSpec
+	}{
+		{
+			name: "empty spec takes the class",
+			want: catv1alpha2.FufuSpec{
+				Template:    class.Template,
+				Service:     class.Service,
+				Autoscaling: class.Autoscaling,
+				Naming:      class.Naming,
+			},
+		},
+		{
+			name: "spec takes precedence",
+			spec: catv1alpha2.FufuSpec{
+				Template: catv1alpha2.PodTemplate{
+					Image:        "nginx:1.22",
+					NodeSelector: map[string]string{},
+				},
+				Service:     catv1alpha2.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+				Autoscaling: catv1alpha2.AutoscalingSpec{Enabled: boolPtr(true), MaxReplicas: 4},
+				Naming:      catv1alpha2.NamingSpec{Overrides: map[string]string{catv1alpha2.SuffixService: "{{.Name}}-web"}},
+			},
+			want: catv1alpha2.FufuSpec{
+				Template: catv1alpha2.PodTemplate{
+					Image:        "nginx:1.22",
+					InitImage:    "alpine:3.16",
+					NodeSelector: map[string]string{},
+					Resources:    class.Template.Resources,
+				},
+				Service: catv1alpha2.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+				Autoscaling: catv1alpha2.AutoscalingSpec{
+					Enabled:                        boolPtr(true),
+					MinReplicas:                    int32Ptr(3),
+					MaxReplicas:                    4,
+					TargetCPUUtilizationPercentage: int32Ptr(80),
+				},
+				Naming: catv1alpha2.NamingSpec{
+					Template:  "cat-{{.Name}}-{{.Suffix}}",
+					Overrides: map[string]string{catv1alpha2.SuffixService: "{{.Name}}-web", catv1alpha2.SuffixHpa: "{{.Name}}-autoscaler"},
+				},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			mergeClass(&c.spec, class.DeepCopy())
+			if !equality.Semantic.DeepEqual(c.spec, c.want) {
+				t.Errorf("merged spec = %+v, want %+v", c.spec, c.want)
+			}
+		})
+	}
+}
+
+func TestDefaultClass(t *testing.T) {
+	class := func(name string, created time.Time, isDefault bool) catv1alpha2.FufuClass {
+		c := catv1alpha2.FufuClass{ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			CreationTimestamp: metav1.NewTime(created),
+		}}
+		if isDefault {
+			c.Annotations = map[string]string{catv1alpha2.DefaultClassAnnotation: "true"}
+		}
+		return c
+	}
+	t0 := time.Date(2022, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name    string
+		classes []catv1alpha2.FufuClass
+		want    string
+	}{
+		{
+			name:    "no default",
+			classes: []catv1alpha2.FufuClass{class("small", t0, false)},
+		},
+		{
+			name:    "single default",
+			classes: []catv1alpha2.FufuClass{class("small", t0, false), class("standard", t0, true)},
+			want:    "standard",
+		},
+		{
+			name:    "most recent default wins",
+			classes: []catv1alpha2.FufuClass{class("standard", t0.Add(time.Hour), true), class("old", t0, true)},
+			want:    "standard",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := ""
+			if found := defaultClass(c.classes); found != nil {
+				got = found.Name
+			}
+			if got != c.want {
+				t.Errorf("default class = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
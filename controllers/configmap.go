@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrutil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+const (
+	nginxConfFile = "default.conf"
+	// stub_status is only served on the loopback, for the exporter sidecar
+	stubStatusURI = "http://127.0.0.1:8080/stub_status"
+)
+
+var nginxConfTmpl = template.Must(template.New(nginxConfFile).Parse(`server {
+    listen       80;
+    listen  [::]:80;
+    server_name  localhost;
+{{- with .Nginx }}
+{{- if .Gzip }}
+
+    gzip            on;
+    gzip_vary       on;
+    gzip_min_length 256;
+    gzip_types      text/plain text/css text/xml application/json application/javascript application/xml image/svg+xml;
+{{- end }}
+{{- with .BasicAuth }}
+
+    auth_basic           "{{ if .Realm }}{{ .Realm }}{{ else }}Fufu{{ end }}";
+    auth_basic_user_file /etc/nginx/auth/auth;
+{{- end }}
+{{- end }}
+
+    location / {
+        root   /usr/share/nginx/html;
+        index  index.html index.htm;
+{{- with .Nginx.CacheMaxAge }}
+        expires {{ . }};
+        add_header Cache-Control "public";
+{{- end }}
+    }
+
+    location = /healthz {
+        auth_basic off;
+        access_log off;
+        default_type text/plain;
+        return 200 "ok";
+    }
+{{- range .Nginx.ExtraLocations }}
+
+    location {{ .Path }} {
+{{- range $k, $v := .AddHeaders }}
+        add_header {{ $k }} "{{ $v }}";
+{{- end }}
+{{- if .Return }}
+        return {{ .Return }};
+{{- else }}
+        proxy_pass {{ .ProxyPass }};
+{{- end }}
+    }
+{{- end }}
+{{- range .Nginx.ErrorPages }}
+
+    error_page {{ .Code }} /_errors/{{ .Code }}.html;
+    location = /_errors/{{ .Code }}.html {
+        internal;
+        auth_basic off;
+        alias /etc/nginx/conf.d/error-{{ .Code }}.html;
+    }
+{{- end }}
+{{- if .DefaultErrorCodes }}
+
+    # redirect server error pages to the static page /50x.html
+    error_page   {{ range .DefaultErrorCodes }}{{ . }} {{ end }} /50x.html;
+    location = /50x.html {
+        root   /usr/share/nginx/html;
+    }
+{{- end }}
+}
+{{- if .StubStatus }}
+
+server {
+    listen       127.0.0.1:8080;
+
+    location = /stub_status {
+        stub_status;
+    }
+}
+{{- end }}
+`))
+
+func (r *FufuReconciler) updateConfigMap(fufu *catv1alpha2.Fufu, ctx context.Context) error {
+	loggr := log.FromContext(ctx)
+
+	wanted, err := r.createConfigMap(fufu)
+	if err != nil {
+		return err
+	}
+
+	had := &corev1.ConfigMap{}
+	if err := r.Get(ctx, types.NamespacedName{Name: wanted.ObjectMeta.Name, Namespace: wanted.ObjectMeta.Namespace}, had); err == nil {
+		changed := !equality.Semantic.DeepEqual(wanted.Data, had.Data)
+		// the running pods would pick the configuration up on restart, keep it for the window
+		if changed && r.deferRollout(fufu, "nginx configuration") {
+			wanted.Data, changed = had.Data, false
+		}
+		if changed || metadataChanged(wanted, had) {
+			loggr.Info("A diff was found, update configmap ...")
+			drifted := markApplied(wanted, had)
+			ctrutil.SetControllerReference(fufu, wanted, r.Scheme)
+			if err = r.Update(ctx, wanted); err != nil {
+				return err
+			}
+			r.childUpdated(fufu, drifted, "configmap-updated", "Nginx configuration updated")
+		}
+		return nil
+	} else {
+		if err = client.IgnoreNotFound(err); err != nil {
+			return err
+		}
+
+		loggr.Info("Create configmap ...")
+		markApplied(wanted, nil)
+		ctrutil.SetControllerReference(fufu, wanted, r.Scheme)
+		if err = r.Create(ctx, wanted); err != nil {
+			loggr.Error(err, "failed to create configmap")
+		}
+
+		r.Recorder.Event(fufu, corev1.EventTypeNormal, "configmap-created", "Nginx configuration created")
+		return nil
+	}
+}
+
+func (r *FufuReconciler) createConfigMap(fufu *catv1alpha2.Fufu) (*corev1.ConfigMap, error) {
+	data, err := renderNginxConf(fufu)
+	if err != nil {
+		return nil, err
+	}
+
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        childName(fufu, catv1alpha2.SuffixConfigMap),
+			Namespace:   fufu.Namespace,
+			Labels:      commonLabels(fufu),
+			Annotations: commonAnnotations(fufu),
+		},
+		Data: data,
+	}, nil
+}
+
+// renderNginxConf generates the files mounted in nginx's conf.d: the server blocks
+// and the custom error pages
+func renderNginxConf(fufu *catv1alpha2.Fufu) (map[string]string, error) {
+	data := map[string]string{}
+
+	custom := map[int32]bool{}
+	for _, page := range fufu.Spec.Nginx.ErrorPages {
+		custom[page.Code] = true
+		data[fmt.Sprintf("error-%d.html", page.Code)] = page.Content
+	}
+	defaultCodes := []int32{}
+	for _, code := range []int32{500, 502, 503, 504} {
+		if !custom[code] {
+			defaultCodes = append(defaultCodes, code)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := nginxConfTmpl.Execute(&buf, struct {
+		Nginx             catv1alpha2.NginxSpec
+		DefaultErrorCodes []int32
+		StubStatus        bool
+	}{
+		Nginx:             fufu.Spec.Nginx,
+		DefaultErrorCodes: defaultCodes,
+		StubStatus:        fufu.Spec.Monitoring.Enabled,
+	}); err != nil {
+		return nil, err
+	}
+	data[nginxConfFile] = buf.String()
+
+	return data, nil
+}
+
+// nginxConfHash fingerprints the rendered configuration, so the pods roll when it changes
+func nginxConfHash(fufu *catv1alpha2.Fufu) string {
+	data, err := renderNginxConf(fufu)
+	if err != nil {
+		return ""
+	}
+
+	return hashData(data)
+}
+
+// hashData fingerprints the content of a ConfigMap
+func hashData(data map[string]string) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s\x00%s\x00", k, data[k])
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
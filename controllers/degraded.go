@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+// crashLoopRestarts is the number of restarts after which a CrashLoopBackOff is persistent
+const crashLoopRestarts = 3
+
+// updateDegraded marks the Fufu Degraded when the Deployment running its current template
+// stops progressing or its pods keep crashing, and asks for a rollback to the last available
+// revision if allowed. It needs the current revision to be recorded
+func (r *FufuReconciler) updateDegraded(fufu *catv1alpha2.Fufu, ctx context.Context) error {
+	loggr := log.FromContext(ctx)
+
+	wanted := r.createDeploy(fufu)
+	name := servingDeployName(fufu)
+	bg := fufu.Status.BlueGreen
+	rolledOut := meta.IsStatusConditionTrue(fufu.Status.Conditions, catv1alpha2.ConditionReady)
+	if fufu.Spec.RolloutStrategy.BlueGreen != nil && bg != nil && bg.ActiveRevision != hashTemplate(&wanted.Spec.Template) {
+		// the preview runs the current template
+		name, rolledOut = colorName(fufu, bg.PreviewColor), false
+	}
+
+	deploy := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: fufu.Namespace}, deploy); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	rolledOut = rolledOut && (fufu.Spec.RolloutStrategy.BlueGreen != nil || !templateChanged(wanted, deploy))
+
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(fufu.Namespace), client.MatchingLabels(deploy.Spec.Selector.MatchLabels)); err != nil {
+		return err
+	}
+
+	cond := metav1.Condition{
+		Type:               catv1alpha2.ConditionDegraded,
+		Status:             metav1.ConditionFalse,
+		Reason:             "RolloutHealthy",
+		Message:            "The pods are progressing",
+		ObservedGeneration: fufu.Generation,
+	}
+	failure := podFailure(pods.Items, 0)
+	if crash := podFailure(pods.Items, crashLoopRestarts); crash != "" {
+		cond.Status, cond.Reason, cond.Message = metav1.ConditionTrue, "CrashLoopBackOff", crash
+	}
+	for _, c := range deploy.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Reason == "ProgressDeadlineExceeded" {
+			cond.Status, cond.Reason, cond.Message = metav1.ConditionTrue, c.Reason, c.Message
+			if failure != "" {
+				cond.Message = failure
+			}
+		}
+	}
+
+	prev := meta.FindStatusCondition(fufu.Status.Conditions, catv1alpha2.ConditionDegraded)
+	switch {
+	case cond.Status == metav1.ConditionTrue && (prev == nil || prev.Status != cond.Status):
+		loggr.Info(fmt.Sprintf("Rollout stuck: %s", cond.Message))
+		r.Recorder.Eventf(fufu, corev1.EventTypeWarning, "rollout-stuck", "%s: %s", cond.Reason, cond.Message)
+	case cond.Status == metav1.ConditionFalse && prev != nil && prev.Status == metav1.ConditionTrue:
+		r.Recorder.Event(fufu, corev1.EventTypeNormal, "rollout-recovered", "The pods are progressing again")
+	}
+	meta.SetStatusCondition(&fufu.Status.Conditions, cond)
+
+	switch {
+	case cond.Status == metav1.ConditionFalse && rolledOut:
+		fufu.Status.LastAvailableRevision = fufu.Status.CurrentRevision
+	case cond.Status == metav1.ConditionTrue && fufu.Spec.RolloutStrategy.AutoRollback:
+		return r.autoRollback(fufu, ctx)
+	}
+	return nil
+}
+
+// podFailure describes the first container found failing, only the ones restarted at least
+// restarts times count as crashing. Empty if none
+func podFailure(pods []corev1.Pod, restarts int32) string {
+	for _, pod := range pods {
+		statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for i, cs := range statuses {
+			reason, message := containerIssue(&cs, i < len(pod.Status.InitContainerStatuses))
+			if reason == "" {
+				continue
+			}
+			if restarts > 0 && (reason != "CrashLoopBackOff" || cs.RestartCount < restarts) {
+				continue
+			}
+
+			failure := fmt.Sprintf("pod %s, container %s: %s", pod.Name, cs.Name, reason)
+			if message != "" {
+				failure += ", " + message
+			}
+			return failure
+		}
+	}
+
+	return ""
+}
+
+// autoRollback asks for a rollback of the pods to the last available revision through the
+// rollback-to annotation, so it goes through the same path as a manual one. The changes
+// that don't shape the pods are kept, and nothing is rolled back if the pods didn't change
+// since that revision
+func (r *FufuReconciler) autoRollback(fufu *catv1alpha2.Fufu, ctx context.Context) error {
+	loggr := log.FromContext(ctx)
+
+	// the live pods don't run the current revision yet, they can't be blamed on it
+	last := fufu.Status.LastAvailableRevision
+	if last == "" || last == fufu.Status.CurrentRevision || rolloutPending(fufu) {
+		return nil
+	}
+
+	rev, current := &appsv1.ControllerRevision{}, &appsv1.ControllerRevision{}
+	for name, into := range map[string]*appsv1.ControllerRevision{last: rev, fufu.Status.CurrentRevision: current} {
+		if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: fufu.Namespace}, into); err != nil {
+			// pruned from the history
+			return client.IgnoreNotFound(err)
+		}
+	}
+	if samePods(rev, current) {
+		loggr.Info(fmt.Sprintf("The pods didn't change since revision %d, nothing to roll back", rev.Revision))
+		return nil
+	}
+
+	loggr.Info(fmt.Sprintf("Roll back automatically to revision %d ...", rev.Revision))
+	pods, to := "true", strconv.FormatInt(rev.Revision, 10)
+	if err := r.patchAnnotation(fufu, rollbackPodsAnnotation, &pods, ctx); err != nil {
+		return err
+	}
+	if err := r.patchAnnotation(fufu, catv1alpha2.RollbackToAnnotation, &to, ctx); err != nil {
+		return err
+	}
+	r.Recorder.Eventf(fufu, corev1.EventTypeWarning, "auto-rollback", "Rolling back to revision %d, the last one available", rev.Revision)
+	return nil
+}
+
+// samePods tells whether both revisions shape the pods alike, the ones that can't be decoded
+// are told apart
+func samePods(a, b *appsv1.ControllerRevision) bool {
+	specA, specB := &catv1alpha2.FufuSpec{}, &catv1alpha2.FufuSpec{}
+	if json.Unmarshal(a.Data.Raw, specA) != nil || json.Unmarshal(b.Data.Raw, specB) != nil {
+		return false
+	}
+
+	empty := &catv1alpha2.FufuSpec{}
+	return equality.Semantic.DeepEqual(outsidePods(specA, empty), outsidePods(specB, empty))
+}
@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/runtime"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+func TestPodFailure(t *testing.T) {
+	pod := func(name string, cs corev1.ContainerStatus) corev1.Pod {
+		cs.Name = "web"
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{cs}},
+		}
+	}
+	waiting := func(reason, msg string) corev1.ContainerState {
+		return corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason, Message: msg}}
+	}
+	crashed := corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Error", ExitCode: 1}}
+
+	cases := []struct {
+		name     string
+		pods     []corev1.Pod
+		restarts int32
+		want     string
+	}{
+		{
+			name: "running",
+			pods: []corev1.Pod{pod("a", corev1.ContainerStatus{State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}})},
+		},
+		{
+			name: "starting",
+			pods: []corev1.Pod{pod("a", corev1.ContainerStatus{State: waiting("ContainerCreating", "")})},
+		},
+		{
+			name: "bad image",
+			pods: []corev1.Pod{pod("a", corev1.ContainerStatus{State: waiting("ImagePullBackOff", `Back-off pulling image "ngnix"`)})},
+			want: `pod a, container web: ImagePullBackOff, Back-off pulling image "ngnix"`,
+		},
+		{
+			name:     "crashing but not persistent yet",
+			pods:     []corev1.Pod{pod("a", corev1.ContainerStatus{State: waiting("CrashLoopBackOff", ""), LastTerminationState: crashed, RestartCount: 1})},
+			restarts: crashLoopRestarts,
+		},
+		{
+			name: "persistent crash loop",
+			pods: []corev1.Pod{
+				pod("a", corev1.ContainerStatus{State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}}),
+				pod("b", corev1.ContainerStatus{State: waiting("CrashLoopBackOff", ""), LastTerminationState: crashed, RestartCount: 5}),
+			},
+			restarts: crashLoopRestarts,
+			want:     "pod b, container web: CrashLoopBackOff, last exit Error with code 1",
+		},
+		{
+			name:     "pulling is not crashing",
+			pods:     []corev1.Pod{pod("a", corev1.ContainerStatus{State: waiting("ImagePullBackOff", "")})},
+			restarts: crashLoopRestarts,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := podFailure(c.pods, c.restarts); got != c.want {
+				t.Errorf("podFailure() = %q, want %q", got, c.want)
+			}
+		})
+	}
+}
+
+func TestAutoRollbackKeepsOutsidePods(t *testing.T) {
+	revision := func(spec catv1alpha2.FufuSpec) *appsv1.ControllerRevision {
+		raw, err := json.Marshal(&spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &appsv1.ControllerRevision{Data: runtime.RawExtension{Raw: raw}}
+	}
+
+	working := catv1alpha2.FufuSpec{Color: "orange", Weight: "5kg", Template: catv1alpha2.PodTemplate{Image: "nginx:1.21"}}
+	broken := *working.DeepCopy()
+	broken.Template.Image = "nginx:broken"
+	// the autoscaling bounds are changed while the broken image keeps the Fufu Degraded
+	fixing := *broken.DeepCopy()
+	fixing.Autoscaling.MaxReplicas = 10
+	fixing.Service.Type = corev1.ServiceTypeNodePort
+
+	if samePods(revision(working), revision(fixing)) {
+		t.Errorf("the image changed since the working revision")
+	}
+	if !samePods(revision(broken), revision(fixing)) {
+		t.Errorf("changes outside the pods told apart")
+	}
+
+	fufu := &catv1alpha2.Fufu{Spec: fixing}
+	restoreSpec(fufu, outsidePods(&working, &fufu.Spec))
+	if fufu.Spec.Template.Image != "nginx:1.21" {
+		t.Errorf("image %s not rolled back", fufu.Spec.Template.Image)
+	}
+	if fufu.Spec.Autoscaling.MaxReplicas != 10 || fufu.Spec.Service.Type != corev1.ServiceTypeNodePort {
+		t.Errorf("changes outside the pods reverted: %+v, %+v", fufu.Spec.Autoscaling, fufu.Spec.Service)
+	}
+}
@@ -3,9 +3,12 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	ctrutil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
@@ -17,75 +20,260 @@ import (
 	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
 )
 
-func (r *FufuReconciler) updateDeploy(fufu *catv1alpha2.Fufu, ctx context.Context) error {
+func (r *FufuReconciler) updateDeploy(fufu *catv1alpha2.Fufu, now time.Time, ctx context.Context) (time.Duration, error) {
 	loggr := log.FromContext(ctx)
 
+	// the pods of all the Fufu's Deployments, for the autoscalers targeting the Fufu itself
+	fufu.Status.Selector = metav1.FormatLabelSelector(&metav1.LabelSelector{
+		MatchLabels: map[string]string{"app": appLabel(fufu)},
+	})
+
+	if fufu.Spec.RolloutStrategy.BlueGreen != nil {
+		return r.updateBlueGreen(fufu, now, ctx)
+	}
+
 	wanted := r.createDeploy(fufu)
 
 	had := &appsv1.Deployment{}
 	if err := r.Get(ctx, types.NamespacedName{Name: wanted.ObjectMeta.Name, Namespace: wanted.ObjectMeta.Namespace}, had); err == nil {
-		if had.Status.Replicas != fufu.Status.Replicas {
-			loggr.Info(fmt.Sprintf("Fufu's current replicas: %d", had.Status.Replicas))
-			fufu.Status.Replicas = had.Status.Replicas
-			if err = r.Status().Update(ctx, fufu); err != nil {
-				return err
-			}
-			r.Recorder.Eventf(fufu, corev1.EventTypeNormal, "replicas-updated", "Replicas updated to %d", had.Status.Replicas)
-			//loggr.Info(fmt.Sprintf("Replicas updated to %d", had.Status.Replicas))
+		r.observeDeploy(fufu, had, ctx)
+
+		// the HPA doesn't scale up from zero, a Fufu waking up starts from the floor
+		if wanted.Spec.Replicas == nil && had.Spec.Replicas != nil && *had.Spec.Replicas == 0 {
+			floor, _ := hpaBounds(fufu)
+			wanted.Spec.Replicas = &floor
+		}
+
+		// a canary under way goes on, a new template waits for the maintenance window
+		if templateChanged(wanted, had) && !canaryInFlight(fufu) && r.deferRollout(fufu, templateChanges(wanted, had)...) {
+			wanted.Spec.Template = *had.Spec.Template.DeepCopy()
 		}
 
-		if !equality.Semantic.DeepDerivative(wanted.Spec, had.Spec) {
+		if deployChanged(wanted, had) {
+			if fufu.Spec.RolloutStrategy.Canary != nil && templateChanged(wanted, had) {
+				return r.updateCanary(fufu, wanted, had, now, ctx)
+			}
+
 			loggr.Info("A diff was found, update deploy ...")
+			drifted := markApplied(wanted, had)
 			ctrutil.SetControllerReference(fufu, wanted, r.Scheme)
 			if err = r.Update(ctx, wanted); err != nil {
-				return err
+				return 0, err
 			}
-			r.Recorder.Event(fufu, corev1.EventTypeNormal, "deploy-updated", "Deployment updated")
+			r.childUpdated(fufu, drifted, "deploy-updated", "Deployment updated")
 			//loggr.Info("Deployment updated")
+			return 0, nil
 		}
 
-		return nil
+		if err := r.finishCanary(fufu, had, ctx); err != nil {
+			return 0, err
+		}
+		return 0, r.finishBlueGreen(fufu, ctx)
 	} else {
 		if err = client.IgnoreNotFound(err); err != nil {
-			return err
+			return 0, err
 		}
 
 		loggr.Info("Create deploy ...")
+		markApplied(wanted, nil)
 		ctrutil.SetControllerReference(fufu, wanted, r.Scheme)
 		if err = r.Create(ctx, wanted); err != nil {
 			loggr.Error(err, "failed to create deploy")
 		}
 
+		meta.SetStatusCondition(&fufu.Status.Conditions, metav1.Condition{
+			Type:               catv1alpha2.ConditionReady,
+			Status:             metav1.ConditionFalse,
+			Reason:             "DeploymentCreated",
+			Message:            "Waiting for the pods to become ready",
+			ObservedGeneration: fufu.Generation,
+		})
 		r.Recorder.Event(fufu, corev1.EventTypeNormal, "deploy-created", "Deployment created")
 		//loggr.Info("Deployment created")
-		return nil
+		return 0, nil
+	}
+}
+
+// observeDeploy reflects the state of the Deployment serving the Fufu into its status
+func (r *FufuReconciler) observeDeploy(fufu *catv1alpha2.Fufu, had *appsv1.Deployment, ctx context.Context) {
+	loggr := log.FromContext(ctx)
+
+	if had.Status.Replicas != fufu.Status.Replicas {
+		loggr.Info(fmt.Sprintf("Fufu's current replicas: %d", had.Status.Replicas))
+		fufu.Status.Replicas = had.Status.Replicas
+		r.Recorder.Eventf(fufu, corev1.EventTypeNormal, "replicas-updated", "Replicas updated to %d", had.Status.Replicas)
+	}
+	fufu.Status.ReadyReplicas = had.Status.ReadyReplicas
+	r.setReadyCondition(fufu, had)
+}
+
+// variantDeploy copies wanted under another name, its pods keep the labels selected by the
+// Service but the stable track and get label=value to tell them apart
+func variantDeploy(wanted *appsv1.Deployment, name, label, value string) *appsv1.Deployment {
+	variant := wanted.DeepCopy()
+	variant.Name = name
+
+	selector := map[string]string{label: value}
+	for k, v := range wanted.Spec.Selector.MatchLabels {
+		selector[k] = v
+	}
+	variant.Spec.Selector = &metav1.LabelSelector{MatchLabels: selector}
+	variant.Spec.Template.Labels = map[string]string{}
+	for k, v := range wanted.Spec.Template.Labels {
+		variant.Spec.Template.Labels[k] = v
+	}
+	delete(variant.Spec.Template.Labels, trackLabel)
+	variant.Spec.Template.Labels[label] = value
+
+	return variant
+}
+
+// applyVariantDeploy returns the variant as found in the cluster, its status is only
+// meaningful when it was left unchanged
+func (r *FufuReconciler) applyVariantDeploy(fufu *catv1alpha2.Fufu, variant *appsv1.Deployment, ctx context.Context) (*appsv1.Deployment, error) {
+	loggr := log.FromContext(ctx)
+
+	ctrutil.SetControllerReference(fufu, variant, r.Scheme)
+
+	had := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Name: variant.Name, Namespace: variant.Namespace}, had); err != nil {
+		if err = client.IgnoreNotFound(err); err != nil {
+			return nil, err
+		}
+
+		loggr.Info(fmt.Sprintf("Create deploy %s with %d replicas ...", variant.Name, *variant.Spec.Replicas))
+		if err = r.Create(ctx, variant); err != nil {
+			return nil, err
+		}
+		return variant, nil
+	}
+
+	if deployChanged(variant, had) || !equality.Semantic.DeepEqual(variant.Spec.Replicas, had.Spec.Replicas) {
+		loggr.Info(fmt.Sprintf("Update deploy %s to %d replicas ...", variant.Name, *variant.Spec.Replicas))
+		variant.ResourceVersion = had.ResourceVersion
+		if err := r.Update(ctx, variant); err != nil {
+			return nil, err
+		}
+		return variant, nil
+	}
+
+	return had, nil
+}
+
+// deployAvailable tells if all the desired pods of the Deployment run its current template
+// and are available
+func deployAvailable(d *appsv1.Deployment) bool {
+	var desired int32 = 1
+	if d.Spec.Replicas != nil {
+		desired = *d.Spec.Replicas
+	}
+
+	return d.Status.ObservedGeneration >= d.Generation &&
+		d.Status.UpdatedReplicas >= desired && d.Status.AvailableReplicas >= desired
+}
+
+// deployChanged tells if had drifted from wanted
+func deployChanged(wanted, had *appsv1.Deployment) bool {
+	return templateChanged(wanted, had) || !equality.Semantic.DeepDerivative(wanted.Spec, had.Spec) || metadataChanged(wanted, had)
+}
+
+// templateChanged tells if the pod template drifted, DeepDerivative alone misses the containers,
+// volumes, scheduling constraints, resources or labels dropped from the wanted pod template
+func templateChanged(wanted, had *appsv1.Deployment) bool {
+	wantedPod, hadPod := wanted.Spec.Template.Spec, had.Spec.Template.Spec
+	return len(wantedPod.Containers) != len(hadPod.Containers) ||
+		len(wantedPod.Volumes) != len(hadPod.Volumes) ||
+		len(wantedPod.NodeSelector) != len(hadPod.NodeSelector) ||
+		len(wantedPod.Tolerations) != len(hadPod.Tolerations) ||
+		!equality.Semantic.DeepEqual(wantedPod.Containers[0].Resources, hadPod.Containers[0].Resources) ||
+		!equality.Semantic.DeepDerivative(wanted.Spec.Template, had.Spec.Template) ||
+		metadataChanged(&wanted.Spec.Template, &had.Spec.Template)
+}
+
+// setReadyCondition reflects the readiness of the deployment's pods into Fufu's Ready condition
+func (r *FufuReconciler) setReadyCondition(fufu *catv1alpha2.Fufu, deploy *appsv1.Deployment) {
+	cond := metav1.Condition{
+		Type:               catv1alpha2.ConditionReady,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: fufu.Generation,
+	}
+
+	var desired int32 = 1
+	if deploy.Spec.Replicas != nil {
+		desired = *deploy.Spec.Replicas
 	}
+
+	switch {
+	case deploy.Status.ObservedGeneration < deploy.Generation || deploy.Status.UpdatedReplicas < desired:
+		cond.Reason = "RolloutInProgress"
+		cond.Message = fmt.Sprintf("%d of %d pods updated", deploy.Status.UpdatedReplicas, desired)
+	case desired == 0 && asleep(fufu):
+		cond.Reason = "Hibernating"
+		cond.Message = "The Fufu sleeps, a placeholder page is served"
+	case desired == 0:
+		cond.Reason = "ScaledToZero"
+		cond.Message = "No pod is desired"
+	case deploy.Status.ReadyReplicas < desired:
+		cond.Reason = "PodsNotReady"
+		cond.Message = fmt.Sprintf("%d of %d pods ready", deploy.Status.ReadyReplicas, desired)
+	default:
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = "PodsReady"
+		cond.Message = fmt.Sprintf("%d of %d pods ready", deploy.Status.ReadyReplicas, desired)
+	}
+
+	if prev := meta.FindStatusCondition(fufu.Status.Conditions, catv1alpha2.ConditionReady); prev == nil || prev.Status != cond.Status {
+		if cond.Status == metav1.ConditionTrue {
+			r.Recorder.Event(fufu, corev1.EventTypeNormal, "fufu-ready", cond.Message)
+		} else if prev != nil && cond.Reason != "Hibernating" {
+			r.Recorder.Event(fufu, corev1.EventTypeWarning, "fufu-not-ready", cond.Message)
+		}
+	}
+	meta.SetStatusCondition(&fufu.Status.Conditions, cond)
 }
 
 func (r *FufuReconciler) createDeploy(fufu *catv1alpha2.Fufu) *appsv1.Deployment {
-	name := fufu.Name + "-deploy"
-	labels := map[string]string{
-		"app": name,
+	name := childName(fufu, catv1alpha2.SuffixDeploy)
+	selector := map[string]string{
+		"app": appLabel(fufu),
 	}
 	volName := "homedir"
+	confVolName := "nginx-conf"
+
+	image := webImage(fufu)
 
-	return &appsv1.Deployment{
+	// the page answers 401 behind basic auth, only the health check is left open
+	readinessProbe := defaultReadinessProbe
+	if fufu.Spec.Nginx.BasicAuth != nil {
+		readinessProbe.Path = healthzPath
+	}
+
+	deploy := &appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: fufu.Namespace,
+			Name:        name,
+			Namespace:   fufu.Namespace,
+			Labels:      commonLabels(fufu),
+			Annotations: commonAnnotations(fufu),
 		},
 		Spec: appsv1.DeploymentSpec{
+			// left to the HPA unless autoscaling is disabled
+			Replicas: fixedReplicas(fufu),
 			Strategy: appsv1.DeploymentStrategy{
 				Type: appsv1.RollingUpdateDeploymentStrategyType,
 			},
 			Selector: &metav1.LabelSelector{
-				MatchLabels: labels,
+				MatchLabels: selector,
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: labels,
+					Labels: commonLabels(fufu),
+					Annotations: withCommonAnnotations(fufu, map[string]string{
+						nginxConfHashAnnotation: nginxConfHash(fufu),
+					}),
 				},
 				Spec: corev1.PodSpec{
+					NodeSelector: fufu.Spec.Template.NodeSelector,
+					Tolerations:  fufu.Spec.Template.Tolerations,
 					Volumes: []corev1.Volume{
 						{
 							Name: volName,
@@ -93,34 +281,32 @@ func (r *FufuReconciler) createDeploy(fufu *catv1alpha2.Fufu) *appsv1.Deployment
 								EmptyDir: &corev1.EmptyDirVolumeSource{},
 							},
 						},
+						{
+							Name: confVolName,
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{
+										Name: childName(fufu, catv1alpha2.SuffixConfigMap),
+									},
+								},
+							},
+						},
 					},
 					InitContainers: []corev1.Container{
 						{
 							Name:  "prepare-webcontent",
-							Image: "alpine",
+							Image: initImage(fufu),
 							Command: []string{
 								"/bin/sh",
 								"-c",
 							},
 							Args: []string{
-								"wget https://raw.githubusercontent.com/ZhengjunHUO/kubebuilder/main/k8s/nginx/index.html.tmpl     && apk add gettext && envsubst '$FUR_COLOR $BREED $AGE $WEIGHT' < index.html.tmpl > /mnt/index.html",
+								`printf '%s' "$PAGE" > /mnt/index.html`,
 							},
 							Env: []corev1.EnvVar{
 								{
-									Name:  "FUR_COLOR",
-									Value: fufu.Spec.Color,
-								},
-								{
-									Name:  "BREED",
-									Value: fufu.Spec.Info.Breed,
-								},
-								{
-									Name:  "AGE",
-									Value: fmt.Sprintf("%d", fufu.Spec.Age),
-								},
-								{
-									Name:  "WEIGHT",
-									Value: fufu.Spec.Weight,
+									Name:  "PAGE",
+									Value: renderPage(fufu),
 								},
 							},
 							VolumeMounts: []corev1.VolumeMount{
@@ -134,13 +320,18 @@ func (r *FufuReconciler) createDeploy(fufu *catv1alpha2.Fufu) *appsv1.Deployment
 					},
 					Containers: []corev1.Container{
 						{
-							Name:  "web",
-							Image: "nginx",
+							Name:      "web",
+							Image:     image,
+							Resources: fufu.Spec.Template.Resources,
 							Ports: []corev1.ContainerPort{
 								{
+									Name:          webPortName,
 									ContainerPort: 80,
 								},
 							},
+							LivenessProbe:  createProbe(fufu.Spec.Probes.Liveness, defaultLivenessProbe),
+							ReadinessProbe: createProbe(fufu.Spec.Probes.Readiness, readinessProbe),
+							StartupProbe:   createProbe(fufu.Spec.Probes.Startup, defaultStartupProbe),
 							VolumeMounts: []corev1.VolumeMount{
 								{
 									Name:      volName,
@@ -148,6 +339,11 @@ func (r *FufuReconciler) createDeploy(fufu *catv1alpha2.Fufu) *appsv1.Deployment
 									SubPath:   "index.html",
 									ReadOnly:  true,
 								},
+								{
+									Name:      confVolName,
+									MountPath: "/etc/nginx/conf.d",
+									ReadOnly:  true,
+								},
 							},
 						},
 					},
@@ -155,4 +351,128 @@ func (r *FufuReconciler) createDeploy(fufu *catv1alpha2.Fufu) *appsv1.Deployment
 			},
 		},
 	}
+
+	if auth := fufu.Spec.Nginx.BasicAuth; auth != nil {
+		authVolName := "nginx-auth"
+		podSpec := &deploy.Spec.Template.Spec
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: authVolName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: auth.SecretName,
+					Items: []corev1.KeyToPath{
+						{
+							Key:  "auth",
+							Path: "auth",
+						},
+					},
+				},
+			},
+		})
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      authVolName,
+			MountPath: "/etc/nginx/auth",
+			ReadOnly:  true,
+		})
+	}
+
+	if fufu.Spec.Monitoring.Enabled {
+		deploy.Spec.Template.Spec.Containers = append(deploy.Spec.Template.Spec.Containers, createExporter(fufu))
+	}
+	deploy.Spec.Template.Labels[trackLabel] = stableTrack
+
+	return deploy
+}
+
+// createExporter returns the sidecar exposing nginx's stub_status to prometheus
+func createExporter(fufu *catv1alpha2.Fufu) corev1.Container {
+	image := defaultExporterImage
+	if fufu.Spec.Monitoring.ExporterImage != "" {
+		image = fufu.Spec.Monitoring.ExporterImage
+	}
+
+	return corev1.Container{
+		Name:  "exporter",
+		Image: image,
+		Args: []string{
+			"-nginx.scrape-uri=" + stubStatusURI,
+		},
+		Ports: []corev1.ContainerPort{
+			{
+				Name:          metricsPortName,
+				ContainerPort: metricsPort,
+			},
+		},
+	}
+}
+
+const (
+	webPortName = "http"
+	healthzPath = "/healthz"
+
+	defaultWebImage  = "nginx"
+	defaultInitImage = "alpine"
+
+	nginxConfHashAnnotation = "cat.huozj.io/nginx-conf-hash"
+)
+
+var (
+	defaultLivenessProbe = catv1alpha2.ProbeSpec{
+		Path:             healthzPath,
+		PeriodSeconds:    10,
+		TimeoutSeconds:   1,
+		FailureThreshold: 3,
+	}
+	defaultReadinessProbe = catv1alpha2.ProbeSpec{
+		Path:             "/",
+		PeriodSeconds:    5,
+		TimeoutSeconds:   1,
+		FailureThreshold: 3,
+	}
+	// give nginx up to one minute to come up before the liveness probe takes over
+	defaultStartupProbe = catv1alpha2.ProbeSpec{
+		Path:             healthzPath,
+		PeriodSeconds:    2,
+		TimeoutSeconds:   1,
+		FailureThreshold: 30,
+	}
+)
+
+// createProbe builds an HTTP probe on the web port, any field left empty in spec falls back to the default
+func createProbe(spec *catv1alpha2.ProbeSpec, dft catv1alpha2.ProbeSpec) *corev1.Probe {
+	p := dft
+	if spec != nil {
+		if spec.Disabled {
+			return nil
+		}
+		if spec.Path != "" {
+			p.Path = spec.Path
+		}
+		if spec.InitialDelaySeconds > 0 {
+			p.InitialDelaySeconds = spec.InitialDelaySeconds
+		}
+		if spec.PeriodSeconds > 0 {
+			p.PeriodSeconds = spec.PeriodSeconds
+		}
+		if spec.TimeoutSeconds > 0 {
+			p.TimeoutSeconds = spec.TimeoutSeconds
+		}
+		if spec.FailureThreshold > 0 {
+			p.FailureThreshold = spec.FailureThreshold
+		}
+	}
+
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{
+				Path: p.Path,
+				Port: intstr.FromString(webPortName),
+			},
+		},
+		InitialDelaySeconds: p.InitialDelaySeconds,
+		PeriodSeconds:       p.PeriodSeconds,
+		TimeoutSeconds:      p.TimeoutSeconds,
+		SuccessThreshold:    1,
+		FailureThreshold:    p.FailureThreshold,
+	}
 }
@@ -18,17 +18,26 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"time"
 
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	appsv1 "k8s.io/api/apps/v1"
-	asv1 "k8s.io/api/autoscaling/v1"
+	asv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
 )
@@ -38,12 +47,35 @@ type FufuReconciler struct {
 	client.Client
 	Scheme   *runtime.Scheme
 	Recorder record.EventRecorder
+	Clock
+	// Notifier, if any, sends the lifecycle events to the notification sinks
+	Notifier *Notifier
 }
 
+// Clock tells the time, the tests can move it through the hibernation windows
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
 //+kubebuilder:rbac:groups=cat.huozj.io,resources=fufus,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=cat.huozj.io,resources=fufus/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=cat.huozj.io,resources=fufus/finalizers,verbs=update
+//+kubebuilder:rbac:groups=cat.huozj.io,resources=fufuclasses,verbs=get;list;watch
+//+kubebuilder:rbac:groups=cat.huozj.io,resources=fufuhouseholds,verbs=get;list;watch
 //+kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps,resources=controllerrevisions,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=autoscaling,resources=horizontalpodautoscalers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=monitoring.coreos.com,resources=servicemonitors;prometheusrules,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -64,10 +96,66 @@ func (r *FufuReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 	}
 	loggr.Info(fmt.Sprintf("Get fufu: %+v", fufu.Spec))
 
-	if err := r.updateDeploy(fufu, ctx); err != nil {
+	if updated, err := r.rollback(fufu, ctx); err != nil || updated {
+		return ctrl.Result{}, err
+	}
+
+	status := fufu.Status.DeepCopy()
+	applied := fufu.Spec.DeepCopy()
+
+	// from here on the spec holds the class' defaults as well
+	if err := r.applyClass(fufu, ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	result := ctrl.Result{}
+	now := r.Now()
+
+	// the age is rendered on the page, compute it before the deploy
+	birthday, err := r.updateAge(fufu, now)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	requeueAfter(&result, birthday)
+
+	// the children are sized and served after the windows, compute it before them
+	requeueAfter(&result, r.updateHibernation(fufu, now))
+
+	// the children are left alone until the objects in their way are adopted
+	retry, err := r.adoptChildren(fufu, ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if retry > 0 {
+		requeueAfter(&result, retry)
+		return result, r.updateStatus(fufu, status, ctx)
+	}
+
+	// the changes rolling the pods wait for the windows, compute them before the children
+	r.updateMaintenance(fufu, now)
+
+	if err := r.updateConfigMap(fufu, ctx); err != nil {
 		return ctrl.Result{}, err
 	}
 
+	rollout, err := r.updateDeploy(fufu, now, ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	requeueAfter(&result, rollout)
+
+	window, err := r.finishMaintenance(fufu, now, ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	requeueAfter(&result, window)
+
+	waking, err := r.updatePlaceholder(fufu, ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	requeueAfter(&result, waking)
+
 	if err := r.updateSvc(fufu, ctx); err != nil {
 		return ctrl.Result{}, err
 	}
@@ -76,17 +164,114 @@ func (r *FufuReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.
 		return ctrl.Result{}, err
 	}
 
-	return ctrl.Result{}, nil
+	if err := r.updatePdb(fufu, ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := r.updateNetpol(fufu, ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := r.updateMonitoring(fufu, ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// the children named after a previous naming strategy go once the renamed ones serve
+	if err := r.updateResources(fufu, ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	// all the children follow the spec, record it, unless changes wait for a window
+	if !rolloutPending(fufu) {
+		if err := r.updateRevision(fufu, applied, ctx); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if err := r.updatePodIssues(fufu, ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := r.updateDegraded(fufu, ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	vaccinationDue, err := r.updateVaccinations(fufu, now)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	requeueAfter(&result, vaccinationDue)
+
+	// the steps above only collect the observed state, write it back once
+	fufu.Status.ObservedGeneration = fufu.Generation
+	return result, r.updateStatus(fufu, status, ctx)
+}
+
+// updateStatus writes the status collected by the reconcile if it differs from the one read
+func (r *FufuReconciler) updateStatus(fufu *catv1alpha2.Fufu, status *catv1alpha2.FufuStatus, ctx context.Context) error {
+	if equality.Semantic.DeepEqual(status, &fufu.Status) {
+		return nil
+	}
+	return r.Status().Update(ctx, fufu)
+}
+
+// patchAnnotation sets the annotation on the Fufu, or removes it if value is nil. The spec in
+// memory holds the class' defaults so only the annotation is patched
+func (r *FufuReconciler) patchAnnotation(fufu *catv1alpha2.Fufu, key string, value *string, ctx context.Context) error {
+	patched := &catv1alpha2.Fufu{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fufu.Name,
+			Namespace: fufu.Namespace,
+		},
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]*string{key: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if err := r.Patch(ctx, patched, client.RawPatch(types.MergePatchType, patch)); err != nil {
+		return err
+	}
+
+	// the status is updated on top of the patched object
+	fufu.Annotations = patched.Annotations
+	fufu.ResourceVersion = patched.ResourceVersion
+	return nil
+}
+
+// requeueAfter keeps the earliest of the delays asked to requeue the Fufu, 0 means no need
+func requeueAfter(result *ctrl.Result, d time.Duration) {
+	if d > 0 && (result.RequeueAfter == 0 || d < result.RequeueAfter) {
+		result.RequeueAfter = d
+	}
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *FufuReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	r.Recorder = mgr.GetEventRecorderFor("Fufu")
+	if r.Notifier != nil {
+		r.Recorder = r.Notifier.Recorder(r.Recorder)
+	}
+	if r.Clock == nil {
+		r.Clock = realClock{}
+	}
 
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&catv1alpha2.Fufu{}).
+		Owns(&corev1.ConfigMap{}).
 		Owns(&appsv1.Deployment{}).
 		Owns(&corev1.Service{}).
-		Owns(&asv1.HorizontalPodAutoscaler{}).
+		Owns(&asv2.HorizontalPodAutoscaler{}).
+		Owns(&policyv1.PodDisruptionBudget{}).
+		Owns(&netv1.NetworkPolicy{}).
+		Watches(&source.Kind{Type: &catv1alpha2.FufuClass{}}, handler.EnqueueRequestsFromMapFunc(r.findFufusForClass)).
+		Watches(&source.Kind{Type: &catv1alpha2.FufuHousehold{}}, handler.EnqueueRequestsFromMapFunc(r.findFufusForHousehold)).
+		Watches(&source.Kind{Type: &corev1.Pod{}}, handler.EnqueueRequestsFromMapFunc(r.findFufuForPod)).
+		Watches(&source.Kind{Type: &appsv1.Deployment{}}, handler.EnqueueRequestsFromMapFunc(r.findFufuForLabelled)).
+		Watches(&source.Kind{Type: &corev1.Service{}}, handler.EnqueueRequestsFromMapFunc(r.findFufuForLabelled)).
+		Watches(&source.Kind{Type: &asv2.HorizontalPodAutoscaler{}}, handler.EnqueueRequestsFromMapFunc(r.findFufuForLabelled)).
 		Complete(r)
 }
@@ -1,16 +1,21 @@
 package controllers
 
 import (
+	"strconv"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
 
 	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
 	appsv1 "k8s.io/api/apps/v1"
-	asv1 "k8s.io/api/autoscaling/v1"
+	asv2 "k8s.io/api/autoscaling/v2"
 	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -38,6 +43,11 @@ var _ = Describe("Test controller", func() {
 			Name:      "fufu-hpa",
 			Namespace: "default",
 		}
+
+		pdbNsn = types.NamespacedName{
+			Name:      "fufu-pdb",
+			Namespace: "default",
+		}
 	)
 
 	When("create custom resource fufu", func() {
@@ -88,6 +98,18 @@ var _ = Describe("Test controller", func() {
 				Expect(deploy.ObjectMeta.OwnerReferences).To(ContainElement(expectedOwnerReference))
 			})
 
+			By("generate default probes for the web container", func() {
+				var deploy appsv1.Deployment
+				Eventually(func() error {
+					return k8sClient.Get(ctx, deployNsn, &deploy)
+				}, timeout, interval).Should(BeNil())
+				web := deploy.Spec.Template.Spec.Containers[0]
+				Expect(web.LivenessProbe).NotTo(BeNil())
+				Expect(web.ReadinessProbe).NotTo(BeNil())
+				Expect(web.StartupProbe).NotTo(BeNil())
+				Expect(web.ReadinessProbe.HTTPGet.Path).To(Equal("/"))
+			})
+
 			By("create associated svc for deploy", func() {
 				var svc corev1.Service
 				Eventually(func() error {
@@ -97,19 +119,488 @@ var _ = Describe("Test controller", func() {
 			})
 
 			By("create associated hpa for deploy", func() {
-				var hpa asv1.HorizontalPodAutoscaler
+				var hpa asv2.HorizontalPodAutoscaler
 				Eventually(func() error {
 					return k8sClient.Get(ctx, hpaNsn, &hpa)
 				}, timeout, interval).Should(BeNil())
 				Expect(hpa.ObjectMeta.OwnerReferences).To(ContainElement(expectedOwnerReference))
 			})
+
+			By("create pdb derived from hpa's floor", func() {
+				var pdb policyv1.PodDisruptionBudget
+				Eventually(func() error {
+					return k8sClient.Get(ctx, pdbNsn, &pdb)
+				}, timeout, interval).Should(BeNil())
+				Expect(pdb.ObjectMeta.OwnerReferences).To(ContainElement(expectedOwnerReference))
+				Expect(pdb.Spec.MinAvailable.IntValue()).To(Equal(1))
+			})
+		})
+
+		When("fufu asks for a network policy", func() {
+			var netpolNsn = types.NamespacedName{
+				Name:      "fufu-netpol",
+				Namespace: "default",
+			}
+
+			BeforeEach(func() {
+				Eventually(func() error {
+					fufu := &catv1alpha2.Fufu{}
+					if err := k8sClient.Get(ctx, nsn, fufu); err != nil {
+						return err
+					}
+					fufu.Spec.NetworkPolicy = &catv1alpha2.NetworkPolicySpec{
+						AllowedNamespaces: []string{"monitoring"},
+					}
+					return k8sClient.Update(ctx, fufu)
+				}, timeout, interval).Should(Succeed())
+			})
+
+			It("netpol selecting fufu's pods created by controller", func() {
+				var netpol netv1.NetworkPolicy
+				Eventually(func() error {
+					return k8sClient.Get(ctx, netpolNsn, &netpol)
+				}, timeout, interval).Should(BeNil())
+				Expect(netpol.Spec.PodSelector.MatchLabels).To(HaveKeyWithValue("app", "fufu-deploy"))
+				Expect(netpol.Spec.Ingress).To(HaveLen(1))
+				Expect(netpol.Spec.Ingress[0].Ports[0].Port.IntValue()).To(Equal(80))
+			})
+		})
+
+		When("fufu sets common labels", func() {
+			BeforeEach(func() {
+				Eventually(func() error {
+					fufu := &catv1alpha2.Fufu{}
+					if err := k8sClient.Get(ctx, nsn, fufu); err != nil {
+						return err
+					}
+					fufu.Labels = map[string]string{"cost.example.com/team": "cats"}
+					fufu.Spec.CommonLabels = map[string]string{"household": "huo"}
+					fufu.Spec.PropagatedPrefixes = []string{"cost.example.com/"}
+					return k8sClient.Update(ctx, fufu)
+				}, timeout, interval).Should(Succeed())
+			})
+
+			It("children and pods labelled by controller, selector untouched", func() {
+				Eventually(func() map[string]string {
+					d := &appsv1.Deployment{}
+					if err := k8sClient.Get(ctx, deployNsn, d); err != nil {
+						return nil
+					}
+					return d.Spec.Template.Labels
+				}, timeout, interval).Should(And(
+					HaveKeyWithValue("household", "huo"),
+					HaveKeyWithValue("cost.example.com/team", "cats"),
+					HaveKeyWithValue("app.kubernetes.io/instance", "fufu"),
+					HaveKeyWithValue("app.kubernetes.io/managed-by", "fufu-controller"),
+				))
+
+				d := &appsv1.Deployment{}
+				Expect(k8sClient.Get(ctx, deployNsn, d)).To(Succeed())
+				Expect(d.Spec.Selector.MatchLabels).To(Equal(map[string]string{"app": "fufu-deploy"}))
+
+				Eventually(func() map[string]string {
+					s := &corev1.Service{}
+					if err := k8sClient.Get(ctx, svcNsn, s); err != nil {
+						return nil
+					}
+					return s.Labels
+				}, timeout, interval).Should(HaveKeyWithValue("household", "huo"))
+			})
+		})
+
+		When("fufu enables monitoring", func() {
+			BeforeEach(func() {
+				Eventually(func() error {
+					fufu := &catv1alpha2.Fufu{}
+					if err := k8sClient.Get(ctx, nsn, fufu); err != nil {
+						return err
+					}
+					fufu.Spec.Monitoring.Enabled = true
+					return k8sClient.Update(ctx, fufu)
+				}, timeout, interval).Should(Succeed())
+			})
+
+			It("exporter sidecar and its stub_status wired up by controller", func() {
+				Eventually(func() int {
+					d := &appsv1.Deployment{}
+					if err := k8sClient.Get(ctx, deployNsn, d); err != nil {
+						return 0
+					}
+					return len(d.Spec.Template.Spec.Containers)
+				}, timeout, interval).Should(Equal(2))
+
+				Eventually(func() int {
+					s := &corev1.Service{}
+					if err := k8sClient.Get(ctx, svcNsn, s); err != nil {
+						return 0
+					}
+					return len(s.Spec.Ports)
+				}, timeout, interval).Should(Equal(2))
+
+				Eventually(func() string {
+					cm := &corev1.ConfigMap{}
+					if err := k8sClient.Get(ctx, types.NamespacedName{Name: "fufu-nginx", Namespace: "default"}, cm); err != nil {
+						return ""
+					}
+					return cm.Data["default.conf"]
+				}, timeout, interval).Should(ContainSubstring("stub_status"))
+			})
+		})
+
+		When("fufu customizes nginx", func() {
+			var hash string
+
+			BeforeEach(func() {
+				Eventually(func() string {
+					d := &appsv1.Deployment{}
+					if err := k8sClient.Get(ctx, deployNsn, d); err != nil {
+						return ""
+					}
+					hash = d.Spec.Template.Annotations["cat.huozj.io/nginx-conf-hash"]
+					return hash
+				}, timeout, interval).ShouldNot(BeEmpty())
+
+				Eventually(func() error {
+					fufu := &catv1alpha2.Fufu{}
+					if err := k8sClient.Get(ctx, nsn, fufu); err != nil {
+						return err
+					}
+					fufu.Spec.Nginx.Gzip = true
+					return k8sClient.Update(ctx, fufu)
+				}, timeout, interval).Should(Succeed())
+			})
+
+			It("configmap rendered and pods rolled by controller", func() {
+				Eventually(func() string {
+					cm := &corev1.ConfigMap{}
+					if err := k8sClient.Get(ctx, types.NamespacedName{Name: "fufu-nginx", Namespace: "default"}, cm); err != nil {
+						return ""
+					}
+					return cm.Data["default.conf"]
+				}, timeout, interval).Should(ContainSubstring("gzip            on;"))
+
+				Eventually(func() string {
+					d := &appsv1.Deployment{}
+					if err := k8sClient.Get(ctx, deployNsn, d); err != nil {
+						return hash
+					}
+					return d.Spec.Template.Annotations["cat.huozj.io/nginx-conf-hash"]
+				}, timeout, interval).ShouldNot(Equal(hash))
+			})
+		})
+
+		When("fufu's autoscaling floor drops to 1", func() {
+			BeforeEach(func() {
+				Eventually(func() error {
+					var pdb policyv1.PodDisruptionBudget
+					return k8sClient.Get(ctx, pdbNsn, &pdb)
+				}, timeout, interval).Should(BeNil())
+
+				Eventually(func() error {
+					fufu := &catv1alpha2.Fufu{}
+					if err := k8sClient.Get(ctx, nsn, fufu); err != nil {
+						return err
+					}
+					var minReplicas int32 = 1
+					fufu.Spec.Autoscaling.MinReplicas = &minReplicas
+					return k8sClient.Update(ctx, fufu)
+				}, timeout, interval).Should(Succeed())
+			})
+
+			It("pdb deleted by controller", func() {
+				Eventually(func() bool {
+					var pdb policyv1.PodDisruptionBudget
+					return errors.IsNotFound(k8sClient.Get(ctx, pdbNsn, &pdb))
+				}, timeout, interval).Should(BeTrue())
+			})
+		})
+
+		When("fufu references a class", func() {
+			var class catv1alpha2.FufuClass
+
+			BeforeEach(func() {
+				class = catv1alpha2.FufuClass{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "standard",
+					},
+					Spec: catv1alpha2.FufuClassSpec{
+						Template: catv1alpha2.PodTemplate{
+							Image: "nginx:1.23",
+						},
+					},
+				}
+				Expect(k8sClient.Create(ctx, &class)).Should(Succeed())
+
+				Eventually(func() error {
+					fufu := &catv1alpha2.Fufu{}
+					if err := k8sClient.Get(ctx, nsn, fufu); err != nil {
+						return err
+					}
+					fufu.Spec.ClassName = class.Name
+					return k8sClient.Update(ctx, fufu)
+				}, timeout, interval).Should(Succeed())
+			})
+
+			AfterEach(func() {
+				k8sClient.Delete(ctx, &class)
+			})
+
+			It("class' defaults merged and followed by controller", func() {
+				image := func() string {
+					d := &appsv1.Deployment{}
+					if err := k8sClient.Get(ctx, deployNsn, d); err != nil {
+						return ""
+					}
+					return d.Spec.Template.Spec.Containers[0].Image
+				}
+				Eventually(image, timeout, interval).Should(Equal("nginx:1.23"))
+
+				Eventually(func() error {
+					c := &catv1alpha2.FufuClass{}
+					if err := k8sClient.Get(ctx, types.NamespacedName{Name: class.Name}, c); err != nil {
+						return err
+					}
+					c.Spec.Template.Image = "nginx:1.24"
+					return k8sClient.Update(ctx, c)
+				}, timeout, interval).Should(Succeed())
+				Eventually(image, timeout, interval).Should(Equal("nginx:1.24"))
+			})
+		})
+
+		When("fufu's spec changed", func() {
+			var previous string
+
+			BeforeEach(func() {
+				Eventually(func() string {
+					fufu := &catv1alpha2.Fufu{}
+					if err := k8sClient.Get(ctx, nsn, fufu); err != nil {
+						return ""
+					}
+					previous = fufu.Status.CurrentRevision
+					return previous
+				}, timeout, interval).ShouldNot(BeEmpty())
+
+				Eventually(func() error {
+					fufu := &catv1alpha2.Fufu{}
+					if err := k8sClient.Get(ctx, nsn, fufu); err != nil {
+						return err
+					}
+					fufu.Spec.Color = "black"
+					return k8sClient.Update(ctx, fufu)
+				}, timeout, interval).Should(Succeed())
+			})
+
+			It("previous spec recorded and restored by controller", func() {
+				Eventually(func() string {
+					fufu := &catv1alpha2.Fufu{}
+					if err := k8sClient.Get(ctx, nsn, fufu); err != nil {
+						return previous
+					}
+					return fufu.Status.CurrentRevision
+				}, timeout, interval).ShouldNot(Equal(previous))
+
+				rev := &appsv1.ControllerRevision{}
+				Expect(k8sClient.Get(ctx, types.NamespacedName{Name: previous, Namespace: nsn.Namespace}, rev)).Should(Succeed())
+				Expect(rev.Labels).To(HaveKeyWithValue(revisionFufuLabel, nsn.Name))
+
+				Eventually(func() error {
+					fufu := &catv1alpha2.Fufu{}
+					if err := k8sClient.Get(ctx, nsn, fufu); err != nil {
+						return err
+					}
+					fufu.Annotations = map[string]string{catv1alpha2.RollbackToAnnotation: strconv.FormatInt(rev.Revision, 10)}
+					return k8sClient.Update(ctx, fufu)
+				}, timeout, interval).Should(Succeed())
+
+				Eventually(func() string {
+					fufu := &catv1alpha2.Fufu{}
+					if err := k8sClient.Get(ctx, nsn, fufu); err != nil {
+						return ""
+					}
+					if _, ok := fufu.Annotations[catv1alpha2.RollbackToAnnotation]; ok {
+						return ""
+					}
+					return fufu.Spec.Color
+				}, timeout, interval).Should(Equal("orange"))
+			})
+		})
+
+		When("fufu renames its service", func() {
+			renamedNsn := types.NamespacedName{Name: "fufu-web", Namespace: "default"}
+
+			BeforeEach(func() {
+				Eventually(func() string {
+					fufu := &catv1alpha2.Fufu{}
+					if err := k8sClient.Get(ctx, nsn, fufu); err != nil || fufu.Status.Resources == nil {
+						return ""
+					}
+					return fufu.Status.Resources.Service
+				}, timeout, interval).Should(Equal(svcNsn.Name))
+
+				Eventually(func() error {
+					fufu := &catv1alpha2.Fufu{}
+					if err := k8sClient.Get(ctx, nsn, fufu); err != nil {
+						return err
+					}
+					fufu.Spec.Service.Type = corev1.ServiceTypeClusterIP
+					fufu.Spec.Naming.Overrides = map[string]string{catv1alpha2.SuffixService: "{{.Name}}-web"}
+					return k8sClient.Update(ctx, fufu)
+				}, timeout, interval).Should(Succeed())
+			})
+
+			It("previous svc deleted once the pods are available", func() {
+				Eventually(func() error {
+					return k8sClient.Get(ctx, renamedNsn, &corev1.Service{})
+				}, timeout, interval).Should(Succeed())
+
+				// no pods run in the test environment, the previous svc is kept
+				Consistently(func() error {
+					return k8sClient.Get(ctx, svcNsn, &corev1.Service{})
+				}, time.Second*2, interval).Should(Succeed())
+
+				Eventually(func() error {
+					d := &appsv1.Deployment{}
+					if err := k8sClient.Get(ctx, deployNsn, d); err != nil {
+						return err
+					}
+					d.Status.ObservedGeneration = d.Generation
+					d.Status.Replicas, d.Status.UpdatedReplicas, d.Status.AvailableReplicas = *d.Spec.Replicas, *d.Spec.Replicas, *d.Spec.Replicas
+					return k8sClient.Status().Update(ctx, d)
+				}, timeout, interval).Should(Succeed())
+
+				Eventually(func() bool {
+					return errors.IsNotFound(k8sClient.Get(ctx, svcNsn, &corev1.Service{}))
+				}, timeout, interval).Should(BeTrue())
+
+				fufu := &catv1alpha2.Fufu{}
+				Expect(k8sClient.Get(ctx, nsn, fufu)).To(Succeed())
+				Expect(fufu.Status.Resources.Service).To(Equal(renamedNsn.Name))
+			})
+		})
+
+		When("fufu rolls a new image out through a canary", func() {
+			BeforeEach(func() {
+				Eventually(func() error {
+					return k8sClient.Get(ctx, deployNsn, &appsv1.Deployment{})
+				}, timeout, interval).Should(BeNil())
+
+				Eventually(func() error {
+					fufu := &catv1alpha2.Fufu{}
+					if err := k8sClient.Get(ctx, nsn, fufu); err != nil {
+						return err
+					}
+					fufu.Spec.RolloutStrategy.Canary = &catv1alpha2.CanaryStrategy{
+						Steps: []catv1alpha2.CanaryStep{{Weight: 20}, {Weight: 50}},
+					}
+					fufu.Spec.Template.Image = "nginx:1.23"
+					return k8sClient.Update(ctx, fufu)
+				}, timeout, interval).Should(Succeed())
+			})
+
+			It("new template run by the canary deploy only", func() {
+				canary := &appsv1.Deployment{}
+				Eventually(func() error {
+					return k8sClient.Get(ctx, types.NamespacedName{Name: "fufu-canary", Namespace: "default"}, canary)
+				}, timeout, interval).Should(BeNil())
+				Expect(canary.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx:1.23"))
+				Expect(canary.Spec.Template.Labels).To(HaveKeyWithValue("app", "fufu-deploy"))
+
+				// no pod runs in envtest, the first step waits for the canary to be ready
+				Eventually(func() catv1alpha2.CanaryPhase {
+					fufu := &catv1alpha2.Fufu{}
+					if err := k8sClient.Get(ctx, nsn, fufu); err != nil || fufu.Status.Canary == nil {
+						return ""
+					}
+					return fufu.Status.Canary.Phase
+				}, timeout, interval).Should(Equal(catv1alpha2.CanaryProgressing))
+
+				stable := &appsv1.Deployment{}
+				Expect(k8sClient.Get(ctx, deployNsn, stable)).Should(Succeed())
+				Expect(stable.Spec.Template.Spec.Containers[0].Image).To(Equal("nginx"))
+			})
+		})
+
+		When("fufu switches to blue-green", func() {
+			BeforeEach(func() {
+				Eventually(func() error {
+					return k8sClient.Get(ctx, deployNsn, &appsv1.Deployment{})
+				}, timeout, interval).Should(BeNil())
+
+				Eventually(func() error {
+					fufu := &catv1alpha2.Fufu{}
+					if err := k8sClient.Get(ctx, nsn, fufu); err != nil {
+						return err
+					}
+					fufu.Spec.RolloutStrategy.BlueGreen = &catv1alpha2.BlueGreenStrategy{}
+					return k8sClient.Update(ctx, fufu)
+				}, timeout, interval).Should(Succeed())
+			})
+
+			It("preview colour run next to the serving deploy by controller", func() {
+				preview := &appsv1.Deployment{}
+				Eventually(func() error {
+					return k8sClient.Get(ctx, types.NamespacedName{Name: "fufu-blue", Namespace: "default"}, preview)
+				}, timeout, interval).Should(BeNil())
+				Expect(preview.Spec.Template.Labels).To(HaveKeyWithValue(colorLabel, blue))
+
+				Eventually(func() string {
+					fufu := &catv1alpha2.Fufu{}
+					if err := k8sClient.Get(ctx, nsn, fufu); err != nil || fufu.Status.BlueGreen == nil {
+						return ""
+					}
+					return fufu.Status.BlueGreen.PreviewColor
+				}, timeout, interval).Should(Equal(blue))
+
+				// no pod runs in envtest, the svc keeps selecting the plain deploy's pods only
+				svc := &corev1.Service{}
+				Eventually(func() map[string]string {
+					if err := k8sClient.Get(ctx, svcNsn, svc); err != nil {
+						return nil
+					}
+					return svc.Spec.Selector
+				}, timeout, interval).Should(HaveKeyWithValue(trackLabel, stableTrack))
+				Expect(svc.Spec.Selector).NotTo(HaveKey(colorLabel))
+				Expect(preview.Spec.Template.Labels).NotTo(HaveKey(trackLabel))
+			})
+		})
+
+		When("fufu's rollout exceeds its progress deadline", func() {
+			BeforeEach(func() {
+				Eventually(func() error {
+					d := &appsv1.Deployment{}
+					if err := k8sClient.Get(ctx, deployNsn, d); err != nil {
+						return err
+					}
+					d.Status.Conditions = []appsv1.DeploymentCondition{{
+						Type:    appsv1.DeploymentProgressing,
+						Status:  corev1.ConditionFalse,
+						Reason:  "ProgressDeadlineExceeded",
+						Message: `ReplicaSet "fufu-deploy-5d4f" has timed out progressing.`,
+					}}
+					return k8sClient.Status().Update(ctx, d)
+				}, timeout, interval).Should(Succeed())
+			})
+
+			It("fufu marked degraded by controller", func() {
+				Eventually(func() string {
+					fufu := &catv1alpha2.Fufu{}
+					if err := k8sClient.Get(ctx, nsn, fufu); err != nil {
+						return ""
+					}
+					cond := meta.FindStatusCondition(fufu.Status.Conditions, catv1alpha2.ConditionDegraded)
+					if cond == nil || cond.Status != metav1.ConditionTrue {
+						return ""
+					}
+					return cond.Reason
+				}, timeout, interval).Should(Equal("ProgressDeadlineExceeded"))
+			})
 		})
 
 		When("the service is up", func() {
 			var (
 				deploy appsv1.Deployment
 				svc    corev1.Service
-				hpa    asv1.HorizontalPodAutoscaler
+				hpa    asv2.HorizontalPodAutoscaler
 			)
 
 			BeforeEach(func() {
@@ -145,6 +636,27 @@ var _ = Describe("Test controller", func() {
 				})
 			})
 
+			When("all the deploy's pods are ready", func() {
+				BeforeEach(func() {
+					deploy.Status.ObservedGeneration = deploy.Generation
+					deploy.Status.Replicas = 1
+					deploy.Status.UpdatedReplicas = 1
+					deploy.Status.ReadyReplicas = 1
+					deploy.Status.AvailableReplicas = 1
+					Expect(k8sClient.Status().Update(ctx, &deploy)).To(Succeed())
+				})
+
+				Specify("Fufu becomes Ready", func() {
+					Eventually(func() bool {
+						fufu := &catv1alpha2.Fufu{}
+						if err := k8sClient.Get(ctx, nsn, fufu); err != nil {
+							return false
+						}
+						return meta.IsStatusConditionTrue(fufu.Status.Conditions, catv1alpha2.ConditionReady)
+					}, timeout, interval).Should(BeTrue())
+				})
+			})
+
 			When("the svc's external ip changed", func() {
 				const extIP = "10.10.10.10"
 
@@ -168,6 +680,113 @@ var _ = Describe("Test controller", func() {
 						return fufu.Status.ExternalIP == extIP
 					}, timeout, interval).Should(BeTrue())
 				})
+
+				Specify("endpoint with the page's url in Fufu's status", func() {
+					Eventually(func() []catv1alpha2.Endpoint {
+						fufu := &catv1alpha2.Fufu{}
+						if err := k8sClient.Get(ctx, nsn, fufu); err != nil {
+							return nil
+						}
+						return fufu.Status.Endpoints
+					}, timeout, interval).Should(Equal([]catv1alpha2.Endpoint{
+						{IP: extIP, Port: 80, URL: "http://10.10.10.10/"},
+					}))
+				})
+
+				When("the LoadBalancer went away", func() {
+					BeforeEach(func() {
+						Eventually(func() string {
+							fufu := &catv1alpha2.Fufu{}
+							if err := k8sClient.Get(ctx, nsn, fufu); err != nil {
+								return ""
+							}
+							return fufu.Status.ExternalIP
+						}, timeout, interval).Should(Equal(extIP))
+
+						Eventually(func() error {
+							s := &corev1.Service{}
+							if err := k8sClient.Get(ctx, svcNsn, s); err != nil {
+								return err
+							}
+							s.Status.LoadBalancer = corev1.LoadBalancerStatus{}
+							return k8sClient.Status().Update(ctx, s)
+						}, timeout, interval).Should(Succeed())
+					})
+
+					Specify("stale addresses cleared from Fufu's status", func() {
+						Eventually(func() bool {
+							fufu := &catv1alpha2.Fufu{}
+							if err := k8sClient.Get(ctx, nsn, fufu); err != nil {
+								return false
+							}
+							return fufu.Status.ExternalIP == "" && len(fufu.Status.Endpoints) == 0
+						}, timeout, interval).Should(BeTrue())
+					})
+				})
+			})
+
+			When("the svc's LoadBalancer has a hostname", func() {
+				const hostname = "a1b2c3-123456789.eu-west-3.elb.amazonaws.com"
+
+				BeforeEach(func() {
+					svc.Status.LoadBalancer = corev1.LoadBalancerStatus{
+						Ingress: []corev1.LoadBalancerIngress{
+							{
+								Hostname: hostname,
+							},
+						},
+					}
+					Expect(k8sClient.Status().Update(ctx, &svc)).To(Succeed())
+				})
+
+				Specify("hostname reported in Fufu's status", func() {
+					Eventually(func() []catv1alpha2.Endpoint {
+						fufu := &catv1alpha2.Fufu{}
+						if err := k8sClient.Get(ctx, nsn, fufu); err != nil {
+							return nil
+						}
+						return fufu.Status.Endpoints
+					}, timeout, interval).Should(Equal([]catv1alpha2.Endpoint{
+						{Hostname: hostname, Port: 80, URL: "http://" + hostname + "/"},
+					}))
+
+					fufu := &catv1alpha2.Fufu{}
+					Expect(k8sClient.Get(ctx, nsn, fufu)).To(Succeed())
+					Expect(fufu.Status.ExternalIP).To(Equal(hostname))
+				})
+			})
+
+			When("the svc's LoadBalancer is dual-stack", func() {
+				BeforeEach(func() {
+					svc.Status.LoadBalancer = corev1.LoadBalancerStatus{
+						Ingress: []corev1.LoadBalancerIngress{
+							{
+								IP: "10.10.10.10",
+							},
+							{
+								IP: "2001:db8::10",
+							},
+						},
+					}
+					Expect(k8sClient.Status().Update(ctx, &svc)).To(Succeed())
+				})
+
+				Specify("both addresses reported in Fufu's status", func() {
+					Eventually(func() []catv1alpha2.Endpoint {
+						fufu := &catv1alpha2.Fufu{}
+						if err := k8sClient.Get(ctx, nsn, fufu); err != nil {
+							return nil
+						}
+						return fufu.Status.Endpoints
+					}, timeout, interval).Should(Equal([]catv1alpha2.Endpoint{
+						{IP: "10.10.10.10", Port: 80, URL: "http://10.10.10.10/"},
+						{IP: "2001:db8::10", Port: 80, URL: "http://[2001:db8::10]/"},
+					}))
+
+					fufu := &catv1alpha2.Fufu{}
+					Expect(k8sClient.Get(ctx, nsn, fufu)).To(Succeed())
+					Expect(fufu.Status.ExternalIP).To(Equal("10.10.10.10"))
+				})
 			})
 
 			Context("Check deploy strategy", func() {
@@ -247,7 +866,7 @@ var _ = Describe("Test controller", func() {
 
 					It("hpa's replica restored by controller", func() {
 						Eventually(func() bool {
-							h := &asv1.HorizontalPodAutoscaler{}
+							h := &asv2.HorizontalPodAutoscaler{}
 							if err := k8sClient.Get(ctx, hpaNsn, h); err != nil {
 								return false
 							}
@@ -258,4 +877,524 @@ var _ = Describe("Test controller", func() {
 			})
 		})
 	})
+
+	When("a fufu's autoscaling is disabled", func() {
+		var (
+			scaledNsn = types.NamespacedName{
+				Name:      "scaled",
+				Namespace: "default",
+			}
+			scaledDeployNsn = types.NamespacedName{
+				Name:      "scaled-deploy",
+				Namespace: "default",
+			}
+			created catv1alpha2.Fufu
+		)
+
+		BeforeEach(func() {
+			disabled, replicas := false, int32(3)
+			created = catv1alpha2.Fufu{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      scaledNsn.Name,
+					Namespace: scaledNsn.Namespace,
+				},
+				Spec: catv1alpha2.FufuSpec{
+					Color:       "orange",
+					Weight:      "5kg",
+					Autoscaling: catv1alpha2.AutoscalingSpec{Enabled: &disabled},
+					Replicas:    &replicas,
+				},
+			}
+			Expect(k8sClient.Create(ctx, &created)).Should(Succeed())
+		})
+
+		AfterEach(func() {
+			k8sClient.Delete(ctx, &created)
+			k8sClient.Delete(ctx, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: scaledDeployNsn.Name, Namespace: scaledDeployNsn.Namespace}})
+		})
+
+		It("deploy sized by spec.replicas without hpa", func() {
+			Eventually(func() int32 {
+				d := &appsv1.Deployment{}
+				if err := k8sClient.Get(ctx, scaledDeployNsn, d); err != nil || d.Spec.Replicas == nil {
+					return 0
+				}
+				return *d.Spec.Replicas
+			}, timeout, interval).Should(Equal(int32(3)))
+
+			Consistently(func() bool {
+				return errors.IsNotFound(k8sClient.Get(ctx, types.NamespacedName{Name: "scaled-hpa", Namespace: "default"}, &asv2.HorizontalPodAutoscaler{}))
+			}, time.Second, interval).Should(BeTrue())
+
+			Eventually(func() string {
+				fufu := &catv1alpha2.Fufu{}
+				if err := k8sClient.Get(ctx, scaledNsn, fufu); err != nil {
+					return ""
+				}
+				return fufu.Status.Selector
+			}, timeout, interval).Should(Equal("app=scaled-deploy"))
+		})
+	})
+
+	When("a fufu is put to sleep", func() {
+		var (
+			sleepyNsn = types.NamespacedName{
+				Name:      "sleepy",
+				Namespace: "default",
+			}
+			sleepyDeployNsn = types.NamespacedName{
+				Name:      "sleepy-deploy",
+				Namespace: "default",
+			}
+			placeholderNsn = types.NamespacedName{
+				Name:      "sleepy-sleeping",
+				Namespace: "default",
+			}
+			created catv1alpha2.Fufu
+		)
+
+		svcSelector := func() string {
+			s := &corev1.Service{}
+			if err := k8sClient.Get(ctx, types.NamespacedName{Name: "sleepy-svc", Namespace: "default"}, s); err != nil {
+				return ""
+			}
+			return s.Spec.Selector["app"]
+		}
+
+		BeforeEach(func() {
+			created = catv1alpha2.Fufu{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      sleepyNsn.Name,
+					Namespace: sleepyNsn.Namespace,
+				},
+				Spec: catv1alpha2.FufuSpec{
+					Color:       "orange",
+					Weight:      "5kg",
+					Hibernation: &catv1alpha2.HibernationSpec{Asleep: true},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &created)).Should(Succeed())
+		})
+
+		AfterEach(func() {
+			k8sClient.Delete(ctx, &created)
+			for _, name := range []string{sleepyDeployNsn.Name, placeholderNsn.Name} {
+				k8sClient.Delete(ctx, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}})
+			}
+		})
+
+		It("deploy scaled to zero and placeholder served by controller", func() {
+			Eventually(func() error {
+				return k8sClient.Get(ctx, placeholderNsn, &appsv1.Deployment{})
+			}, timeout, interval).Should(BeNil())
+			Eventually(svcSelector, timeout, interval).Should(Equal("sleepy-sleeping"))
+
+			d := &appsv1.Deployment{}
+			Expect(k8sClient.Get(ctx, sleepyDeployNsn, d)).To(Succeed())
+			Expect(d.Spec.Replicas).NotTo(BeNil())
+			Expect(*d.Spec.Replicas).To(Equal(int32(0)))
+
+			fufu := &catv1alpha2.Fufu{}
+			Expect(k8sClient.Get(ctx, sleepyNsn, fufu)).To(Succeed())
+			Expect(meta.IsStatusConditionTrue(fufu.Status.Conditions, catv1alpha2.ConditionHibernating)).To(BeTrue())
+		})
+
+		When("the fufu wakes up", func() {
+			BeforeEach(func() {
+				Eventually(func() error {
+					return k8sClient.Get(ctx, placeholderNsn, &appsv1.Deployment{})
+				}, timeout, interval).Should(BeNil())
+
+				Eventually(func() error {
+					fufu := &catv1alpha2.Fufu{}
+					if err := k8sClient.Get(ctx, sleepyNsn, fufu); err != nil {
+						return err
+					}
+					fufu.Spec.Hibernation.Asleep = false
+					return k8sClient.Update(ctx, fufu)
+				}, timeout, interval).Should(Succeed())
+			})
+
+			It("placeholder removed and fufu's pods served again once available", func() {
+				Eventually(func() int32 {
+					d := &appsv1.Deployment{}
+					if err := k8sClient.Get(ctx, sleepyDeployNsn, d); err != nil || d.Spec.Replicas == nil {
+						return 0
+					}
+					return *d.Spec.Replicas
+				}, timeout, interval).Should(Equal(int32(2)))
+
+				By("keep serving the placeholder while the pods start", func() {
+					Consistently(svcSelector, time.Second*2, interval).Should(Equal("sleepy-sleeping"))
+					Expect(k8sClient.Get(ctx, placeholderNsn, &appsv1.Deployment{})).To(Succeed())
+				})
+
+				// no pod runs in envtest, the deployment controller is played by hand
+				Eventually(func() error {
+					d := &appsv1.Deployment{}
+					if err := k8sClient.Get(ctx, sleepyDeployNsn, d); err != nil {
+						return err
+					}
+					d.Status.Replicas, d.Status.AvailableReplicas = 2, 2
+					return k8sClient.Status().Update(ctx, d)
+				}, timeout, interval).Should(Succeed())
+
+				Eventually(svcSelector, timeout, interval).Should(Equal("sleepy-deploy"))
+				Eventually(func() bool {
+					return errors.IsNotFound(k8sClient.Get(ctx, placeholderNsn, &appsv1.Deployment{}))
+				}, timeout, interval).Should(BeTrue())
+			})
+		})
+	})
+
+	When("a fufu changes outside its maintenance windows", func() {
+		var (
+			patientNsn = types.NamespacedName{
+				Name:      "patient",
+				Namespace: "default",
+			}
+			patientDeployNsn = types.NamespacedName{
+				Name:      "patient-deploy",
+				Namespace: "default",
+			}
+			created catv1alpha2.Fufu
+		)
+
+		deployImage := func() string {
+			d := &appsv1.Deployment{}
+			if err := k8sClient.Get(ctx, patientDeployNsn, d); err != nil {
+				return ""
+			}
+			return d.Spec.Template.Spec.Containers[0].Image
+		}
+
+		BeforeEach(func() {
+			created = catv1alpha2.Fufu{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      patientNsn.Name,
+					Namespace: patientNsn.Namespace,
+				},
+				Spec: catv1alpha2.FufuSpec{
+					Color:  "orange",
+					Weight: "5kg",
+					// only opens on new year's day
+					MaintenanceWindows: []catv1alpha2.MaintenanceWindow{
+						{Start: "0 0 1 1 *", Duration: metav1.Duration{Duration: time.Minute}},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &created)).Should(Succeed())
+			Eventually(deployImage, timeout, interval).Should(Equal(defaultWebImage))
+
+			Eventually(func() error {
+				fufu := &catv1alpha2.Fufu{}
+				if err := k8sClient.Get(ctx, patientNsn, fufu); err != nil {
+					return err
+				}
+				fufu.Spec.Template.Image = "nginx:1.23"
+				return k8sClient.Update(ctx, fufu)
+			}, timeout, interval).Should(Succeed())
+		})
+
+		AfterEach(func() {
+			k8sClient.Delete(ctx, &created)
+			k8sClient.Delete(ctx, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: patientDeployNsn.Name, Namespace: "default"}})
+		})
+
+		It("new image held back by controller", func() {
+			Eventually(func() []string {
+				fufu := &catv1alpha2.Fufu{}
+				if err := k8sClient.Get(ctx, patientNsn, fufu); err != nil || fufu.Status.Maintenance == nil {
+					return nil
+				}
+				return fufu.Status.Maintenance.PendingChanges
+			}, timeout, interval).Should(ContainElement("image of web: nginx:1.23"))
+			Consistently(deployImage, time.Second, interval).Should(Equal(defaultWebImage))
+
+			fufu := &catv1alpha2.Fufu{}
+			Expect(k8sClient.Get(ctx, patientNsn, fufu)).To(Succeed())
+			Expect(meta.IsStatusConditionTrue(fufu.Status.Conditions, catv1alpha2.ConditionRolloutPending)).To(BeTrue())
+		})
+
+		It("new image rolled out at once with the rollout-now annotation", func() {
+			Eventually(func() error {
+				fufu := &catv1alpha2.Fufu{}
+				if err := k8sClient.Get(ctx, patientNsn, fufu); err != nil {
+					return err
+				}
+				if fufu.Annotations == nil {
+					fufu.Annotations = map[string]string{}
+				}
+				fufu.Annotations[catv1alpha2.RolloutNowAnnotation] = "true"
+				return k8sClient.Update(ctx, fufu)
+			}, timeout, interval).Should(Succeed())
+
+			Eventually(deployImage, timeout, interval).Should(Equal("nginx:1.23"))
+			Eventually(func() bool {
+				fufu := &catv1alpha2.Fufu{}
+				if err := k8sClient.Get(ctx, patientNsn, fufu); err != nil {
+					return false
+				}
+				_, ok := fufu.Annotations[catv1alpha2.RolloutNowAnnotation]
+				return !ok
+			}, timeout, interval).Should(BeTrue())
+		})
+	})
+
+	When("a fufu's rollout gets stuck outside its maintenance windows", func() {
+		var (
+			relapseNsn = types.NamespacedName{
+				Name:      "relapse",
+				Namespace: "default",
+			}
+			relapseDeployNsn = types.NamespacedName{
+				Name:      "relapse-deploy",
+				Namespace: "default",
+			}
+			created catv1alpha2.Fufu
+			working string
+		)
+
+		deployImage := func() string {
+			d := &appsv1.Deployment{}
+			if err := k8sClient.Get(ctx, relapseDeployNsn, d); err != nil {
+				return ""
+			}
+			return d.Spec.Template.Spec.Containers[0].Image
+		}
+
+		BeforeEach(func() {
+			created = catv1alpha2.Fufu{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      relapseNsn.Name,
+					Namespace: relapseNsn.Namespace,
+				},
+				Spec: catv1alpha2.FufuSpec{
+					Color:           "orange",
+					Weight:          "5kg",
+					RolloutStrategy: catv1alpha2.RolloutStrategy{AutoRollback: true},
+					// only opens on new year's day
+					MaintenanceWindows: []catv1alpha2.MaintenanceWindow{
+						{Start: "0 0 1 1 *", Duration: metav1.Duration{Duration: time.Minute}},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &created)).Should(Succeed())
+
+			// no pod runs in envtest, the deploy is reported available
+			Eventually(func() error {
+				d := &appsv1.Deployment{}
+				if err := k8sClient.Get(ctx, relapseDeployNsn, d); err != nil {
+					return err
+				}
+				d.Status.ObservedGeneration = d.Generation
+				d.Status.Replicas, d.Status.UpdatedReplicas = *d.Spec.Replicas, *d.Spec.Replicas
+				d.Status.ReadyReplicas, d.Status.AvailableReplicas = *d.Spec.Replicas, *d.Spec.Replicas
+				return k8sClient.Status().Update(ctx, d)
+			}, timeout, interval).Should(Succeed())
+			Eventually(func() string {
+				fufu := &catv1alpha2.Fufu{}
+				if err := k8sClient.Get(ctx, relapseNsn, fufu); err != nil || fufu.Status.LastAvailableRevision != fufu.Status.CurrentRevision {
+					return ""
+				}
+				return fufu.Status.LastAvailableRevision
+			}, timeout, interval).ShouldNot(BeEmpty())
+			fufu := &catv1alpha2.Fufu{}
+			Expect(k8sClient.Get(ctx, relapseNsn, fufu)).To(Succeed())
+			working = fufu.Status.LastAvailableRevision
+
+			// a broken image forced out of the windows
+			Eventually(func() error {
+				fufu := &catv1alpha2.Fufu{}
+				if err := k8sClient.Get(ctx, relapseNsn, fufu); err != nil {
+					return err
+				}
+				fufu.Spec.Template.Image = "nginx:broken"
+				fufu.Annotations = map[string]string{catv1alpha2.RolloutNowAnnotation: "true"}
+				return k8sClient.Update(ctx, fufu)
+			}, timeout, interval).Should(Succeed())
+			Eventually(deployImage, timeout, interval).Should(Equal("nginx:broken"))
+			Eventually(func() string {
+				fufu := &catv1alpha2.Fufu{}
+				if err := k8sClient.Get(ctx, relapseNsn, fufu); err != nil {
+					return working
+				}
+				return fufu.Status.CurrentRevision
+			}, timeout, interval).ShouldNot(Equal(working))
+		})
+
+		AfterEach(func() {
+			k8sClient.Delete(ctx, &created)
+			k8sClient.Delete(ctx, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: relapseDeployNsn.Name, Namespace: "default"}})
+		})
+
+		It("working spec rolled back out at once by controller", func() {
+			Eventually(func() error {
+				d := &appsv1.Deployment{}
+				if err := k8sClient.Get(ctx, relapseDeployNsn, d); err != nil {
+					return err
+				}
+				d.Status.Conditions = []appsv1.DeploymentCondition{{
+					Type:    appsv1.DeploymentProgressing,
+					Status:  corev1.ConditionFalse,
+					Reason:  "ProgressDeadlineExceeded",
+					Message: `ReplicaSet "relapse-deploy-5d4f" has timed out progressing.`,
+				}}
+				return k8sClient.Status().Update(ctx, d)
+			}, timeout, interval).Should(Succeed())
+
+			Eventually(deployImage, timeout, interval).Should(Equal(defaultWebImage))
+			Eventually(func() bool {
+				fufu := &catv1alpha2.Fufu{}
+				if err := k8sClient.Get(ctx, relapseNsn, fufu); err != nil || fufu.Spec.Template.Image != "" {
+					return false
+				}
+				_, rollback := fufu.Annotations[catv1alpha2.RollbackToAnnotation]
+				_, rolloutNow := fufu.Annotations[catv1alpha2.RolloutNowAnnotation]
+				return !rollback && !rolloutNow
+			}, timeout, interval).Should(BeTrue())
+		})
+	})
+
+	When("a deployment already has the fufu's name", func() {
+		var (
+			webNsn = types.NamespacedName{
+				Name:      "web",
+				Namespace: "default",
+			}
+			webDeployNsn = types.NamespacedName{
+				Name:      "web-deploy",
+				Namespace: "default",
+			}
+			existing appsv1.Deployment
+			created  catv1alpha2.Fufu
+			app      string
+			policy   catv1alpha2.AdoptionPolicy
+		)
+
+		conflictReason := func() string {
+			fufu := &catv1alpha2.Fufu{}
+			if err := k8sClient.Get(ctx, webNsn, fufu); err != nil {
+				return ""
+			}
+			cond := meta.FindStatusCondition(fufu.Status.Conditions, catv1alpha2.ConditionOwnershipConflict)
+			if cond == nil || cond.Status != metav1.ConditionTrue {
+				return ""
+			}
+			return cond.Reason
+		}
+
+		JustBeforeEach(func() {
+			labels := map[string]string{"app": app}
+			existing = appsv1.Deployment{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      webDeployNsn.Name,
+					Namespace: webDeployNsn.Namespace,
+				},
+				Spec: appsv1.DeploymentSpec{
+					Selector: &metav1.LabelSelector{MatchLabels: labels},
+					Template: corev1.PodTemplateSpec{
+						ObjectMeta: metav1.ObjectMeta{Labels: labels},
+						Spec: corev1.PodSpec{
+							Containers: []corev1.Container{{Name: "web", Image: "nginx"}},
+						},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &existing)).Should(Succeed())
+
+			created = catv1alpha2.Fufu{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      webNsn.Name,
+					Namespace: webNsn.Namespace,
+				},
+				Spec: catv1alpha2.FufuSpec{
+					Color:          "orange",
+					Weight:         "5kg",
+					AdoptionPolicy: policy,
+				},
+			}
+			Expect(k8sClient.Create(ctx, &created)).Should(Succeed())
+		})
+
+		AfterEach(func() {
+			k8sClient.Delete(ctx, &created)
+			k8sClient.Delete(ctx, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: webDeployNsn.Name, Namespace: webDeployNsn.Namespace}})
+			policy = ""
+		})
+
+		Context("selecting the fufu's pods", func() {
+			BeforeEach(func() {
+				app = "web-deploy"
+			})
+
+			It("deploy not adopted without label", func() {
+				Eventually(conflictReason, timeout, interval).Should(Equal("NotLabelled"))
+
+				d := &appsv1.Deployment{}
+				Expect(k8sClient.Get(ctx, webDeployNsn, d)).To(Succeed())
+				Expect(d.OwnerReferences).To(BeEmpty())
+				Expect(d.Spec.Template.Spec.Containers).To(HaveLen(1))
+			})
+
+			When("the deploy gets labelled", func() {
+				JustBeforeEach(func() {
+					Eventually(conflictReason, timeout, interval).Should(Equal("NotLabelled"))
+
+					Eventually(func() error {
+						d := &appsv1.Deployment{}
+						if err := k8sClient.Get(ctx, webDeployNsn, d); err != nil {
+							return err
+						}
+						d.Labels = map[string]string{catv1alpha2.FufuLabel: webNsn.Name}
+						return k8sClient.Update(ctx, d)
+					}, timeout, interval).Should(Succeed())
+				})
+
+				It("deploy adopted by the fufu", func() {
+					Eventually(func() bool {
+						d := &appsv1.Deployment{}
+						if err := k8sClient.Get(ctx, webDeployNsn, d); err != nil {
+							return false
+						}
+						owner := metav1.GetControllerOf(d)
+						return owner != nil && owner.UID == created.UID
+					}, timeout, interval).Should(BeTrue())
+					Eventually(conflictReason, timeout, interval).Should(BeEmpty())
+				})
+			})
+
+			When("the fufu never adopts", func() {
+				BeforeEach(func() {
+					policy = catv1alpha2.AdoptNever
+				})
+
+				It("conflict reported by controller", func() {
+					Eventually(conflictReason, timeout, interval).Should(Equal("AdoptionDisabled"))
+				})
+			})
+		})
+
+		Context("selecting other pods", func() {
+			BeforeEach(func() {
+				app = "web"
+			})
+
+			When("the fufu adopts by force", func() {
+				BeforeEach(func() {
+					policy = catv1alpha2.AdoptForce
+				})
+
+				It("deploy recreated with the fufu's selector", func() {
+					Eventually(func() bool {
+						d := &appsv1.Deployment{}
+						if err := k8sClient.Get(ctx, webDeployNsn, d); err != nil {
+							return false
+						}
+						return metav1.IsControlledBy(d, &created) && d.Spec.Selector.MatchLabels["app"] == "web-deploy"
+					}, timeout, interval).Should(BeTrue())
+				})
+			})
+		})
+	})
 })
@@ -0,0 +1,262 @@
+/*
+Copyright 2022 huo.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrutil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+// FufuHouseholdReconciler reconciles a FufuHousehold object
+type FufuHouseholdReconciler struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+}
+
+//+kubebuilder:rbac:groups=cat.huozj.io,resources=fufuhouseholds,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=cat.huozj.io,resources=fufuhouseholds/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=cat.huozj.io,resources=fufuhouseholds/finalizers,verbs=update
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile gathers the Fufus selected by the household behind a single entrypoint: an nginx
+// serving an index page and proxying a path per member to the member's Service
+func (r *FufuHouseholdReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	loggr := log.FromContext(ctx)
+
+	hh := &catv1alpha2.FufuHousehold{}
+	if err := r.Get(ctx, req.NamespacedName, hh); err != nil {
+		err = client.IgnoreNotFound(err)
+		return ctrl.Result{}, err
+	}
+	status := hh.Status.DeepCopy()
+
+	members, err := r.listMembers(hh, ctx)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	loggr.Info(fmt.Sprintf("Household has %d members", len(members)))
+
+	data, err := renderHousehold(hh, members)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	cm := createHouseholdConfigMap(hh, data)
+	if err := r.apply(hh, "ConfigMap", cm, &corev1.ConfigMap{}, func(had client.Object) bool {
+		return !equality.Semantic.DeepEqual(cm.Data, had.(*corev1.ConfigMap).Data)
+	}, ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	deploy := createHouseholdDeploy(hh, hashData(data))
+	if err := r.apply(hh, "Deployment", deploy, &appsv1.Deployment{}, func(had client.Object) bool {
+		return deployChanged(deploy, had.(*appsv1.Deployment))
+	}, ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	svc := createHouseholdSvc(hh)
+	if err := r.apply(hh, "Service", svc, &corev1.Service{}, func(had client.Object) bool {
+		return svc.Spec.Type != had.(*corev1.Service).Spec.Type || !equality.Semantic.DeepDerivative(svc.Spec, had.(*corev1.Service).Spec)
+	}, ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := r.updateIngress(hh, ctx); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	hh.Status.Members = members
+	hh.Status.MemberCount = int32(len(members))
+	hh.Status.ReadyMembers = 0
+	for _, m := range members {
+		if m.Ready {
+			hh.Status.ReadyMembers++
+		}
+	}
+
+	if !equality.Semantic.DeepEqual(status, &hh.Status) {
+		if status.MemberCount != hh.Status.MemberCount {
+			r.Recorder.Eventf(hh, corev1.EventTypeNormal, "members-updated", "Household has %d members", hh.Status.MemberCount)
+		}
+		if err := r.Status().Update(ctx, hh); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// listMembers returns the Fufus selected by the household, only the ones whose Service exists
+// are routable: nginx refuses to start with an unresolvable upstream
+func (r *FufuHouseholdReconciler) listMembers(hh *catv1alpha2.FufuHousehold, ctx context.Context) ([]catv1alpha2.HouseholdMember, error) {
+	selector, err := metav1.LabelSelectorAsSelector(&hh.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	fufus := &catv1alpha2.FufuList{}
+	if err := r.List(ctx, fufus, client.InNamespace(hh.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+
+	members := []catv1alpha2.HouseholdMember{}
+	for i := range fufus.Items {
+		fufu := &fufus.Items[i]
+		if !fufu.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		// the class of the Fufu may rename its children, the name in use is recorded
+		svcName := childName(fufu, catv1alpha2.SuffixService)
+		if fufu.Status.Resources != nil {
+			svcName = fufu.Status.Resources.Service
+		}
+
+		svc := &corev1.Service{}
+		if err := r.Get(ctx, types.NamespacedName{Name: svcName, Namespace: fufu.Namespace}, svc); err != nil {
+			if err = client.IgnoreNotFound(err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		members = append(members, catv1alpha2.HouseholdMember{
+			Name:    fufu.Name,
+			Path:    "/" + fufu.Name + "/",
+			Service: svcName,
+			Ready:   meta.IsStatusConditionTrue(fufu.Status.Conditions, catv1alpha2.ConditionReady),
+		})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+
+	return members, nil
+}
+
+// apply creates wanted, or updates it when changed tells it drifted from the existing object
+func (r *FufuHouseholdReconciler) apply(hh *catv1alpha2.FufuHousehold, kind string, wanted, had client.Object, changed func(had client.Object) bool, ctx context.Context) error {
+	loggr := log.FromContext(ctx)
+
+	if err := r.Get(ctx, types.NamespacedName{Name: wanted.GetName(), Namespace: wanted.GetNamespace()}, had); err == nil {
+		if changed(had) {
+			loggr.Info(fmt.Sprintf("A diff was found, update household's %s ...", kind))
+			ctrutil.SetControllerReference(hh, wanted, r.Scheme)
+			wanted.SetResourceVersion(had.GetResourceVersion())
+			if err = r.Update(ctx, wanted); err != nil {
+				return err
+			}
+		}
+		return nil
+	} else {
+		if err = client.IgnoreNotFound(err); err != nil {
+			return err
+		}
+
+		loggr.Info(fmt.Sprintf("Create household's %s ...", kind))
+		ctrutil.SetControllerReference(hh, wanted, r.Scheme)
+		if err = r.Create(ctx, wanted); err != nil {
+			loggr.Error(err, fmt.Sprintf("failed to create household's %s", kind))
+		}
+
+		r.Recorder.Eventf(hh, corev1.EventTypeNormal, "household-created", "Household's %s created", kind)
+		return nil
+	}
+}
+
+func (r *FufuHouseholdReconciler) updateIngress(hh *catv1alpha2.FufuHousehold, ctx context.Context) error {
+	if hh.Spec.Ingress != nil {
+		ing := createHouseholdIngress(hh)
+		return r.apply(hh, "Ingress", ing, &netv1.Ingress{}, func(had client.Object) bool {
+			return !equality.Semantic.DeepDerivative(ing.Spec, had.(*netv1.Ingress).Spec)
+		}, ctx)
+	}
+
+	had := &netv1.Ingress{}
+	if err := r.Get(ctx, types.NamespacedName{Name: householdChildName(hh), Namespace: hh.Namespace}, had); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(had, hh) {
+		return nil
+	}
+
+	return client.IgnoreNotFound(r.Delete(ctx, had))
+}
+
+// findHouseholds maps a Fufu to the households selecting it, or having it as a member
+// so a Fufu removed from a household is dropped from the index
+func (r *FufuHouseholdReconciler) findHouseholds(obj client.Object) []reconcile.Request {
+	households := &catv1alpha2.FufuHouseholdList{}
+	if err := r.List(context.Background(), households, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+
+	reqs := []reconcile.Request{}
+	for _, hh := range households.Items {
+		selector, err := metav1.LabelSelectorAsSelector(&hh.Spec.Selector)
+		if err != nil {
+			continue
+		}
+
+		member := false
+		for _, m := range hh.Status.Members {
+			member = member || m.Name == obj.GetName()
+		}
+
+		if member || selector.Matches(labels.Set(obj.GetLabels())) {
+			reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Name: hh.Name, Namespace: hh.Namespace}})
+		}
+	}
+
+	return reqs
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *FufuHouseholdReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.Recorder = mgr.GetEventRecorderFor("FufuHousehold")
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&catv1alpha2.FufuHousehold{}).
+		Owns(&corev1.ConfigMap{}).
+		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.Service{}).
+		Owns(&netv1.Ingress{}).
+		Watches(&source.Kind{Type: &catv1alpha2.Fufu{}}, handler.EnqueueRequestsFromMapFunc(r.findHouseholds)).
+		Complete(r)
+}
@@ -0,0 +1,182 @@
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/types"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Test household controller", func() {
+	const (
+		timeout  = time.Second * 10
+		interval = time.Second * 1
+	)
+
+	var (
+		hhNsn = types.NamespacedName{
+			Name:      "huo",
+			Namespace: "default",
+		}
+		childNsn = types.NamespacedName{
+			Name:      "huo-household",
+			Namespace: "default",
+		}
+	)
+
+	When("create a household selecting a fufu", func() {
+		var (
+			member    catv1alpha2.Fufu
+			household catv1alpha2.FufuHousehold
+		)
+
+		BeforeEach(func() {
+			member = catv1alpha2.Fufu{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "tabby",
+					Namespace: "default",
+					Labels:    map[string]string{"household": "huo"},
+				},
+				Spec: catv1alpha2.FufuSpec{
+					Color:  "grey",
+					Weight: "4kg",
+				},
+			}
+			Expect(k8sClient.Create(ctx, &member)).Should(Succeed())
+
+			household = catv1alpha2.FufuHousehold{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      hhNsn.Name,
+					Namespace: hhNsn.Namespace,
+				},
+				Spec: catv1alpha2.FufuHouseholdSpec{
+					Selector: metav1.LabelSelector{
+						MatchLabels: map[string]string{"household": "huo"},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &household)).Should(Succeed())
+		})
+
+		AfterEach(func() {
+			k8sClient.Delete(ctx, &household)
+			k8sClient.Delete(ctx, &member)
+		})
+
+		Specify("one entrypoint routing to every member", func() {
+			By("report the member in status", func() {
+				Eventually(func() int32 {
+					hh := &catv1alpha2.FufuHousehold{}
+					if err := k8sClient.Get(ctx, hhNsn, hh); err != nil {
+						return 0
+					}
+					return hh.Status.MemberCount
+				}, timeout, interval).Should(Equal(int32(1)))
+			})
+
+			By("render a path per member", func() {
+				cm := &corev1.ConfigMap{}
+				Eventually(func() error {
+					return k8sClient.Get(ctx, childNsn, cm)
+				}, timeout, interval).Should(BeNil())
+				Expect(cm.Data["index.html"]).To(ContainSubstring(`href="/tabby/"`))
+				Expect(cm.Data[nginxConfFile]).To(ContainSubstring("proxy_pass http://tabby-svc/;"))
+			})
+
+			By("create the entrypoint", func() {
+				Eventually(func() error {
+					return k8sClient.Get(ctx, childNsn, &appsv1.Deployment{})
+				}, timeout, interval).Should(BeNil())
+
+				svc := &corev1.Service{}
+				Eventually(func() error {
+					return k8sClient.Get(ctx, childNsn, svc)
+				}, timeout, interval).Should(BeNil())
+				Expect(svc.Spec.Type).To(Equal(corev1.ServiceTypeLoadBalancer))
+			})
+
+			By("drop the fufu leaving the household", func() {
+				Eventually(func() error {
+					fufu := &catv1alpha2.Fufu{}
+					if err := k8sClient.Get(ctx, types.NamespacedName{Name: "tabby", Namespace: "default"}, fufu); err != nil {
+						return err
+					}
+					fufu.Labels = nil
+					return k8sClient.Update(ctx, fufu)
+				}, timeout, interval).Should(Succeed())
+
+				Eventually(func() int32 {
+					hh := &catv1alpha2.FufuHousehold{}
+					if err := k8sClient.Get(ctx, hhNsn, hh); err != nil {
+						return -1
+					}
+					return hh.Status.MemberCount
+				}, timeout, interval).Should(Equal(int32(0)))
+			})
+		})
+	})
+
+	When("a household selects a fufu behind a network policy", func() {
+		var (
+			member    catv1alpha2.Fufu
+			household catv1alpha2.FufuHousehold
+		)
+
+		BeforeEach(func() {
+			member = catv1alpha2.Fufu{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "calico",
+					Namespace: "default",
+					Labels:    map[string]string{"household": "huo"},
+				},
+				Spec: catv1alpha2.FufuSpec{
+					Color:         "calico",
+					Weight:        "4kg",
+					NetworkPolicy: &catv1alpha2.NetworkPolicySpec{AllowedNamespaces: []string{"front"}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &member)).Should(Succeed())
+
+			household = catv1alpha2.FufuHousehold{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      hhNsn.Name,
+					Namespace: hhNsn.Namespace,
+				},
+				Spec: catv1alpha2.FufuHouseholdSpec{
+					Selector: metav1.LabelSelector{
+						MatchLabels: map[string]string{"household": "huo"},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &household)).Should(Succeed())
+		})
+
+		AfterEach(func() {
+			k8sClient.Delete(ctx, &household)
+			k8sClient.Delete(ctx, &member)
+		})
+
+		It("household's pods let in by the member's policy", func() {
+			Eventually(func() []netv1.NetworkPolicyPeer {
+				netpol := &netv1.NetworkPolicy{}
+				if err := k8sClient.Get(ctx, types.NamespacedName{Name: "calico-netpol", Namespace: "default"}, netpol); err != nil || len(netpol.Spec.Ingress) == 0 {
+					return nil
+				}
+				return netpol.Spec.Ingress[0].From
+			}, timeout, interval).Should(ContainElement(netv1.NetworkPolicyPeer{
+				PodSelector: &metav1.LabelSelector{
+					MatchExpressions: []metav1.LabelSelectorRequirement{
+						{Key: "app", Operator: metav1.LabelSelectorOpIn, Values: []string{childNsn.Name}},
+					},
+				},
+			}))
+		})
+	})
+})
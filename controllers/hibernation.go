@@ -0,0 +1,292 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrutil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+// wakeCheckDelay is how often the pods of a Fufu waking up are checked for availability
+const wakeCheckDelay = 5 * time.Second
+
+// updateHibernation tells from the windows if the Fufu sleeps at now, the children are sized
+// and served accordingly. It returns the delay until the windows change that
+func (r *FufuReconciler) updateHibernation(fufu *catv1alpha2.Fufu, now time.Time) time.Duration {
+	spec, prev := fufu.Spec.Hibernation, fufu.Status.Hibernation
+	wasAsleep := prev != nil && prev.Asleep
+	// the placeholder serves until the pods woken up are available, see updatePlaceholder
+	waking := wasAsleep || wakingUp(fufu)
+
+	if spec == nil {
+		fufu.Status.Hibernation = nil
+		if waking {
+			fufu.Status.Hibernation = &catv1alpha2.HibernationStatus{WakingUp: true}
+		}
+		meta.RemoveStatusCondition(&fufu.Status.Conditions, catv1alpha2.ConditionHibernating)
+		if wasAsleep {
+			r.Recorder.Event(fufu, corev1.EventTypeNormal, "fufu-awake", "Hibernation removed, Fufu woken up")
+		}
+		return 0
+	}
+
+	asleep, next, err := hibernationState(spec, now)
+	if err != nil {
+		// rejected by the webhook, unless it is disabled
+		asleep, next = spec.Asleep, time.Time{}
+	}
+
+	st := &catv1alpha2.HibernationStatus{Asleep: asleep, WakingUp: waking && !asleep}
+	cond := metav1.Condition{
+		Type:               catv1alpha2.ConditionHibernating,
+		Status:             metav1.ConditionFalse,
+		Reason:             "Awake",
+		Message:            "Awake",
+		ObservedGeneration: fufu.Generation,
+	}
+	if !next.IsZero() {
+		st.NextTransition = &metav1.Time{Time: next}
+		cond.Message = fmt.Sprintf("Awake until %s", next.Format(time.RFC3339))
+	}
+	if asleep {
+		cond.Status, cond.Reason = metav1.ConditionTrue, "Asleep"
+		cond.Message = "Asleep until spec.hibernation.asleep is unset"
+		if !spec.Asleep && !next.IsZero() {
+			cond.Message = fmt.Sprintf("Asleep until %s", next.Format(time.RFC3339))
+		}
+	}
+
+	if err != nil {
+		cond.Reason, cond.Message = "InvalidWindows", fmt.Sprintf("Windows ignored: %v", err)
+		if prev := meta.FindStatusCondition(fufu.Status.Conditions, catv1alpha2.ConditionHibernating); prev == nil || prev.Reason != cond.Reason || prev.Message != cond.Message {
+			r.Recorder.Event(fufu, corev1.EventTypeWarning, "invalid-hibernation", cond.Message)
+		}
+	}
+
+	if asleep != wasAsleep {
+		if asleep {
+			r.Recorder.Event(fufu, corev1.EventTypeNormal, "fufu-asleep", cond.Message)
+		} else {
+			r.Recorder.Event(fufu, corev1.EventTypeNormal, "fufu-awake", cond.Message)
+		}
+	}
+	meta.SetStatusCondition(&fufu.Status.Conditions, cond)
+	fufu.Status.Hibernation = st
+
+	if next.IsZero() {
+		return 0
+	}
+	return next.Sub(now)
+}
+
+// hibernationState tells if the Fufu sleeps at now and when the windows next change that, in
+// the time zone of the windows. A window sleeps if its next wake comes before its next sleep
+func hibernationState(spec *catv1alpha2.HibernationSpec, now time.Time) (bool, time.Time, error) {
+	loc, err := spec.Location()
+	if err != nil {
+		return false, time.Time{}, err
+	}
+	now = now.In(loc)
+
+	asleep := spec.Asleep
+	var next time.Time
+	for _, w := range spec.Windows {
+		sleep, err := catv1alpha2.ParseSchedule(w.Sleep)
+		if err != nil {
+			return false, time.Time{}, fmt.Errorf("sleep %q: %w", w.Sleep, err)
+		}
+		wake, err := catv1alpha2.ParseSchedule(w.Wake)
+		if err != nil {
+			return false, time.Time{}, fmt.Errorf("wake %q: %w", w.Wake, err)
+		}
+
+		nextSleep, nextWake := sleep.Next(now), wake.Next(now)
+		if !nextWake.IsZero() && (nextSleep.IsZero() || nextWake.Before(nextSleep)) {
+			asleep = true
+		}
+		for _, t := range []time.Time{nextSleep, nextWake} {
+			if !t.IsZero() && (next.IsZero() || t.Before(next)) {
+				next = t
+			}
+		}
+	}
+
+	return asleep, next, nil
+}
+
+// asleep tells if the Fufu sleeps, as found by updateHibernation
+func asleep(fufu *catv1alpha2.Fufu) bool {
+	return fufu.Spec.Hibernation != nil && fufu.Status.Hibernation != nil && fufu.Status.Hibernation.Asleep
+}
+
+// wakingUp tells if the Fufu's placeholder still serves while its pods start
+func wakingUp(fufu *catv1alpha2.Fufu) bool {
+	return fufu.Status.Hibernation != nil && fufu.Status.Hibernation.WakingUp
+}
+
+// placeholderLabel selects the pods serving the placeholder page while the Fufu sleeps
+func placeholderLabel(fufu *catv1alpha2.Fufu) string {
+	return catv1alpha2.TruncateName(fufu.Name + "-" + catv1alpha2.SuffixSleeping)
+}
+
+// updatePlaceholder runs the placeholder page while the Fufu sleeps, and removes it once awake
+// and its pods are available. It returns the delay to check them again meanwhile
+func (r *FufuReconciler) updatePlaceholder(fufu *catv1alpha2.Fufu, ctx context.Context) (time.Duration, error) {
+	loggr := log.FromContext(ctx)
+
+	name := childName(fufu, catv1alpha2.SuffixSleeping)
+	if !asleep(fufu) {
+		if wakingUp(fufu) {
+			woken, err := r.woken(fufu, ctx)
+			if err != nil || !woken {
+				return wakeCheckDelay, err
+			}
+
+			loggr.Info("Fufu woken up, remove placeholder ...")
+			fufu.Status.Hibernation.WakingUp = false
+			if fufu.Spec.Hibernation == nil {
+				fufu.Status.Hibernation = nil
+			}
+		}
+		return 0, r.deleteOwned(fufu, name, &appsv1.Deployment{}, ctx)
+	}
+
+	wanted := r.createPlaceholder(fufu)
+	ctrutil.SetControllerReference(fufu, wanted, r.Scheme)
+
+	had := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: fufu.Namespace}, had); err != nil {
+		if err = client.IgnoreNotFound(err); err != nil {
+			return 0, err
+		}
+
+		loggr.Info("Create placeholder ...")
+		if err = r.Create(ctx, wanted); err != nil {
+			return 0, err
+		}
+		r.Recorder.Event(fufu, corev1.EventTypeNormal, "placeholder-created", "Placeholder page created")
+		return 0, nil
+	}
+
+	if deployChanged(wanted, had) {
+		loggr.Info("A diff was found, update placeholder ...")
+		wanted.ResourceVersion = had.ResourceVersion
+		return 0, r.Update(ctx, wanted)
+	}
+	return 0, nil
+}
+
+// woken tells whether the serving Deployment of the Fufu woken up has available pods, or
+// isn't asked for any
+func (r *FufuReconciler) woken(fufu *catv1alpha2.Fufu, ctx context.Context) (bool, error) {
+	deploy := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Name: servingDeployName(fufu), Namespace: fufu.Namespace}, deploy); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+
+	return deploy.Status.AvailableReplicas > 0 || (deploy.Spec.Replicas != nil && *deploy.Spec.Replicas == 0), nil
+}
+
+// createPlaceholder returns a single nginx serving a page telling that the Fufu sleeps
+func (r *FufuReconciler) createPlaceholder(fufu *catv1alpha2.Fufu) *appsv1.Deployment {
+	labels := withCommonLabels(fufu, map[string]string{"app": placeholderLabel(fufu)})
+	volName := "homedir"
+	var replicas int32 = 1
+
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        childName(fufu, catv1alpha2.SuffixSleeping),
+			Namespace:   fufu.Namespace,
+			Labels:      labels,
+			Annotations: commonAnnotations(fufu),
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": placeholderLabel(fufu)},
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      labels,
+					Annotations: commonAnnotations(fufu),
+				},
+				Spec: corev1.PodSpec{
+					NodeSelector: fufu.Spec.Template.NodeSelector,
+					Tolerations:  fufu.Spec.Template.Tolerations,
+					Volumes: []corev1.Volume{
+						{
+							Name: volName,
+							VolumeSource: corev1.VolumeSource{
+								EmptyDir: &corev1.EmptyDirVolumeSource{},
+							},
+						},
+					},
+					InitContainers: []corev1.Container{
+						{
+							Name:    "prepare-webcontent",
+							Image:   initImage(fufu),
+							Command: []string{"/bin/sh", "-c"},
+							Args:    []string{`echo "$PAGE" > /mnt/index.html`},
+							Env: []corev1.EnvVar{
+								{
+									Name:  "PAGE",
+									Value: placeholderPage(fufu),
+								},
+							},
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      volName,
+									MountPath: "/mnt",
+								},
+							},
+						},
+					},
+					Containers: []corev1.Container{
+						{
+							Name:  "web",
+							Image: webImage(fufu),
+							Ports: []corev1.ContainerPort{
+								{
+									Name:          webPortName,
+									ContainerPort: 80,
+								},
+							},
+							ReadinessProbe: createProbe(nil, defaultReadinessProbe),
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      volName,
+									MountPath: "/usr/share/nginx/html/index.html",
+									SubPath:   "index.html",
+									ReadOnly:  true,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// placeholderPage tells the visitors when the Fufu wakes up, if its windows say so
+func placeholderPage(fufu *catv1alpha2.Fufu) string {
+	back := ""
+	if st := fufu.Status.Hibernation; st != nil && st.NextTransition != nil && !fufu.Spec.Hibernation.Asleep {
+		back = fmt.Sprintf("<p>Back at %s</p>", st.NextTransition.Format(time.RFC1123))
+	}
+
+	return fmt.Sprintf("<html><head><title>%[1]s</title></head><body><h1>%[1]s is sleeping</h1>%s</body></html>",
+		html.EscapeString(fufu.Name), back)
+}
@@ -0,0 +1,186 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/tools/record"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+// fakeClock is moved by hand through the windows
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func TestUpdateHibernation(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	clock := &fakeClock{}
+	r := &FufuReconciler{Recorder: recorder, Clock: clock}
+
+	// asleep on weekday nights and all the weekend, Paris time
+	fufu := &catv1alpha2.Fufu{
+		ObjectMeta: metav1.ObjectMeta{Name: "fufu", Namespace: "default"},
+		Spec: catv1alpha2.FufuSpec{
+			Hibernation: &catv1alpha2.HibernationSpec{
+				TimeZone: "Europe/Paris",
+				Windows: []catv1alpha2.HibernationWindow{
+					{Sleep: "0 20 * * 1-5", Wake: "0 8 * * 1-5"},
+				},
+			},
+		},
+	}
+
+	steps := []struct {
+		name   string
+		now    time.Time
+		asleep bool
+		waking bool
+		next   time.Time
+		event  string
+	}{
+		{
+			name: "friday afternoon",
+			now:  time.Date(2022, 6, 3, 16, 0, 0, 0, time.UTC),
+			next: time.Date(2022, 6, 3, 18, 0, 0, 0, time.UTC),
+		},
+		{
+			name:   "friday night",
+			now:    time.Date(2022, 6, 3, 18, 0, 0, 0, time.UTC),
+			asleep: true,
+			next:   time.Date(2022, 6, 6, 6, 0, 0, 0, time.UTC),
+			event:  "fufu-asleep",
+		},
+		{
+			name:   "sunday",
+			now:    time.Date(2022, 6, 5, 12, 0, 0, 0, time.UTC),
+			asleep: true,
+			next:   time.Date(2022, 6, 6, 6, 0, 0, 0, time.UTC),
+		},
+		{
+			name:   "monday morning",
+			now:    time.Date(2022, 6, 6, 6, 0, 0, 0, time.UTC),
+			waking: true,
+			next:   time.Date(2022, 6, 6, 18, 0, 0, 0, time.UTC),
+			event:  "fufu-awake",
+		},
+	}
+
+	for _, step := range steps {
+		clock.now = step.now
+		delay := r.updateHibernation(fufu, r.Now())
+
+		st := fufu.Status.Hibernation
+		if st == nil || st.Asleep != step.asleep || asleep(fufu) != step.asleep || st.WakingUp != step.waking {
+			t.Fatalf("%s: status %+v, want asleep %v, waking up %v", step.name, st, step.asleep, step.waking)
+		}
+		if st.NextTransition == nil || !st.NextTransition.Time.Equal(step.next) {
+			t.Errorf("%s: next transition %v, want %v", step.name, st.NextTransition, step.next)
+		}
+		if want := step.next.Sub(step.now); delay != want {
+			t.Errorf("%s: requeued after %v, want %v", step.name, delay, want)
+		}
+		if !meta.IsStatusConditionPresentAndEqual(fufu.Status.Conditions, catv1alpha2.ConditionHibernating, boolCondition(step.asleep)) {
+			t.Errorf("%s: conditions %+v", step.name, fufu.Status.Conditions)
+		}
+
+		select {
+		case e := <-recorder.Events:
+			if step.event == "" {
+				t.Errorf("%s: unexpected event %q", step.name, e)
+			} else if !strings.HasPrefix(e, "Normal "+step.event+" ") {
+				t.Errorf("%s: event %q, want %s", step.name, e, step.event)
+			}
+		default:
+			if step.event != "" {
+				t.Errorf("%s: no %s event", step.name, step.event)
+			}
+		}
+	}
+
+	// the Fufu sleeps whatever the windows
+	fufu.Spec.Hibernation.Asleep = true
+	r.updateHibernation(fufu, r.Now())
+	if !asleep(fufu) {
+		t.Errorf("fufu put to sleep is awake")
+	}
+	<-recorder.Events
+
+	// invalid windows, as the webhook may be disabled, are reported once
+	fufu.Spec.Hibernation.Windows = []catv1alpha2.HibernationWindow{{Sleep: "tonight", Wake: "0 8 * * *"}}
+	for i := 0; i < 3; i++ {
+		r.updateHibernation(fufu, r.Now())
+	}
+	if cond := meta.FindStatusCondition(fufu.Status.Conditions, catv1alpha2.ConditionHibernating); cond == nil || cond.Reason != "InvalidWindows" {
+		t.Errorf("condition %+v, want InvalidWindows", cond)
+	}
+	if e := <-recorder.Events; !strings.HasPrefix(e, "Warning invalid-hibernation ") {
+		t.Errorf("event %q, want invalid-hibernation", e)
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("invalid windows reported again: %q", <-recorder.Events)
+	}
+
+	// the placeholder serves until the pods are available
+	fufu.Spec.Hibernation = nil
+	if delay := r.updateHibernation(fufu, r.Now()); delay != 0 || !wakingUp(fufu) || asleep(fufu) {
+		t.Errorf("hibernation removed but status %+v, requeued after %v", fufu.Status.Hibernation, delay)
+	}
+	if selector := r.createSvc(fufu).Spec.Selector; selector["app"] != placeholderLabel(fufu) {
+		t.Errorf("svc selects %v while waking up, want the placeholder", selector)
+	}
+	if meta.FindStatusCondition(fufu.Status.Conditions, catv1alpha2.ConditionHibernating) != nil {
+		t.Errorf("hibernating condition kept")
+	}
+}
+
+func TestHibernationChildren(t *testing.T) {
+	r := &FufuReconciler{}
+	fufu := &catv1alpha2.Fufu{
+		ObjectMeta: metav1.ObjectMeta{Name: "fufu", Namespace: "default"},
+		Spec: catv1alpha2.FufuSpec{
+			Hibernation: &catv1alpha2.HibernationSpec{Asleep: true},
+			Template:    catv1alpha2.PodTemplate{InitImage: "registry.local/alpine:3.16"},
+		},
+		Status: catv1alpha2.FufuStatus{
+			Hibernation: &catv1alpha2.HibernationStatus{Asleep: true},
+		},
+	}
+
+	// the hpa is kept, paused by its target scaled to zero
+	if !autoscaled(fufu) {
+		t.Errorf("hpa removed while asleep")
+	}
+	if replicas := r.createDeploy(fufu).Spec.Replicas; replicas == nil || *replicas != 0 {
+		t.Errorf("deploy sized to %v, want 0", replicas)
+	}
+	if pdb := r.createPdb(fufu); pdb != nil {
+		t.Errorf("pdb kept while asleep")
+	}
+
+	placeholder := r.createPlaceholder(fufu)
+	selector := r.createSvc(fufu).Spec.Selector
+	if len(selector) != 1 || selector["app"] != placeholder.Spec.Template.Labels["app"] {
+		t.Errorf("svc selects %v, placeholder pods labelled %v", selector, placeholder.Spec.Template.Labels)
+	}
+	if selector["app"] == appLabel(fufu) {
+		t.Errorf("svc still selects the fufu's pods")
+	}
+	if image := placeholder.Spec.Template.Spec.InitContainers[0].Image; image != fufu.Spec.Template.InitImage {
+		t.Errorf("placeholder init image %s, want %s", image, fufu.Spec.Template.InitImage)
+	}
+}
+
+func boolCondition(b bool) metav1.ConditionStatus {
+	if b {
+		return metav1.ConditionTrue
+	}
+	return metav1.ConditionFalse
+}
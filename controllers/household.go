@@ -0,0 +1,247 @@
+package controllers
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"text/template"
+
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+var householdIndexTmpl = htmltemplate.Must(htmltemplate.New("index.html").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>{{ .Title }}</title>
+<style>
+html { color-scheme: light dark; }
+body { width: 35em; margin: 0 auto;
+font-family: Tahoma, Verdana, Arial, sans-serif; }
+.badge { display: inline-block; padding: 0.2em 0.6em; border-radius: 0.8em;
+font-size: 0.8em; color: white; }
+.badge-ready { background-color: #2e7d32; }
+.badge-not-ready { background-color: #9e9e9e; }
+</style>
+</head>
+<body>
+<h1>{{ .Title }}</h1>
+{{- if .Members }}
+<ul>
+{{- range .Members }}
+<li><a href="{{ .Path }}">{{ .Name }}</a> {{ if .Ready }}<span class="badge badge-ready">Ready</span>{{ else }}<span class="badge badge-not-ready">Not ready</span>{{ end }}</li>
+{{- end }}
+</ul>
+{{- else }}
+<p>Nobody lives here yet.</p>
+{{- end }}
+</body>
+</html>
+`))
+
+var householdConfTmpl = template.Must(template.New(nginxConfFile).Parse(`server {
+    listen       80;
+    listen  [::]:80;
+    server_name  localhost;
+
+    location = / {
+        root   /usr/share/nginx/html;
+        index  index.html;
+    }
+
+    location = /index.html {
+        root   /usr/share/nginx/html;
+    }
+{{- range .Members }}
+
+    location {{ .Path }} {
+        proxy_pass http://{{ .Service }}/;
+        proxy_set_header Host $host;
+        proxy_set_header X-Forwarded-Prefix {{ .Path }};
+    }
+{{- end }}
+}
+`))
+
+// householdChildName is shared by all the children of the household, they are of different kinds
+func householdChildName(hh *catv1alpha2.FufuHousehold) string {
+	return catv1alpha2.TruncateName(hh.Name + "-household")
+}
+
+// renderHousehold generates the index page and the nginx configuration routing to the members
+func renderHousehold(hh *catv1alpha2.FufuHousehold, members []catv1alpha2.HouseholdMember) (map[string]string, error) {
+	title := hh.Spec.Title
+	if title == "" {
+		title = "Welcome to " + hh.Name + "'s household!"
+	}
+	values := struct {
+		Title   string
+		Members []catv1alpha2.HouseholdMember
+	}{
+		Title:   title,
+		Members: members,
+	}
+
+	var index, conf bytes.Buffer
+	if err := householdIndexTmpl.Execute(&index, values); err != nil {
+		return nil, err
+	}
+	if err := householdConfTmpl.Execute(&conf, values); err != nil {
+		return nil, err
+	}
+
+	return map[string]string{
+		"index.html":  index.String(),
+		nginxConfFile: conf.String(),
+	}, nil
+}
+
+func createHouseholdConfigMap(hh *catv1alpha2.FufuHousehold, data map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      householdChildName(hh),
+			Namespace: hh.Namespace,
+		},
+		Data: data,
+	}
+}
+
+func createHouseholdDeploy(hh *catv1alpha2.FufuHousehold, hash string) *appsv1.Deployment {
+	name := householdChildName(hh)
+	labels := map[string]string{
+		"app": name,
+	}
+	volName := "household"
+
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: hh.Namespace,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+					Annotations: map[string]string{
+						nginxConfHashAnnotation: hash,
+					},
+				},
+				Spec: corev1.PodSpec{
+					Volumes: []corev1.Volume{
+						{
+							Name: volName,
+							VolumeSource: corev1.VolumeSource{
+								ConfigMap: &corev1.ConfigMapVolumeSource{
+									LocalObjectReference: corev1.LocalObjectReference{
+										Name: name,
+									},
+								},
+							},
+						},
+					},
+					Containers: []corev1.Container{
+						{
+							Name:  "web",
+							Image: defaultWebImage,
+							Ports: []corev1.ContainerPort{
+								{
+									Name:          webPortName,
+									ContainerPort: 80,
+								},
+							},
+							ReadinessProbe: createProbe(nil, defaultReadinessProbe),
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      volName,
+									MountPath: "/usr/share/nginx/html/index.html",
+									SubPath:   "index.html",
+									ReadOnly:  true,
+								},
+								{
+									Name:      volName,
+									MountPath: "/etc/nginx/conf.d/" + nginxConfFile,
+									SubPath:   nginxConfFile,
+									ReadOnly:  true,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func createHouseholdSvc(hh *catv1alpha2.FufuHousehold) *corev1.Service {
+	name := householdChildName(hh)
+	svcType := corev1.ServiceTypeLoadBalancer
+	if hh.Spec.ServiceType != "" {
+		svcType = hh.Spec.ServiceType
+	}
+
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: hh.Namespace,
+		},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{
+				"app": name,
+			},
+			Ports: []corev1.ServicePort{
+				{
+					Name:       webPortName,
+					Port:       80,
+					TargetPort: intstr.FromInt(80),
+				},
+			},
+			Type: svcType,
+		},
+	}
+}
+
+func createHouseholdIngress(hh *catv1alpha2.FufuHousehold) *netv1.Ingress {
+	name := householdChildName(hh)
+	pathType := netv1.PathTypePrefix
+
+	return &netv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: hh.Namespace,
+		},
+		Spec: netv1.IngressSpec{
+			IngressClassName: hh.Spec.Ingress.ClassName,
+			Rules: []netv1.IngressRule{
+				{
+					Host: hh.Spec.Ingress.Host,
+					IngressRuleValue: netv1.IngressRuleValue{
+						HTTP: &netv1.HTTPIngressRuleValue{
+							Paths: []netv1.HTTPIngressPath{
+								{
+									Path:     "/",
+									PathType: &pathType,
+									Backend: netv1.IngressBackend{
+										Service: &netv1.IngressServiceBackend{
+											Name: name,
+											Port: netv1.ServiceBackendPort{
+												Name: webPortName,
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
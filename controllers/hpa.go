@@ -2,15 +2,18 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 
 	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	ctrutil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
-	asv1 "k8s.io/api/autoscaling/v1"
+	asv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -19,23 +22,47 @@ func (r *FufuReconciler) updateHpa(fufu *catv1alpha2.Fufu, ctx context.Context)
 
 	wanted := r.createHpa(fufu)
 
-	had := &asv1.HorizontalPodAutoscaler{}
+	had := &asv2.HorizontalPodAutoscaler{}
 	if err := r.Get(ctx, types.NamespacedName{Name: wanted.ObjectMeta.Name, Namespace: wanted.ObjectMeta.Namespace}, had); err == nil {
-		if !equality.Semantic.DeepDerivative(wanted.Spec, had.Spec) {
+		// the Deployment is sized by spec.replicas, or scaled to zero, instead
+		if !autoscaled(fufu) {
+			fufu.Status.Autoscaling = nil
+			if !metav1.IsControlledBy(had, fufu) {
+				return nil
+			}
+
+			loggr.Info("Autoscaling disabled, delete hpa ...")
+			if err = r.Delete(ctx, had); err != nil {
+				return client.IgnoreNotFound(err)
+			}
+			r.Recorder.Event(fufu, corev1.EventTypeNormal, "hpa-deleted", "HorizontalPodAutoscaler deleted")
+			return nil
+		}
+
+		r.observeHpa(fufu, had)
+
+		if !equality.Semantic.DeepDerivative(wanted.Spec, had.Spec) || metadataChanged(wanted, had) {
 			loggr.Info("A diff was found, update hpa ...")
+			drifted := markApplied(wanted, had)
 			ctrutil.SetControllerReference(fufu, wanted, r.Scheme)
+			wanted.ResourceVersion = had.ResourceVersion
 			if err = r.Update(ctx, wanted); err != nil {
 				return err
 			}
-			loggr.Info("Hpa updated")
+			r.childUpdated(fufu, drifted, "hpa-updated", "HorizontalPodAutoscaler updated")
 		}
 		return nil
 	} else {
 		if err = client.IgnoreNotFound(err); err != nil {
 			return err
 		}
+		if !autoscaled(fufu) {
+			fufu.Status.Autoscaling = nil
+			return nil
+		}
 
 		loggr.Info("Create hpa ...")
+		markApplied(wanted, nil)
 		ctrutil.SetControllerReference(fufu, wanted, r.Scheme)
 		if err = r.Create(ctx, wanted); err != nil {
 			loggr.Error(err, "failed to create hpa")
@@ -46,22 +73,38 @@ func (r *FufuReconciler) updateHpa(fufu *catv1alpha2.Fufu, ctx context.Context)
 	}
 }
 
-func (r *FufuReconciler) createHpa(fufu *catv1alpha2.Fufu) *asv1.HorizontalPodAutoscaler {
-	name := fufu.Name + "-hpa"
-	deployName := fufu.Name + "-deploy"
-	var minReplicas int32 = 2
+func (r *FufuReconciler) createHpa(fufu *catv1alpha2.Fufu) *asv2.HorizontalPodAutoscaler {
+	name := childName(fufu, catv1alpha2.SuffixHpa)
+	deployName := servingDeployName(fufu)
+	minReplicas, maxReplicas := hpaBounds(fufu)
 	var cpuThreshold int32 = 60
+	if fufu.Spec.Autoscaling.TargetCPUUtilizationPercentage != nil {
+		cpuThreshold = *fufu.Spec.Autoscaling.TargetCPUUtilizationPercentage
+	}
 
-	return &asv1.HorizontalPodAutoscaler{
+	return &asv2.HorizontalPodAutoscaler{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: fufu.Namespace,
+			Name:        name,
+			Namespace:   fufu.Namespace,
+			Labels:      commonLabels(fufu),
+			Annotations: commonAnnotations(fufu),
 		},
-		Spec: asv1.HorizontalPodAutoscalerSpec{
-			MinReplicas:                    &minReplicas,
-			MaxReplicas:                    5,
-			TargetCPUUtilizationPercentage: &cpuThreshold,
-			ScaleTargetRef: asv1.CrossVersionObjectReference{
+		Spec: asv2.HorizontalPodAutoscalerSpec{
+			MinReplicas: &minReplicas,
+			MaxReplicas: maxReplicas,
+			Metrics: []asv2.MetricSpec{
+				{
+					Type: asv2.ResourceMetricSourceType,
+					Resource: &asv2.ResourceMetricSource{
+						Name: corev1.ResourceCPU,
+						Target: asv2.MetricTarget{
+							Type:               asv2.UtilizationMetricType,
+							AverageUtilization: &cpuThreshold,
+						},
+					},
+				},
+			},
+			ScaleTargetRef: asv2.CrossVersionObjectReference{
 				Kind:       "Deployment",
 				APIVersion: "apps/v1",
 				Name:       deployName,
@@ -69,3 +112,139 @@ func (r *FufuReconciler) createHpa(fufu *catv1alpha2.Fufu) *asv1.HorizontalPodAu
 		},
 	}
 }
+
+// observeHpa reflects the status of the HPA into the Fufu's, and warns when the HPA would
+// scale beyond its bounds
+func (r *FufuReconciler) observeHpa(fufu *catv1alpha2.Fufu, hpa *asv2.HorizontalPodAutoscaler) {
+	prev := fufu.Status.Autoscaling
+	st := &catv1alpha2.AutoscalingStatus{
+		CurrentReplicas: hpa.Status.CurrentReplicas,
+		DesiredReplicas: hpa.Status.DesiredReplicas,
+		LastScaleTime:   hpa.Status.LastScaleTime,
+	}
+	var prevConditions []metav1.Condition
+	if prev != nil {
+		prevConditions = prev.Conditions
+	}
+
+	for i, spec := range hpa.Spec.Metrics {
+		if spec.Type != asv2.ResourceMetricSourceType || spec.Resource == nil {
+			continue
+		}
+		metric := catv1alpha2.MetricStatus{
+			Name:   string(spec.Resource.Name),
+			Target: formatMetricTarget(spec.Resource.Target),
+		}
+		// the current metrics follow the order of the spec
+		if i < len(hpa.Status.CurrentMetrics) {
+			if current := hpa.Status.CurrentMetrics[i].Resource; current != nil && current.Name == spec.Resource.Name {
+				metric.Current = formatMetricValue(current.Current, spec.Resource.Target.Type)
+			}
+		}
+		st.Metrics = append(st.Metrics, metric)
+	}
+
+	// the conditions are those of the HPA only, a transition is kept from the previous
+	// status while the condition holds
+	for _, c := range hpa.Status.Conditions {
+		cond := metav1.Condition{
+			Type:               string(c.Type),
+			Status:             metav1.ConditionStatus(c.Status),
+			Reason:             c.Reason,
+			Message:            c.Message,
+			LastTransitionTime: c.LastTransitionTime,
+			ObservedGeneration: fufu.Generation,
+		}
+		if cond.Reason == "" {
+			cond.Reason = "Unknown"
+		}
+
+		old := meta.FindStatusCondition(prevConditions, cond.Type)
+		if old != nil && old.Status == cond.Status {
+			cond.LastTransitionTime = old.LastTransitionTime
+		}
+		if c.Type == asv2.ScalingLimited && c.Status == corev1.ConditionTrue {
+			if old == nil || old.Status != cond.Status || old.Reason != cond.Reason {
+				r.Recorder.Eventf(fufu, corev1.EventTypeWarning, "scaling-limited", "%s: %s", c.Reason, c.Message)
+			}
+		}
+		meta.SetStatusCondition(&st.Conditions, cond)
+	}
+
+	fufu.Status.Autoscaling = st
+}
+
+func formatMetricTarget(target asv2.MetricTarget) string {
+	switch {
+	case target.Type == asv2.UtilizationMetricType && target.AverageUtilization != nil:
+		return fmt.Sprintf("%d%%", *target.AverageUtilization)
+	case target.Type == asv2.AverageValueMetricType && target.AverageValue != nil:
+		return target.AverageValue.String()
+	case target.Value != nil:
+		return target.Value.String()
+	}
+
+	return ""
+}
+
+func formatMetricValue(value asv2.MetricValueStatus, targetType asv2.MetricTargetType) string {
+	switch {
+	case targetType == asv2.UtilizationMetricType && value.AverageUtilization != nil:
+		return fmt.Sprintf("%d%%", *value.AverageUtilization)
+	case value.AverageValue != nil:
+		return value.AverageValue.String()
+	case value.Value != nil:
+		return value.Value.String()
+	}
+
+	return ""
+}
+
+// autoscaled tells if the Fufu is scaled by its HPA. The HPA is kept while the Fufu sleeps,
+// its target scaled to zero pauses it
+func autoscaled(fufu *catv1alpha2.Fufu) bool {
+	return fufu.Spec.Autoscaling.Enabled == nil || *fufu.Spec.Autoscaling.Enabled
+}
+
+// fixedReplicas is the size of the Fufu when autoscaling is disabled, nil when the HPA sizes it
+func fixedReplicas(fufu *catv1alpha2.Fufu) *int32 {
+	var replicas int32
+	if asleep(fufu) {
+		return &replicas
+	}
+	if autoscaled(fufu) {
+		return nil
+	}
+
+	replicas, _ = hpaBounds(fufu)
+	if fufu.Spec.Replicas != nil {
+		replicas = *fufu.Spec.Replicas
+	}
+	return &replicas
+}
+
+// replicasFloor is the least number of pods the Fufu runs
+func replicasFloor(fufu *catv1alpha2.Fufu) int32 {
+	if replicas := fixedReplicas(fufu); replicas != nil {
+		return *replicas
+	}
+
+	replicas, _ := hpaBounds(fufu)
+	return replicas
+}
+
+// hpaBounds returns the min and max replicas of the Fufu's hpa
+func hpaBounds(fufu *catv1alpha2.Fufu) (int32, int32) {
+	var minReplicas, maxReplicas int32 = 2, 5
+	if fufu.Spec.Autoscaling.MinReplicas != nil {
+		minReplicas = *fufu.Spec.Autoscaling.MinReplicas
+	}
+	if fufu.Spec.Autoscaling.MaxReplicas > 0 {
+		maxReplicas = fufu.Spec.Autoscaling.MaxReplicas
+	}
+	if maxReplicas < minReplicas {
+		maxReplicas = minReplicas
+	}
+
+	return minReplicas, maxReplicas
+}
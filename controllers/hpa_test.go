@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/record"
+
+	asv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+func TestObserveHpa(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	r := &FufuReconciler{Recorder: recorder}
+	fufu := &catv1alpha2.Fufu{ObjectMeta: metav1.ObjectMeta{Name: "fufu", Namespace: "default"}}
+
+	hpa := r.createHpa(fufu)
+	utilization := int32(85)
+	scaled := metav1.Now()
+	hpa.Status = asv2.HorizontalPodAutoscalerStatus{
+		CurrentReplicas: 5,
+		DesiredReplicas: 5,
+		LastScaleTime:   &scaled,
+		CurrentMetrics: []asv2.MetricStatus{{
+			Type: asv2.ResourceMetricSourceType,
+			Resource: &asv2.ResourceMetricStatus{
+				Name:    corev1.ResourceCPU,
+				Current: asv2.MetricValueStatus{AverageUtilization: &utilization},
+			},
+		}},
+		Conditions: []asv2.HorizontalPodAutoscalerCondition{
+			{Type: asv2.AbleToScale, Status: corev1.ConditionTrue, Reason: "ReadyForNewScale"},
+			{Type: asv2.ScalingLimited, Status: corev1.ConditionTrue, Reason: "TooManyReplicas", Message: "the desired replica count is more than the maximum replica count"},
+		},
+	}
+
+	r.observeHpa(fufu, hpa)
+	st := fufu.Status.Autoscaling
+	if st == nil || st.CurrentReplicas != 5 || st.DesiredReplicas != 5 || st.LastScaleTime != &scaled {
+		t.Fatalf("unexpected autoscaling status %+v", st)
+	}
+	if len(st.Metrics) != 1 || st.Metrics[0] != (catv1alpha2.MetricStatus{Name: "cpu", Current: "85%", Target: "60%"}) {
+		t.Errorf("unexpected metrics %+v", st.Metrics)
+	}
+	if len(st.Conditions) != 2 || st.Conditions[1].Type != "ScalingLimited" || st.Conditions[1].LastTransitionTime.IsZero() {
+		t.Errorf("unexpected conditions %+v", st.Conditions)
+	}
+	if len(recorder.Events) != 1 {
+		t.Fatalf("expected a scaling-limited event, got %d events", len(recorder.Events))
+	}
+	<-recorder.Events
+
+	// still limited, no new event
+	limitedSince := st.Conditions[1].LastTransitionTime
+	hpa.Status.Conditions[1].LastTransitionTime = metav1.NewTime(limitedSince.Add(time.Minute))
+	r.observeHpa(fufu, hpa)
+	if len(recorder.Events) != 0 {
+		t.Errorf("expected no event while scaling stays limited, got %s", <-recorder.Events)
+	}
+	if got := fufu.Status.Autoscaling.Conditions[1].LastTransitionTime; !got.Equal(&limitedSince) {
+		t.Errorf("transition moved to %v while scaling stays limited", got)
+	}
+
+	// the HPA dropped the condition, so does the status
+	hpa.Status.Conditions = hpa.Status.Conditions[:1]
+	r.observeHpa(fufu, hpa)
+	if conds := fufu.Status.Autoscaling.Conditions; len(conds) != 1 || conds[0].Type != "AbleToScale" {
+		t.Errorf("stale conditions kept %+v", conds)
+	}
+}
+
+func TestFixedReplicas(t *testing.T) {
+	enabled, disabled := true, false
+	three, zero := int32(3), int32(0)
+
+	cases := []struct {
+		name        string
+		autoscaling catv1alpha2.AutoscalingSpec
+		replicas    *int32
+		want        *int32
+		floor       int32
+	}{
+		{
+			name:  "autoscaled by default",
+			floor: 2,
+		},
+		{
+			name:        "replicas ignored while autoscaled",
+			autoscaling: catv1alpha2.AutoscalingSpec{Enabled: &enabled, MinReplicas: &three},
+			replicas:    &zero,
+			floor:       3,
+		},
+		{
+			name:        "autoscaling floor without replicas",
+			autoscaling: catv1alpha2.AutoscalingSpec{Enabled: &disabled, MinReplicas: &three},
+			want:        &three,
+			floor:       3,
+		},
+		{
+			name:        "replicas",
+			autoscaling: catv1alpha2.AutoscalingSpec{Enabled: &disabled},
+			replicas:    &zero,
+			want:        &zero,
+			floor:       0,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fufu := &catv1alpha2.Fufu{Spec: catv1alpha2.FufuSpec{Autoscaling: c.autoscaling, Replicas: c.replicas}}
+
+			got := fixedReplicas(fufu)
+			if (got == nil) != (c.want == nil) || (got != nil && *got != *c.want) {
+				t.Errorf("replicas %v, want %v", got, c.want)
+			}
+			if floor := replicasFloor(fufu); floor != c.floor {
+				t.Errorf("floor %d, want %d", floor, c.floor)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+package controllers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+// the recommended labels, https://kubernetes.io/docs/concepts/overview/working-with-objects/common-labels/
+const (
+	nameLabel      = "app.kubernetes.io/name"
+	instanceLabel  = "app.kubernetes.io/instance"
+	managedByLabel = "app.kubernetes.io/managed-by"
+	partOfLabel    = "app.kubernetes.io/part-of"
+	versionLabel   = "app.kubernetes.io/version"
+
+	appName   = "fufu"
+	managedBy = "fufu-controller"
+
+	// propagatedAnnotation lists the annotations propagated from the Fufu, so that the ones
+	// no longer asked for are dropped
+	propagatedAnnotation = "cat.huozj.io/propagated-annotations"
+
+	// appliedHashAnnotation is the hash of the child as last applied by the controller, a child
+	// to update while the controller applies the same again was changed by someone else
+	appliedHashAnnotation = "cat.huozj.io/applied-hash"
+)
+
+// commonLabels are the labels of all the children of the Fufu and of its pods: the recommended
+// ones and the user's, the labels the controller relies on can't be overridden. The selectors
+// only use the app label, they are immutable
+func commonLabels(fufu *catv1alpha2.Fufu) map[string]string {
+	labels := map[string]string{}
+	for k, v := range fufu.Labels {
+		if propagated(k, fufu.Spec.PropagatedPrefixes) {
+			labels[k] = v
+		}
+	}
+	for k, v := range fufu.Spec.CommonLabels {
+		labels[k] = v
+	}
+
+	if _, ok := labels[partOfLabel]; !ok && fufu.Spec.ClassName != "" {
+		labels[partOfLabel] = catv1alpha2.TruncateName(fufu.Spec.ClassName)
+	}
+	if _, ok := labels[versionLabel]; !ok {
+		labels[versionLabel] = imageVersion(webImage(fufu))
+	}
+	labels[nameLabel] = appName
+	labels[instanceLabel] = catv1alpha2.TruncateName(fufu.Name)
+	labels[managedByLabel] = managedBy
+	labels["app"] = appLabel(fufu)
+
+	return labels
+}
+
+// commonAnnotations are the annotations of all the children of the Fufu and of its pods
+func commonAnnotations(fufu *catv1alpha2.Fufu) map[string]string {
+	annotations := map[string]string{}
+	for k, v := range fufu.Annotations {
+		if propagated(k, fufu.Spec.PropagatedPrefixes) {
+			annotations[k] = v
+		}
+	}
+	for k, v := range fufu.Spec.CommonAnnotations {
+		annotations[k] = v
+	}
+	if len(annotations) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(annotations))
+	for k := range annotations {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	annotations[propagatedAnnotation] = strings.Join(keys, ",")
+
+	return annotations
+}
+
+// withCommonLabels returns the child's own labels along with the common ones
+func withCommonLabels(fufu *catv1alpha2.Fufu, own map[string]string) map[string]string {
+	labels := commonLabels(fufu)
+	for k, v := range own {
+		labels[k] = v
+	}
+	return labels
+}
+
+// withCommonAnnotations returns the child's own annotations along with the common ones
+func withCommonAnnotations(fufu *catv1alpha2.Fufu, own map[string]string) map[string]string {
+	annotations := commonAnnotations(fufu)
+	if annotations == nil && len(own) > 0 {
+		annotations = map[string]string{}
+	}
+	for k, v := range own {
+		annotations[k] = v
+	}
+	return annotations
+}
+
+func propagated(key string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// metadataChanged tells if had's labels drifted from wanted, or if it misses an annotation or
+// keeps one no longer propagated. The annotations added by others are left alone
+func metadataChanged(wanted, had metav1.Object) bool {
+	if !equality.Semantic.DeepEqual(wanted.GetLabels(), had.GetLabels()) {
+		return true
+	}
+
+	for k, v := range wanted.GetAnnotations() {
+		if value, ok := had.GetAnnotations()[k]; !ok || value != v {
+			return true
+		}
+	}
+	if keys := had.GetAnnotations()[propagatedAnnotation]; keys != "" {
+		for _, k := range strings.Split(keys, ",") {
+			if _, ok := wanted.GetAnnotations()[k]; !ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// appliedHash hashes the child as the controller applies it, whatever its version and owner
+func appliedHash(obj client.Object) string {
+	applied := obj.DeepCopyObject().(client.Object)
+	applied.SetResourceVersion("")
+	applied.SetOwnerReferences(nil)
+	annotations := map[string]string{}
+	for k, v := range applied.GetAnnotations() {
+		annotations[k] = v
+	}
+	delete(annotations, appliedHashAnnotation)
+	applied.SetAnnotations(annotations)
+
+	raw, _ := json.Marshal(applied)
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])[:10]
+}
+
+// markApplied records the hash of wanted before it is applied. It tells if had, to be
+// replaced, drifted from what was last applied while the controller applies the same again
+func markApplied(wanted, had client.Object) bool {
+	hash := appliedHash(wanted)
+
+	annotations := map[string]string{}
+	for k, v := range wanted.GetAnnotations() {
+		annotations[k] = v
+	}
+	annotations[appliedHashAnnotation] = hash
+	wanted.SetAnnotations(annotations)
+
+	return had != nil && had.GetAnnotations()[appliedHashAnnotation] == hash
+}
+
+// webImage is the image of the web container
+func webImage(fufu *catv1alpha2.Fufu) string {
+	if fufu.Spec.Template.Image != "" {
+		return fufu.Spec.Template.Image
+	}
+	return defaultWebImage
+}
+
+// initImage is the image of the init container preparing the page
+func initImage(fufu *catv1alpha2.Fufu) string {
+	if fufu.Spec.Template.InitImage != "" {
+		return fufu.Spec.Template.InitImage
+	}
+	return defaultInitImage
+}
+
+// imageVersion is the tag of the image, or the beginning of its digest, as a label value
+func imageVersion(image string) string {
+	version := "latest"
+	if i := strings.LastIndex(image, "@"); i >= 0 {
+		version = image[i+1:]
+		if j := strings.Index(version, ":"); j >= 0 {
+			version = version[j+1:]
+		}
+		if len(version) > 12 {
+			version = version[:12]
+		}
+	} else if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		version = image[i+1:]
+	}
+
+	if len(version) > validation.LabelValueMaxLength {
+		version = version[:validation.LabelValueMaxLength]
+	}
+	return strings.Trim(version, "_.-")
+}
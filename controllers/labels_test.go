@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+func TestCommonLabels(t *testing.T) {
+	cases := []struct {
+		name   string
+		labels map[string]string
+		spec   catv1alpha2.FufuSpec
+		want   map[string]string
+	}{
+		{
+			name: "recommended labels",
+			want: map[string]string{
+				"app":          "fufu-deploy",
+				nameLabel:      "fufu",
+				instanceLabel:  "fufu",
+				managedByLabel: "fufu-controller",
+				versionLabel:   "latest",
+			},
+		},
+		{
+			name: "class and image",
+			spec: catv1alpha2.FufuSpec{
+				ClassName: "indoor",
+				Template:  catv1alpha2.PodTemplate{Image: "registry.local:5000/nginx:1.23"},
+			},
+			want: map[string]string{
+				"app":          "fufu-deploy",
+				nameLabel:      "fufu",
+				instanceLabel:  "fufu",
+				managedByLabel: "fufu-controller",
+				partOfLabel:    "indoor",
+				versionLabel:   "1.23",
+			},
+		},
+		{
+			name:   "common and propagated labels",
+			labels: map[string]string{"cost.example.com/team": "cats", "cost.example.com/center": "42", "household": "huo"},
+			spec: catv1alpha2.FufuSpec{
+				ClassName:          "indoor",
+				PropagatedPrefixes: []string{"cost.example.com/"},
+				CommonLabels: map[string]string{
+					"cost.example.com/center": "43",
+					partOfLabel:               "pets",
+					"app":                     "dog",
+				},
+			},
+			want: map[string]string{
+				"app":                     "fufu-deploy",
+				"cost.example.com/team":   "cats",
+				"cost.example.com/center": "43",
+				nameLabel:                 "fufu",
+				instanceLabel:             "fufu",
+				managedByLabel:            "fufu-controller",
+				partOfLabel:               "pets",
+				versionLabel:              "latest",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			fufu := &catv1alpha2.Fufu{ObjectMeta: metav1.ObjectMeta{Name: "fufu", Labels: c.labels}, Spec: c.spec}
+			if got := commonLabels(fufu); !equality.Semantic.DeepEqual(got, c.want) {
+				t.Errorf("labels %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestCommonAnnotations(t *testing.T) {
+	fufu := &catv1alpha2.Fufu{ObjectMeta: metav1.ObjectMeta{
+		Name:        "fufu",
+		Annotations: map[string]string{"cost.example.com/owner": "huo", catv1alpha2.PromoteAnnotation: "true"},
+	}}
+	if got := commonAnnotations(fufu); got != nil {
+		t.Errorf("annotations %v propagated without being asked for", got)
+	}
+
+	fufu.Spec.PropagatedPrefixes = []string{"cost.example.com/"}
+	fufu.Spec.CommonAnnotations = map[string]string{"description": "orange cat"}
+	want := map[string]string{
+		"cost.example.com/owner": "huo",
+		"description":            "orange cat",
+		propagatedAnnotation:     "cost.example.com/owner,description",
+	}
+	if got := commonAnnotations(fufu); !equality.Semantic.DeepEqual(got, want) {
+		t.Errorf("annotations %v, want %v", got, want)
+	}
+}
+
+func TestMetadataChanged(t *testing.T) {
+	obj := func(labels, annotations map[string]string) metav1.Object {
+		return &metav1.ObjectMeta{Labels: labels, Annotations: annotations}
+	}
+	labels := map[string]string{"app": "fufu-deploy", "team": "cats"}
+
+	cases := []struct {
+		name        string
+		wanted, had metav1.Object
+		want        bool
+	}{
+		{
+			name:   "unchanged",
+			wanted: obj(labels, map[string]string{"description": "orange cat", propagatedAnnotation: "description"}),
+			had:    obj(labels, map[string]string{"description": "orange cat", propagatedAnnotation: "description"}),
+		},
+		{
+			name:   "label added",
+			wanted: obj(labels, nil),
+			had:    obj(map[string]string{"app": "fufu-deploy"}, nil),
+			want:   true,
+		},
+		{
+			name:   "label removed",
+			wanted: obj(map[string]string{"app": "fufu-deploy"}, nil),
+			had:    obj(labels, nil),
+			want:   true,
+		},
+		{
+			name:   "annotation added by others",
+			wanted: obj(labels, nil),
+			had:    obj(labels, map[string]string{"deployment.kubernetes.io/revision": "2"}),
+		},
+		{
+			name:   "annotation changed",
+			wanted: obj(labels, map[string]string{"description": "black cat", propagatedAnnotation: "description"}),
+			had:    obj(labels, map[string]string{"description": "orange cat", propagatedAnnotation: "description"}),
+			want:   true,
+		},
+		{
+			name:   "annotation no longer propagated",
+			wanted: obj(labels, nil),
+			had:    obj(labels, map[string]string{"description": "orange cat", propagatedAnnotation: "description"}),
+			want:   true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := metadataChanged(c.wanted, c.had); got != c.want {
+				t.Errorf("changed %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestImageVersion(t *testing.T) {
+	cases := map[string]string{
+		"nginx":                             "latest",
+		"nginx:1.23":                        "1.23",
+		"registry.local:5000/nginx":         "latest",
+		"registry.local:5000/nginx:1.23.1":  "1.23.1",
+		"nginx@sha256:0123456789abcdef0123": "0123456789ab",
+		"nginx:_dev-":                       "dev",
+	}
+
+	for image, want := range cases {
+		if got := imageVersion(image); got != want {
+			t.Errorf("version of %s is %q, want %q", image, got, want)
+		}
+	}
+}
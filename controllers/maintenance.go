@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+// updateMaintenance tells from the windows if the changes rolling the pods may apply at now.
+// The pending changes are collected again by the steps deferring them
+func (r *FufuReconciler) updateMaintenance(fufu *catv1alpha2.Fufu, now time.Time) {
+	if len(fufu.Spec.MaintenanceWindows) == 0 {
+		fufu.Status.Maintenance = nil
+		meta.RemoveStatusCondition(&fufu.Status.Conditions, catv1alpha2.ConditionRolloutPending)
+		return
+	}
+
+	open, next, err := maintenanceState(fufu.Spec.MaintenanceWindows, now)
+	if err != nil {
+		// rejected by the webhook, unless it is disabled, better roll than hold forever.
+		// finishMaintenance tells why through the RolloutPending condition
+		open, next = true, time.Time{}
+	}
+
+	st := &catv1alpha2.MaintenanceStatus{InWindow: open}
+	if !next.IsZero() {
+		st.NextWindow = &metav1.Time{Time: next}
+	}
+	fufu.Status.Maintenance = st
+}
+
+// maintenanceState tells if a window is open at now and when the next one opens, each window
+// in its own time zone. A window is open if it started less than its duration ago
+func maintenanceState(windows []catv1alpha2.MaintenanceWindow, now time.Time) (bool, time.Time, error) {
+	open := false
+	var next time.Time
+	for _, w := range windows {
+		loc, err := w.Location()
+		if err != nil {
+			return false, time.Time{}, err
+		}
+		start, err := catv1alpha2.ParseSchedule(w.Start)
+		if err != nil {
+			return false, time.Time{}, fmt.Errorf("start %q: %w", w.Start, err)
+		}
+
+		local := now.In(loc)
+		if last := start.Next(local.Add(-w.Duration.Duration)); !last.IsZero() && !last.After(local) {
+			open = true
+		}
+		if t := start.Next(local); !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+
+	return open, next, nil
+}
+
+// rolloutAllowed tells if the changes rolling the pods may apply now, a rollback sets the
+// rollout-now annotation as well
+func rolloutAllowed(fufu *catv1alpha2.Fufu) bool {
+	st := fufu.Status.Maintenance
+	return st == nil || st.InWindow || fufu.Annotations[catv1alpha2.RolloutNowAnnotation] == "true"
+}
+
+// rolloutPending tells if changes wait for a window, the children don't follow the spec yet
+func rolloutPending(fufu *catv1alpha2.Fufu) bool {
+	return fufu.Status.Maintenance != nil && len(fufu.Status.Maintenance.PendingChanges) > 0
+}
+
+// deferRollout records the changes as pending unless they may roll now, the caller keeps the
+// child as it is when it returns true
+func (r *FufuReconciler) deferRollout(fufu *catv1alpha2.Fufu, changes ...string) bool {
+	if rolloutAllowed(fufu) {
+		return false
+	}
+
+	st := fufu.Status.Maintenance
+	pending := map[string]bool{}
+	for _, c := range st.PendingChanges {
+		pending[c] = true
+	}
+	for _, c := range changes {
+		if !pending[c] {
+			pending[c] = true
+			st.PendingChanges = append(st.PendingChanges, c)
+		}
+	}
+	return true
+}
+
+// templateChanges describes what rolls the pods when wanted replaces had
+func templateChanges(wanted, had *appsv1.Deployment) []string {
+	var changes []string
+	wantedTmpl, hadTmpl := wanted.Spec.Template, had.Spec.Template
+
+	if wantedTmpl.Annotations[nginxConfHashAnnotation] != hadTmpl.Annotations[nginxConfHashAnnotation] {
+		changes = append(changes, "nginx configuration")
+	}
+	if len(wantedTmpl.Spec.InitContainers) != len(hadTmpl.Spec.InitContainers) ||
+		!equality.Semantic.DeepDerivative(wantedTmpl.Spec.InitContainers, hadTmpl.Spec.InitContainers) {
+		changes = append(changes, "page content")
+	}
+
+	hadContainers := map[string]corev1.Container{}
+	for _, c := range hadTmpl.Spec.Containers {
+		hadContainers[c.Name] = c
+	}
+	for _, c := range wantedTmpl.Spec.Containers {
+		prev, ok := hadContainers[c.Name]
+		delete(hadContainers, c.Name)
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("container %s added", c.Name))
+			continue
+		case c.Image != prev.Image:
+			changes = append(changes, fmt.Sprintf("image of %s: %s", c.Name, c.Image))
+		}
+		if !equality.Semantic.DeepEqual(c.Resources, prev.Resources) {
+			changes = append(changes, fmt.Sprintf("resources of %s", c.Name))
+		}
+	}
+	for _, c := range hadTmpl.Spec.Containers {
+		if _, ok := hadContainers[c.Name]; ok {
+			changes = append(changes, fmt.Sprintf("container %s removed", c.Name))
+		}
+	}
+
+	if len(changes) == 0 {
+		changes = append(changes, "pod template")
+	}
+	return changes
+}
+
+// finishMaintenance tells through the RolloutPending condition what waits for which window,
+// or that the windows are ignored when invalid, and removes the handled rollout-now
+// annotation. It returns the delay until the window opens
+func (r *FufuReconciler) finishMaintenance(fufu *catv1alpha2.Fufu, now time.Time, ctx context.Context) (time.Duration, error) {
+	st := fufu.Status.Maintenance
+
+	if _, ok := fufu.Annotations[catv1alpha2.RolloutNowAnnotation]; ok {
+		if st != nil && !st.InWindow && fufu.Annotations[catv1alpha2.RolloutNowAnnotation] == "true" {
+			r.Recorder.Event(fufu, corev1.EventTypeNormal, "rollout-forced", "Changes rolled out outside the maintenance windows")
+		}
+		if err := r.patchAnnotation(fufu, catv1alpha2.RolloutNowAnnotation, nil, ctx); err != nil {
+			return 0, err
+		}
+	}
+	if st == nil {
+		return 0, nil
+	}
+
+	cond := metav1.Condition{
+		Type:               catv1alpha2.ConditionRolloutPending,
+		Status:             metav1.ConditionFalse,
+		Reason:             "NoPendingChanges",
+		Message:            "The pods run the spec",
+		ObservedGeneration: fufu.Generation,
+	}
+	if !rolloutPending(fufu) {
+		if _, _, err := maintenanceState(fufu.Spec.MaintenanceWindows, now); err != nil {
+			cond.Reason, cond.Message = "InvalidWindows", fmt.Sprintf("Windows ignored, the changes roll out at once: %v", err)
+			if prev := meta.FindStatusCondition(fufu.Status.Conditions, catv1alpha2.ConditionRolloutPending); prev == nil || prev.Reason != cond.Reason || prev.Message != cond.Message {
+				r.Recorder.Event(fufu, corev1.EventTypeWarning, "invalid-maintenance-windows", cond.Message)
+			}
+		}
+		meta.SetStatusCondition(&fufu.Status.Conditions, cond)
+		return 0, nil
+	}
+
+	until := "the " + catv1alpha2.RolloutNowAnnotation + " annotation, no window ahead"
+	if st.NextWindow != nil {
+		until = "the window at " + st.NextWindow.Format(time.RFC3339)
+	}
+	cond.Status, cond.Reason = metav1.ConditionTrue, "OutsideMaintenanceWindow"
+	cond.Message = fmt.Sprintf("Pending until %s: %s", until, strings.Join(st.PendingChanges, ", "))
+	if !meta.IsStatusConditionTrue(fufu.Status.Conditions, catv1alpha2.ConditionRolloutPending) {
+		r.Recorder.Event(fufu, corev1.EventTypeNormal, "rollout-deferred", cond.Message)
+	}
+	meta.SetStatusCondition(&fufu.Status.Conditions, cond)
+
+	if st.NextWindow == nil {
+		return 0, nil
+	}
+	return st.NextWindow.Sub(now), nil
+}
@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/tools/record"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+func TestMaintenanceState(t *testing.T) {
+	// saturday nights from 2 to 4, Paris time, and the first of the month for an hour
+	windows := []catv1alpha2.MaintenanceWindow{
+		{Start: "0 2 * * 6", Duration: metav1.Duration{Duration: 2 * time.Hour}, TimeZone: "Europe/Paris"},
+		{Start: "0 12 1 * *", Duration: metav1.Duration{Duration: time.Hour}},
+	}
+
+	cases := []struct {
+		name string
+		now  time.Time
+		open bool
+		next time.Time
+	}{
+		{
+			name: "weekday",
+			now:  time.Date(2022, 6, 8, 12, 0, 0, 0, time.UTC),
+			next: time.Date(2022, 6, 11, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "saturday night",
+			now:  time.Date(2022, 6, 11, 1, 30, 0, 0, time.UTC),
+			open: true,
+			next: time.Date(2022, 6, 18, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "window over",
+			now:  time.Date(2022, 6, 11, 2, 0, 0, 0, time.UTC),
+			next: time.Date(2022, 6, 18, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "first of the month",
+			now:  time.Date(2022, 7, 1, 12, 59, 0, 0, time.UTC),
+			open: true,
+			next: time.Date(2022, 7, 2, 0, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			open, next, err := maintenanceState(windows, c.now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if open != c.open || !next.Equal(c.next) {
+				t.Errorf("open %v until %v, want %v until %v", open, next, c.open, c.next)
+			}
+		})
+	}
+
+	if _, _, err := maintenanceState([]catv1alpha2.MaintenanceWindow{{Start: "saturday"}}, time.Now()); err == nil {
+		t.Errorf("invalid window accepted")
+	}
+}
+
+func TestTemplateChanges(t *testing.T) {
+	r := &FufuReconciler{}
+	fufu := &catv1alpha2.Fufu{
+		ObjectMeta: metav1.ObjectMeta{Name: "fufu", Namespace: "default"},
+		Spec: catv1alpha2.FufuSpec{
+			Color:  "orange",
+			Weight: "5kg",
+		},
+	}
+	had := r.createDeploy(fufu)
+
+	changed := fufu.DeepCopy()
+	changed.Spec.Template.Image = "nginx:1.23"
+	changed.Spec.Color = "grey"
+	changed.Spec.Template.Resources = corev1.ResourceRequirements{
+		Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+	}
+	want := []string{"page content", "image of web: nginx:1.23", "resources of web"}
+	if got := templateChanges(r.createDeploy(changed), had); !reflect.DeepEqual(got, want) {
+		t.Errorf("changes %q, want %q", got, want)
+	}
+
+	labelled := fufu.DeepCopy()
+	labelled.Spec.CommonLabels = map[string]string{"team": "cats"}
+	if got := templateChanges(r.createDeploy(labelled), had); !reflect.DeepEqual(got, []string{"pod template"}) {
+		t.Errorf("changes %q, want the pod template", got)
+	}
+}
+
+func TestDeferRollout(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	r := &FufuReconciler{Recorder: recorder}
+	fufu := &catv1alpha2.Fufu{
+		ObjectMeta: metav1.ObjectMeta{Name: "fufu", Namespace: "default"},
+		Spec: catv1alpha2.FufuSpec{
+			MaintenanceWindows: []catv1alpha2.MaintenanceWindow{
+				{Start: "0 2 * * 6", Duration: metav1.Duration{Duration: 2 * time.Hour}},
+			},
+		},
+	}
+	ctx := context.Background()
+
+	// wednesday, outside the window
+	now := time.Date(2022, 6, 8, 12, 0, 0, 0, time.UTC)
+	r.updateMaintenance(fufu, now)
+	if !r.deferRollout(fufu, "nginx configuration") || !r.deferRollout(fufu, "nginx configuration", "page content") {
+		t.Fatalf("rollout not deferred outside the window")
+	}
+	if got := fufu.Status.Maintenance.PendingChanges; !reflect.DeepEqual(got, []string{"nginx configuration", "page content"}) {
+		t.Errorf("pending changes %q", got)
+	}
+
+	delay, err := r.finishMaintenance(fufu, now, ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 62 * time.Hour; delay != want {
+		t.Errorf("requeued after %v, want %v", delay, want)
+	}
+	cond := meta.FindStatusCondition(fufu.Status.Conditions, catv1alpha2.ConditionRolloutPending)
+	if cond == nil || cond.Status != metav1.ConditionTrue || !strings.Contains(cond.Message, "page content") {
+		t.Errorf("condition %+v", cond)
+	}
+	if e := <-recorder.Events; !strings.HasPrefix(e, "Normal rollout-deferred ") {
+		t.Errorf("event %q", e)
+	}
+
+	// saturday night, the window opens
+	now = time.Date(2022, 6, 11, 3, 0, 0, 0, time.UTC)
+	r.updateMaintenance(fufu, now)
+	if r.deferRollout(fufu, "page content") {
+		t.Errorf("rollout deferred in the window")
+	}
+	if delay, err := r.finishMaintenance(fufu, now, ctx); err != nil || delay != 0 {
+		t.Errorf("requeued after %v, error %v", delay, err)
+	}
+	if meta.IsStatusConditionTrue(fufu.Status.Conditions, catv1alpha2.ConditionRolloutPending) {
+		t.Errorf("rollout still pending in the window")
+	}
+
+	// the annotation rolls out whatever the windows
+	now = time.Date(2022, 6, 8, 12, 0, 0, 0, time.UTC)
+	r.updateMaintenance(fufu, now)
+	fufu.Annotations = map[string]string{catv1alpha2.RolloutNowAnnotation: "true"}
+	if r.deferRollout(fufu, "page content") {
+		t.Errorf("rollout deferred despite the annotation")
+	}
+
+	// invalid windows, as the webhook may be disabled, are reported once
+	fufu.Annotations = nil
+	fufu.Spec.MaintenanceWindows = []catv1alpha2.MaintenanceWindow{{Start: "saturday", Duration: metav1.Duration{Duration: time.Hour}}}
+	for i := 0; i < 3; i++ {
+		r.updateMaintenance(fufu, now)
+		if r.deferRollout(fufu, "page content") {
+			t.Errorf("rollout deferred by invalid windows")
+		}
+		if _, err := r.finishMaintenance(fufu, now, ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cond := meta.FindStatusCondition(fufu.Status.Conditions, catv1alpha2.ConditionRolloutPending); cond == nil || cond.Status != metav1.ConditionFalse || cond.Reason != "InvalidWindows" {
+		t.Errorf("condition %+v, want InvalidWindows", cond)
+	}
+	if e := <-recorder.Events; !strings.HasPrefix(e, "Warning invalid-maintenance-windows ") {
+		t.Errorf("event %q, want invalid-maintenance-windows", e)
+	}
+	if len(recorder.Events) != 0 {
+		t.Errorf("invalid windows reported again: %q", <-recorder.Events)
+	}
+
+	fufu.Spec.MaintenanceWindows = nil
+	r.updateMaintenance(fufu, now)
+	if fufu.Status.Maintenance != nil || meta.FindStatusCondition(fufu.Status.Conditions, catv1alpha2.ConditionRolloutPending) != nil {
+		t.Errorf("windows removed but status %+v", fufu.Status.Maintenance)
+	}
+}
+
+func TestRollbackOutsideWindow(t *testing.T) {
+	recorder := record.NewFakeRecorder(10)
+	r := &FufuReconciler{Recorder: recorder}
+	windows := []catv1alpha2.MaintenanceWindow{
+		{Start: "0 2 * * 6", Duration: metav1.Duration{Duration: 2 * time.Hour}},
+	}
+	fufu := &catv1alpha2.Fufu{
+		ObjectMeta: metav1.ObjectMeta{Name: "fufu", Namespace: "default"},
+		Spec: catv1alpha2.FufuSpec{
+			Template:           catv1alpha2.PodTemplate{Image: "nginx:broken"},
+			MaintenanceWindows: windows,
+		},
+		Status: catv1alpha2.FufuStatus{
+			CurrentRevision:       "fufu-broken",
+			LastAvailableRevision: "fufu-working",
+		},
+	}
+
+	// wednesday, outside the window, the broken image waits
+	r.updateMaintenance(fufu, time.Date(2022, 6, 8, 12, 0, 0, 0, time.UTC))
+	if !r.deferRollout(fufu, "image of web: nginx:broken") {
+		t.Fatalf("rollout not deferred outside the window")
+	}
+
+	// the pods still run the last available revision, no rollback is asked, no client is
+	// needed to tell it
+	if err := r.autoRollback(fufu, context.Background()); err != nil || len(recorder.Events) != 0 {
+		t.Errorf("rollback asked while the rollout is pending, error %v", err)
+	}
+
+	// a restored spec doesn't wait for the window
+	restoreSpec(fufu, &catv1alpha2.FufuSpec{MaintenanceWindows: windows})
+	r.updateMaintenance(fufu, time.Date(2022, 6, 8, 12, 1, 0, 0, time.UTC))
+	if fufu.Spec.Template.Image != "" || r.deferRollout(fufu, "image of web: nginx") {
+		t.Errorf("restored spec %+v deferred", fufu.Spec.Template)
+	}
+}
@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrutil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	corev1 "k8s.io/api/core/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+const (
+	defaultExporterImage  = "nginx/nginx-prometheus-exporter:0.10.0"
+	defaultScrapeInterval = "30s"
+	metricsPortName       = "metrics"
+	metricsPort           = 9113
+)
+
+var (
+	serviceMonitorGVK = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "ServiceMonitor"}
+	prometheusRuleGVK = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "PrometheusRule"}
+)
+
+// updateMonitoring manages the prometheus-operator objects of the Fufu, they are not watched
+// because the CRDs are optional in the cluster
+func (r *FufuReconciler) updateMonitoring(fufu *catv1alpha2.Fufu, ctx context.Context) error {
+	for _, wanted := range []*unstructured.Unstructured{r.createServiceMonitor(fufu), r.createPrometheusRule(fufu)} {
+		if err := r.updateMonitoringObject(fufu, wanted, ctx); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *FufuReconciler) updateMonitoringObject(fufu *catv1alpha2.Fufu, wanted *unstructured.Unstructured, ctx context.Context) error {
+	loggr := log.FromContext(ctx)
+	kind := wanted.GetKind()
+
+	if _, err := r.RESTMapper().RESTMapping(wanted.GroupVersionKind().GroupKind(), wanted.GroupVersionKind().Version); err != nil {
+		if meta.IsNoMatchError(err) {
+			if fufu.Spec.Monitoring.Enabled {
+				loggr.Info(fmt.Sprintf("%s is not installed in the cluster, skip it", kind))
+			}
+			return nil
+		}
+		return err
+	}
+
+	had := &unstructured.Unstructured{}
+	had.SetGroupVersionKind(wanted.GroupVersionKind())
+	if err := r.Get(ctx, types.NamespacedName{Name: wanted.GetName(), Namespace: wanted.GetNamespace()}, had); err == nil {
+		if !fufu.Spec.Monitoring.Enabled {
+			loggr.Info(fmt.Sprintf("Monitoring disabled, delete %s ...", kind))
+			if err = r.Delete(ctx, had); err != nil {
+				return client.IgnoreNotFound(err)
+			}
+			r.Recorder.Eventf(fufu, corev1.EventTypeNormal, "monitoring-deleted", "%s deleted", kind)
+			return nil
+		}
+
+		if !equality.Semantic.DeepEqual(wanted.Object["spec"], had.Object["spec"]) || metadataChanged(wanted, had) {
+			loggr.Info(fmt.Sprintf("A diff was found, update %s ...", kind))
+			drifted := markApplied(wanted, had)
+			ctrutil.SetControllerReference(fufu, wanted, r.Scheme)
+			wanted.SetResourceVersion(had.GetResourceVersion())
+			if err = r.Update(ctx, wanted); err != nil {
+				return err
+			}
+			r.childUpdated(fufu, drifted, "monitoring-updated", "%s updated", kind)
+		}
+		return nil
+	} else {
+		if err = client.IgnoreNotFound(err); err != nil {
+			return err
+		}
+		if !fufu.Spec.Monitoring.Enabled {
+			return nil
+		}
+
+		loggr.Info(fmt.Sprintf("Create %s ...", kind))
+		markApplied(wanted, nil)
+		ctrutil.SetControllerReference(fufu, wanted, r.Scheme)
+		if err = r.Create(ctx, wanted); err != nil {
+			loggr.Error(err, fmt.Sprintf("failed to create %s", kind))
+		}
+
+		r.Recorder.Eventf(fufu, corev1.EventTypeNormal, "monitoring-created", "%s created", kind)
+		return nil
+	}
+}
+
+func (r *FufuReconciler) createServiceMonitor(fufu *catv1alpha2.Fufu) *unstructured.Unstructured {
+	interval := defaultScrapeInterval
+	if fufu.Spec.Monitoring.Interval != "" {
+		interval = fufu.Spec.Monitoring.Interval
+	}
+
+	sm := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"selector": map[string]interface{}{
+					"matchLabels": map[string]interface{}{
+						"app": appLabel(fufu),
+					},
+				},
+				"namespaceSelector": map[string]interface{}{
+					"matchNames": []interface{}{fufu.Namespace},
+				},
+				"endpoints": []interface{}{
+					map[string]interface{}{
+						"port":     metricsPortName,
+						"interval": interval,
+					},
+				},
+			},
+		},
+	}
+	sm.SetGroupVersionKind(serviceMonitorGVK)
+	sm.SetName(childName(fufu, catv1alpha2.SuffixMonitor))
+	sm.SetNamespace(fufu.Namespace)
+	sm.SetLabels(commonLabels(fufu))
+	sm.SetAnnotations(commonAnnotations(fufu))
+
+	return sm
+}
+
+func (r *FufuReconciler) createPrometheusRule(fufu *catv1alpha2.Fufu) *unstructured.Unstructured {
+	selector := fmt.Sprintf(`namespace="%s",service="%s"`, fufu.Namespace, childName(fufu, catv1alpha2.SuffixService))
+
+	rule := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"groups": []interface{}{
+					map[string]interface{}{
+						"name": "fufu." + fufu.Name,
+						"rules": []interface{}{
+							map[string]interface{}{
+								"record": "fufu:nginx_http_requests:rate5m",
+								"expr":   fmt.Sprintf("sum(rate(nginx_http_requests_total{%s}[5m]))", selector),
+							},
+							map[string]interface{}{
+								"alert": "FufuNginxDown",
+								"expr":  fmt.Sprintf("max(nginx_up{%s}) < 1", selector),
+								"for":   "5m",
+								"labels": map[string]interface{}{
+									"severity": "warning",
+								},
+								"annotations": map[string]interface{}{
+									"summary": fmt.Sprintf("Fufu %s/%s's nginx is down", fufu.Namespace, fufu.Name),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	rule.SetGroupVersionKind(prometheusRuleGVK)
+	rule.SetName(childName(fufu, catv1alpha2.SuffixRules))
+	rule.SetNamespace(fufu.Namespace)
+	rule.SetLabels(commonLabels(fufu))
+	rule.SetAnnotations(commonAnnotations(fufu))
+
+	return rule
+}
@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "k8s.io/api/apps/v1"
+	asv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+// childName is the name of the Fufu's child with the given suffix, the default one if the
+// naming templates, maybe inherited from a class, can't render it
+func childName(fufu *catv1alpha2.Fufu, suffix string) string {
+	name, err := fufu.ChildName(suffix)
+	if err != nil {
+		return catv1alpha2.TruncateName(fufu.Name + "-" + suffix)
+	}
+	return name
+}
+
+// appLabel selects the pods of the Fufu. It keeps the default name of the Deployment whatever
+// the naming templates, the selector of a Deployment being immutable
+func appLabel(fufu *catv1alpha2.Fufu) string {
+	return catv1alpha2.TruncateName(fufu.Name + "-" + catv1alpha2.SuffixDeploy)
+}
+
+// resourceNames are the names of the children the spec asks for
+func (r *FufuReconciler) resourceNames(fufu *catv1alpha2.Fufu) *catv1alpha2.ResourceNames {
+	names := &catv1alpha2.ResourceNames{
+		ConfigMap:  childName(fufu, catv1alpha2.SuffixConfigMap),
+		Deployment: servingDeployName(fufu),
+		Service:    childName(fufu, catv1alpha2.SuffixService),
+	}
+	if autoscaled(fufu) {
+		names.HorizontalPodAutoscaler = childName(fufu, catv1alpha2.SuffixHpa)
+	}
+	if r.createPdb(fufu) != nil {
+		names.PodDisruptionBudget = childName(fufu, catv1alpha2.SuffixPdb)
+	}
+	if fufu.Spec.NetworkPolicy != nil {
+		names.NetworkPolicy = childName(fufu, catv1alpha2.SuffixNetpol)
+	}
+	if fufu.Spec.Monitoring.Enabled {
+		names.ServiceMonitor = childName(fufu, catv1alpha2.SuffixMonitor)
+		names.PrometheusRule = childName(fufu, catv1alpha2.SuffixRules)
+	}
+	if asleep(fufu) || wakingUp(fufu) {
+		names.Placeholder = childName(fufu, catv1alpha2.SuffixSleeping)
+	}
+
+	return names
+}
+
+// updateResources records the names of the Fufu's children. When the naming strategy changed,
+// the children named after the previous one are only deleted once the renamed Deployment and
+// Service are serving: the pods of both Deployments share the label selected by the Services
+func (r *FufuReconciler) updateResources(fufu *catv1alpha2.Fufu, ctx context.Context) error {
+	loggr := log.FromContext(ctx)
+
+	wanted := r.resourceNames(fufu)
+	prev := fufu.Status.Resources
+	current := map[string]bool{}
+	for _, suffix := range []string{catv1alpha2.SuffixDeploy, catv1alpha2.SuffixCanary, catv1alpha2.SuffixBlue, catv1alpha2.SuffixGreen} {
+		current[childName(fufu, suffix)] = true
+	}
+
+	monitor := &unstructured.Unstructured{}
+	monitor.SetGroupVersionKind(serviceMonitorGVK)
+	rule := &unstructured.Unstructured{}
+	rule.SetGroupVersionKind(prometheusRuleGVK)
+
+	type child struct {
+		name, wanted string
+		obj          client.Object
+	}
+	var previous []child
+	if prev != nil {
+		for _, c := range []child{
+			{prev.ConfigMap, wanted.ConfigMap, &corev1.ConfigMap{}},
+			{prev.Service, wanted.Service, &corev1.Service{}},
+			{prev.HorizontalPodAutoscaler, wanted.HorizontalPodAutoscaler, &asv2.HorizontalPodAutoscaler{}},
+			{prev.PodDisruptionBudget, wanted.PodDisruptionBudget, &policyv1.PodDisruptionBudget{}},
+			{prev.NetworkPolicy, wanted.NetworkPolicy, &netv1.NetworkPolicy{}},
+			{prev.ServiceMonitor, wanted.ServiceMonitor, monitor},
+			{prev.PrometheusRule, wanted.PrometheusRule, rule},
+			{prev.Placeholder, wanted.Placeholder, &appsv1.Deployment{}},
+		} {
+			// a child no longer asked for is deleted by its own update
+			if c.name != "" && c.wanted != "" && c.name != c.wanted {
+				previous = append(previous, c)
+			}
+		}
+	}
+
+	// the serving Deployment also changes with the colour of a blue-green Fufu
+	renamed := len(previous) > 0 || (prev != nil && prev.Deployment != wanted.Deployment && !current[prev.Deployment])
+	if !renamed {
+		fufu.Status.Resources = wanted
+		return nil
+	}
+
+	if serving, err := r.renamedServing(fufu, wanted, ctx); err != nil || !serving {
+		return err
+	}
+
+	for _, c := range previous {
+		if err := r.deleteOwned(fufu, c.name, c.obj, ctx); err != nil {
+			return err
+		}
+	}
+
+	// the previous Deployments, the canary and the colours included
+	deploys := &appsv1.DeploymentList{}
+	if err := r.List(ctx, deploys, client.InNamespace(fufu.Namespace), client.MatchingLabels{"app": appLabel(fufu)}); err != nil {
+		return err
+	}
+	for i := range deploys.Items {
+		if d := &deploys.Items[i]; !current[d.Name] {
+			if err := r.deleteOwned(fufu, d.Name, d, ctx); err != nil {
+				return err
+			}
+		}
+	}
+
+	loggr.Info(fmt.Sprintf("Children renamed: %+v", *wanted))
+	r.Recorder.Eventf(fufu, corev1.EventTypeNormal, "children-renamed", "Children renamed, deployment %s and service %s are serving", wanted.Deployment, wanted.Service)
+	fufu.Status.Resources = wanted
+	return nil
+}
+
+// renamedServing tells whether the renamed Deployment is available and the renamed Service,
+// if a LoadBalancer, has got an address
+func (r *FufuReconciler) renamedServing(fufu *catv1alpha2.Fufu, wanted *catv1alpha2.ResourceNames, ctx context.Context) (bool, error) {
+	deploy := &appsv1.Deployment{}
+	if err := r.Get(ctx, types.NamespacedName{Name: wanted.Deployment, Namespace: fufu.Namespace}, deploy); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	if !deployAvailable(deploy) {
+		return false, nil
+	}
+
+	svc := &corev1.Service{}
+	if err := r.Get(ctx, types.NamespacedName{Name: wanted.Service, Namespace: fufu.Namespace}, svc); err != nil {
+		return false, client.IgnoreNotFound(err)
+	}
+	return svc.Spec.Type != corev1.ServiceTypeLoadBalancer || len(svc.Status.LoadBalancer.Ingress) > 0, nil
+}
+
+// deleteOwned deletes the named child if the Fufu controls it, it may be gone already
+func (r *FufuReconciler) deleteOwned(fufu *catv1alpha2.Fufu, name string, obj client.Object, ctx context.Context) error {
+	if err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: fufu.Namespace}, obj); err != nil {
+		if meta.IsNoMatchError(err) {
+			return nil
+		}
+		return client.IgnoreNotFound(err)
+	}
+	if !metav1.IsControlledBy(obj, fufu) {
+		return nil
+	}
+
+	log.FromContext(ctx).Info(fmt.Sprintf("Delete previous child %s ...", name))
+	return client.IgnoreNotFound(r.Delete(ctx, obj))
+}
@@ -0,0 +1,252 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrutil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	corev1 "k8s.io/api/core/v1"
+	netv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+func (r *FufuReconciler) updateNetpol(fufu *catv1alpha2.Fufu, ctx context.Context) error {
+	loggr := log.FromContext(ctx)
+
+	var proxies []string
+	if fufu.Spec.NetworkPolicy != nil {
+		var err error
+		if proxies, err = r.householdProxies(fufu, ctx); err != nil {
+			return err
+		}
+	}
+	wanted := r.createNetpol(fufu, proxies)
+
+	had := &netv1.NetworkPolicy{}
+	if err := r.Get(ctx, types.NamespacedName{Name: childName(fufu, catv1alpha2.SuffixNetpol), Namespace: fufu.Namespace}, had); err == nil {
+		if wanted == nil {
+			loggr.Info("Network policy removed from spec, delete netpol ...")
+			if err = r.Delete(ctx, had); err != nil {
+				return client.IgnoreNotFound(err)
+			}
+			r.Recorder.Event(fufu, corev1.EventTypeNormal, "netpol-deleted", "NetworkPolicy deleted")
+			return nil
+		}
+
+		// compare both ways, removing a source should be reflected as well
+		if !equality.Semantic.DeepEqual(wanted.Spec, had.Spec) || metadataChanged(wanted, had) {
+			loggr.Info("A diff was found, update netpol ...")
+			drifted := markApplied(wanted, had)
+			ctrutil.SetControllerReference(fufu, wanted, r.Scheme)
+			wanted.ResourceVersion = had.ResourceVersion
+			if err = r.Update(ctx, wanted); err != nil {
+				return err
+			}
+			r.childUpdated(fufu, drifted, "netpol-updated", "NetworkPolicy updated")
+		}
+		return nil
+	} else {
+		if err = client.IgnoreNotFound(err); err != nil {
+			return err
+		}
+		if wanted == nil {
+			return nil
+		}
+
+		loggr.Info("Create netpol ...")
+		markApplied(wanted, nil)
+		ctrutil.SetControllerReference(fufu, wanted, r.Scheme)
+		if err = r.Create(ctx, wanted); err != nil {
+			loggr.Error(err, "failed to create netpol")
+		}
+
+		r.Recorder.Event(fufu, corev1.EventTypeNormal, "netpol-created", "NetworkPolicy created")
+		return nil
+	}
+}
+
+// householdProxies returns the app labels of the pods of the households having the Fufu as a
+// member, they proxy to its Service
+func (r *FufuReconciler) householdProxies(fufu *catv1alpha2.Fufu, ctx context.Context) ([]string, error) {
+	hhs := &catv1alpha2.FufuHouseholdList{}
+	if err := r.List(ctx, hhs, client.InNamespace(fufu.Namespace)); err != nil {
+		return nil, err
+	}
+
+	proxies := []string{}
+	for i := range hhs.Items {
+		hh := &hhs.Items[i]
+		selector, err := metav1.LabelSelectorAsSelector(&hh.Spec.Selector)
+		if err != nil || !hh.DeletionTimestamp.IsZero() || !selector.Matches(labels.Set(fufu.Labels)) {
+			continue
+		}
+		proxies = append(proxies, householdChildName(hh))
+	}
+	sort.Strings(proxies)
+
+	return proxies, nil
+}
+
+// findFufusForHousehold maps a FufuHousehold to the Fufus of its namespace having a network
+// policy, the ones it no longer selects have to close their policy as well
+func (r *FufuReconciler) findFufusForHousehold(obj client.Object) []reconcile.Request {
+	fufus := &catv1alpha2.FufuList{}
+	if err := r.List(context.Background(), fufus, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+
+	reqs := []reconcile.Request{}
+	for _, fufu := range fufus.Items {
+		if fufu.Spec.NetworkPolicy != nil {
+			reqs = append(reqs, reconcile.Request{NamespacedName: types.NamespacedName{Name: fufu.Name, Namespace: fufu.Namespace}})
+		}
+	}
+
+	return reqs
+}
+
+// createNetpol returns nil when the Fufu doesn't ask for a network policy. The pods of the
+// households proxying to the Fufu, given by their app label, are let in as well
+func (r *FufuReconciler) createNetpol(fufu *catv1alpha2.Fufu, proxies []string) *netv1.NetworkPolicy {
+	spec := fufu.Spec.NetworkPolicy
+	if spec == nil {
+		return nil
+	}
+
+	tcp, udp := corev1.ProtocolTCP, corev1.ProtocolUDP
+	webPort := intstr.FromInt(80)
+
+	from := []netv1.NetworkPolicyPeer{}
+	if len(spec.AllowedNamespaces) > 0 {
+		from = append(from, netv1.NetworkPolicyPeer{
+			NamespaceSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{
+						Key:      corev1.LabelMetadataName,
+						Operator: metav1.LabelSelectorOpIn,
+						Values:   spec.AllowedNamespaces,
+					},
+				},
+			},
+		})
+	}
+	for i := range spec.AllowedPods {
+		from = append(from, netv1.NetworkPolicyPeer{
+			PodSelector: spec.AllowedPods[i].DeepCopy(),
+		})
+	}
+	if len(proxies) > 0 {
+		from = append(from, netv1.NetworkPolicyPeer{
+			PodSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{
+						Key:      "app",
+						Operator: metav1.LabelSelectorOpIn,
+						Values:   proxies,
+					},
+				},
+			},
+		})
+	}
+
+	// a rule without peers would allow everyone, leave it out to deny all instead
+	ingress := []netv1.NetworkPolicyIngressRule{}
+	if len(from) > 0 {
+		ingress = append(ingress, netv1.NetworkPolicyIngressRule{
+			From: from,
+			Ports: []netv1.NetworkPolicyPort{
+				{
+					Protocol: &tcp,
+					Port:     &webPort,
+				},
+			},
+		})
+	}
+
+	// the exporter is scraped from the Prometheus namespace, not by the page's visitors
+	if fufu.Spec.Monitoring.Enabled {
+		scrapePort := intstr.FromInt(metricsPort)
+		rule := netv1.NetworkPolicyIngressRule{
+			Ports: []netv1.NetworkPolicyPort{
+				{
+					Protocol: &tcp,
+					Port:     &scrapePort,
+				},
+			},
+		}
+		if len(spec.ScrapingNamespaces) > 0 {
+			rule.From = []netv1.NetworkPolicyPeer{
+				{
+					NamespaceSelector: &metav1.LabelSelector{
+						MatchExpressions: []metav1.LabelSelectorRequirement{
+							{
+								Key:      corev1.LabelMetadataName,
+								Operator: metav1.LabelSelectorOpIn,
+								Values:   spec.ScrapingNamespaces,
+							},
+						},
+					},
+				},
+			}
+		}
+		ingress = append(ingress, rule)
+	}
+
+	policyTypes := []netv1.PolicyType{netv1.PolicyTypeIngress}
+	var egress []netv1.NetworkPolicyEgressRule
+	if spec.DenyEgress {
+		dnsPort := intstr.FromInt(53)
+		policyTypes = append(policyTypes, netv1.PolicyTypeEgress)
+		egress = []netv1.NetworkPolicyEgressRule{
+			{
+				Ports: []netv1.NetworkPolicyPort{
+					{Protocol: &udp, Port: &dnsPort},
+					{Protocol: &tcp, Port: &dnsPort},
+				},
+			},
+		}
+	}
+
+	// the placeholder serves the page while the Fufu sleeps, it is let in alike
+	selector := metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			"app": appLabel(fufu),
+		},
+	}
+	if fufu.Spec.Hibernation != nil || wakingUp(fufu) {
+		selector = metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{
+				{
+					Key:      "app",
+					Operator: metav1.LabelSelectorOpIn,
+					Values:   []string{appLabel(fufu), placeholderLabel(fufu)},
+				},
+			},
+		}
+	}
+
+	return &netv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        childName(fufu, catv1alpha2.SuffixNetpol),
+			Namespace:   fufu.Namespace,
+			Labels:      commonLabels(fufu),
+			Annotations: commonAnnotations(fufu),
+		},
+		Spec: netv1.NetworkPolicySpec{
+			PodSelector: selector,
+			Ingress:     ingress,
+			Egress:      egress,
+			PolicyTypes: policyTypes,
+		},
+	}
+}
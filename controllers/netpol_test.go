@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+func TestNetpolScraping(t *testing.T) {
+	r := &FufuReconciler{}
+	fufu := &catv1alpha2.Fufu{
+		ObjectMeta: metav1.ObjectMeta{Name: "fufu", Namespace: "default"},
+		Spec: catv1alpha2.FufuSpec{
+			NetworkPolicy: &catv1alpha2.NetworkPolicySpec{AllowedNamespaces: []string{"front"}},
+		},
+	}
+
+	if ingress := r.createNetpol(fufu, nil).Spec.Ingress; len(ingress) != 1 || ingress[0].Ports[0].Port.IntValue() != 80 {
+		t.Fatalf("ingress %+v, want the web port only", ingress)
+	}
+
+	fufu.Spec.Monitoring.Enabled = true
+	ingress := r.createNetpol(fufu, nil).Spec.Ingress
+	if len(ingress) != 2 || ingress[1].Ports[0].Port.IntValue() != metricsPort || len(ingress[1].From) != 0 {
+		t.Fatalf("ingress %+v, want the metrics port open to any namespace", ingress)
+	}
+
+	fufu.Spec.NetworkPolicy.ScrapingNamespaces = []string{"monitoring"}
+	ingress = r.createNetpol(fufu, nil).Spec.Ingress
+	if len(ingress) != 2 || len(ingress[1].From) != 1 || ingress[1].From[0].NamespaceSelector.MatchExpressions[0].Values[0] != "monitoring" {
+		t.Errorf("ingress %+v, want the metrics port open to the monitoring namespace", ingress)
+	}
+}
+
+func TestNetpolDenyEgress(t *testing.T) {
+	r := &FufuReconciler{}
+	fufu := &catv1alpha2.Fufu{
+		ObjectMeta: metav1.ObjectMeta{Name: "fufu", Namespace: "default"},
+		Spec: catv1alpha2.FufuSpec{
+			NetworkPolicy: &catv1alpha2.NetworkPolicySpec{DenyEgress: true},
+		},
+	}
+
+	egress := r.createNetpol(fufu, nil).Spec.Egress
+	if len(egress) != 1 {
+		t.Fatalf("egress %+v, want a single rule", egress)
+	}
+	for _, p := range egress[0].Ports {
+		if p.Port.IntValue() != 53 {
+			t.Errorf("egress open to port %s, want DNS only", p.Port)
+		}
+	}
+}
+
+func TestNetpolHouseholdProxies(t *testing.T) {
+	r := &FufuReconciler{}
+	fufu := &catv1alpha2.Fufu{
+		ObjectMeta: metav1.ObjectMeta{Name: "fufu", Namespace: "default"},
+		Spec: catv1alpha2.FufuSpec{
+			NetworkPolicy: &catv1alpha2.NetworkPolicySpec{},
+		},
+	}
+
+	if ingress := r.createNetpol(fufu, nil).Spec.Ingress; len(ingress) != 0 {
+		t.Fatalf("ingress %+v, want all denied", ingress)
+	}
+
+	ingress := r.createNetpol(fufu, []string{"huo-household"}).Spec.Ingress
+	if len(ingress) != 1 || len(ingress[0].From) != 1 || ingress[0].Ports[0].Port.IntValue() != 80 {
+		t.Fatalf("ingress %+v, want the web port open to the household", ingress)
+	}
+	if expr := ingress[0].From[0].PodSelector.MatchExpressions[0]; expr.Key != "app" || expr.Values[0] != "huo-household" {
+		t.Errorf("peer %+v, want the household's pods", expr)
+	}
+}
+
+func TestNetpolSleeping(t *testing.T) {
+	r := &FufuReconciler{}
+	fufu := &catv1alpha2.Fufu{
+		ObjectMeta: metav1.ObjectMeta{Name: "fufu", Namespace: "default"},
+		Spec: catv1alpha2.FufuSpec{
+			NetworkPolicy: &catv1alpha2.NetworkPolicySpec{AllowedNamespaces: []string{"front"}},
+			Hibernation:   &catv1alpha2.HibernationSpec{Asleep: true},
+		},
+		Status: catv1alpha2.FufuStatus{
+			Hibernation: &catv1alpha2.HibernationStatus{Asleep: true},
+		},
+	}
+
+	netpol := r.createNetpol(fufu, nil)
+	selector, err := metav1.LabelSelectorAsSelector(&netpol.Spec.PodSelector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pods := r.createPlaceholder(fufu).Spec.Template.Labels; !selector.Matches(labels.Set(pods)) {
+		t.Errorf("selector %s misses the placeholder pods %v", selector, pods)
+	}
+	if pods := r.createDeploy(fufu).Spec.Template.Labels; !selector.Matches(labels.Set(pods)) {
+		t.Errorf("selector %s misses the fufu's pods %v", selector, pods)
+	}
+	if len(netpol.Spec.Ingress) != 1 || len(netpol.Spec.Ingress[0].From) != 1 {
+		t.Errorf("ingress %+v, want the same rules as awake", netpol.Spec.Ingress)
+	}
+}
@@ -0,0 +1,450 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+// The notifications sent about a Fufu, the CloudEvent type is prefixed with notificationTypePrefix
+const (
+	NotificationCreated        = "created"
+	NotificationReady          = "ready"
+	NotificationNotReady       = "not-ready"
+	NotificationDrifted        = "drifted"
+	NotificationRolledBack     = "rolled-back"
+	NotificationScalingLimited = "scaling-limited"
+
+	notificationTypePrefix = "io.huozj.cat.fufu."
+
+	defaultNotificationBatchSize     = 10
+	defaultNotificationFlushInterval = 5 * time.Second
+	defaultNotificationMaxRetries    = 5
+	defaultNotificationRetryBackoff  = time.Second
+	defaultNotificationTimeout       = 10 * time.Second
+	maxNotificationRetryBackoff      = time.Minute
+	notificationQueueSize            = 1000
+)
+
+var notificationTypes = []string{
+	NotificationCreated, NotificationReady, NotificationNotReady,
+	NotificationDrifted, NotificationRolledBack, NotificationScalingLimited,
+}
+
+// NotificationConfig lists the sinks the Fufu's lifecycle events are sent to, it is read from
+// the file given to --notification-config
+type NotificationConfig struct {
+	Sinks []NotificationSink `json:"sinks"`
+}
+
+// NotificationSink receives the CloudEvents over HTTP, in batches once more than one is due
+type NotificationSink struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Namespaces routes the notifications about the Fufus of these namespaces only, all if empty
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Types are the notifications sent, like ready or drifted, all if empty
+	Types []string `json:"types,omitempty"`
+	// Headers are added to the requests, like an Authorization
+	Headers map[string]string `json:"headers,omitempty"`
+	// BatchSize is the most events sent in one request, default to 10
+	BatchSize int `json:"batchSize,omitempty"`
+	// FlushInterval is the longest an event waits for its batch to fill, default to 5s
+	FlushInterval metav1.Duration `json:"flushInterval,omitempty"`
+	// MaxRetries of a failed request, default to 5. Requests rejected with a 4xx other than
+	// 429 are not retried
+	MaxRetries *int `json:"maxRetries,omitempty"`
+	// RetryBackoff is the delay before the first retry, doubled with each of the next ones up
+	// to a minute, default to 1s
+	RetryBackoff metav1.Duration `json:"retryBackoff,omitempty"`
+	// Timeout of a request, default to 10s
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+}
+
+// LoadNotificationConfig reads the sinks from a YAML or JSON file
+func LoadNotificationConfig(path string) (*NotificationConfig, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg := &NotificationConfig{}
+	if err := yaml.NewYAMLOrJSONDecoder(f, 4096).Decode(cfg); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("decode %s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+func (c *NotificationConfig) validate() error {
+	names := map[string]bool{}
+	for i, s := range c.Sinks {
+		if s.Name == "" {
+			return fmt.Errorf("sinks[%d]: name is required", i)
+		}
+		if names[s.Name] {
+			return fmt.Errorf("sinks[%d]: name %s is duplicated", i, s.Name)
+		}
+		names[s.Name] = true
+
+		if _, err := http.NewRequest(http.MethodPost, s.URL, nil); err != nil || s.URL == "" {
+			return fmt.Errorf("sink %s: invalid url %q", s.Name, s.URL)
+		}
+		for _, t := range s.Types {
+			known := false
+			for _, nt := range notificationTypes {
+				known = known || t == nt
+			}
+			if !known {
+				return fmt.Errorf("sink %s: unknown type %q, expected one of %v", s.Name, t, notificationTypes)
+			}
+		}
+		if s.BatchSize < 0 || (s.MaxRetries != nil && *s.MaxRetries < 0) {
+			return fmt.Errorf("sink %s: batchSize and maxRetries can't be negative", s.Name)
+		}
+	}
+
+	return nil
+}
+
+// CloudEvent is a CloudEvents 1.0 event in the structured JSON format
+type CloudEvent struct {
+	SpecVersion     string           `json:"specversion"`
+	ID              string           `json:"id"`
+	Source          string           `json:"source"`
+	Type            string           `json:"type"`
+	Subject         string           `json:"subject"`
+	Time            time.Time        `json:"time"`
+	DataContentType string           `json:"datacontenttype"`
+	Data            NotificationData `json:"data"`
+}
+
+// NotificationData tells what happened to the Fufu, after the Kubernetes event
+type NotificationData struct {
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	Generation int64  `json:"generation"`
+	// EventType is Normal or Warning
+	EventType string `json:"eventType"`
+	Reason    string `json:"reason"`
+	Message   string `json:"message"`
+}
+
+// Notifier sends the Fufu's lifecycle events to the sinks. It runs with the manager, on the
+// leader only, each sink has its own queue so a slow one doesn't hold the others back
+type Notifier struct {
+	sinks []*notificationSink
+	now   func() time.Time
+}
+
+type notificationSink struct {
+	NotificationSink
+	queue  chan CloudEvent
+	client *http.Client
+}
+
+// NewNotifier returns a Notifier sending to the configured sinks, defaults applied
+func NewNotifier(cfg *NotificationConfig) *Notifier {
+	n := &Notifier{now: time.Now}
+	for _, s := range cfg.Sinks {
+		if s.BatchSize == 0 {
+			s.BatchSize = defaultNotificationBatchSize
+		}
+		if s.FlushInterval.Duration <= 0 {
+			s.FlushInterval.Duration = defaultNotificationFlushInterval
+		}
+		if s.MaxRetries == nil {
+			retries := defaultNotificationMaxRetries
+			s.MaxRetries = &retries
+		}
+		if s.RetryBackoff.Duration <= 0 {
+			s.RetryBackoff.Duration = defaultNotificationRetryBackoff
+		}
+		if s.Timeout.Duration <= 0 {
+			s.Timeout.Duration = defaultNotificationTimeout
+		}
+
+		n.sinks = append(n.sinks, &notificationSink{
+			NotificationSink: s,
+			queue:            make(chan CloudEvent, notificationQueueSize),
+			client:           &http.Client{Timeout: s.Timeout.Duration},
+		})
+	}
+
+	return n
+}
+
+// Start runs the sinks until ctx is done, the queued events are flushed before it returns
+func (n *Notifier) Start(ctx context.Context) error {
+	loggr := log.FromContext(ctx).WithName("notifier")
+
+	done := make(chan struct{})
+	for _, s := range n.sinks {
+		go func(s *notificationSink) {
+			s.run(log.IntoContext(ctx, loggr.WithValues("sink", s.Name)))
+			done <- struct{}{}
+		}(s)
+	}
+	for range n.sinks {
+		<-done
+	}
+
+	return nil
+}
+
+// Notify queues the notification to the sinks routing it, an event is dropped rather than
+// holding the reconcile back when a queue is full
+func (n *Notifier) Notify(fufu *catv1alpha2.Fufu, notification, eventtype, reason, message string) {
+	event := CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              string(uuid.NewUUID()),
+		Source:          fmt.Sprintf("/apis/%s/namespaces/%s/fufus/%s", catv1alpha2.GroupVersion, fufu.Namespace, fufu.Name),
+		Type:            notificationTypePrefix + notification,
+		Subject:         fufu.Namespace + "/" + fufu.Name,
+		Time:            n.now().UTC(),
+		DataContentType: "application/json",
+		Data: NotificationData{
+			Namespace:  fufu.Namespace,
+			Name:       fufu.Name,
+			Generation: fufu.Generation,
+			EventType:  eventtype,
+			Reason:     reason,
+			Message:    message,
+		},
+	}
+
+	for _, s := range n.sinks {
+		if !s.routes(fufu.Namespace, notification) {
+			continue
+		}
+
+		select {
+		case s.queue <- event:
+		default:
+			log.Log.WithName("notifier").Info("Queue full, notification dropped", "sink", s.Name, "type", event.Type, "subject", event.Subject)
+		}
+	}
+}
+
+// routes tells if the sink wants the notification about a Fufu of the namespace
+func (s *notificationSink) routes(namespace, notification string) bool {
+	return (len(s.Namespaces) == 0 || containsAny(s.Namespaces, namespace)) &&
+		(len(s.Types) == 0 || containsAny(s.Types, notification))
+}
+
+func containsAny(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// run sends the queued events once a batch is full or the oldest one waited for the flush
+// interval
+func (s *notificationSink) run(ctx context.Context) {
+	var batch []CloudEvent
+	timer := time.NewTimer(s.FlushInterval.Duration)
+	timer.Stop()
+
+	flush := func(ctx context.Context) {
+		if len(batch) > 0 {
+			s.send(ctx, batch)
+			batch = nil
+		}
+	}
+
+	for {
+		select {
+		case event := <-s.queue:
+			if len(batch) == 0 {
+				timer.Reset(s.FlushInterval.Duration)
+			}
+			batch = append(batch, event)
+			if len(batch) >= s.BatchSize {
+				timer.Stop()
+				flush(ctx)
+			}
+		case <-timer.C:
+			flush(ctx)
+		case <-ctx.Done():
+			timer.Stop()
+			// the manager is stopping, give what is left one last try
+			last, cancel := context.WithTimeout(log.IntoContext(context.Background(), log.FromContext(ctx)), s.Timeout.Duration)
+			defer cancel()
+			for {
+				select {
+				case event := <-s.queue:
+					batch = append(batch, event)
+					if len(batch) >= s.BatchSize {
+						flush(last)
+					}
+				default:
+					flush(last)
+					return
+				}
+			}
+		}
+	}
+}
+
+// send posts the batch, retried with a growing backoff while the sink fails or is unreachable
+func (s *notificationSink) send(ctx context.Context, batch []CloudEvent) {
+	loggr := log.FromContext(ctx)
+
+	backoff := s.RetryBackoff.Duration
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(ctx, batch)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= *s.MaxRetries {
+			loggr.Error(err, fmt.Sprintf("%d notifications dropped after %d attempts", len(batch), attempt+1))
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			loggr.Error(err, fmt.Sprintf("%d notifications dropped on shutdown", len(batch)))
+			return
+		}
+		if backoff *= 2; backoff > maxNotificationRetryBackoff {
+			backoff = maxNotificationRetryBackoff
+		}
+	}
+}
+
+// post sends a single event in the structured mode and more than one in the batched mode. It
+// tells if a failed request is worth retrying
+func (s *notificationSink) post(ctx context.Context, batch []CloudEvent) (bool, error) {
+	var body interface{} = batch
+	contentType := "application/cloudevents-batch+json"
+	if len(batch) == 1 {
+		body, contentType = batch[0], "application/cloudevents+json"
+	}
+	raw, err := json.Marshal(body)
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(raw))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range s.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("sink %s answered %s", s.Name, resp.Status)
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, err
+}
+
+// Recorder records the events with rec and notifies the ones telling about the Fufu's
+// lifecycle
+func (n *Notifier) Recorder(rec record.EventRecorder) record.EventRecorder {
+	return &notifyingRecorder{EventRecorder: rec, notifier: n}
+}
+
+type notifyingRecorder struct {
+	record.EventRecorder
+	notifier *Notifier
+}
+
+func (r *notifyingRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	r.EventRecorder.Event(object, eventtype, reason, message)
+	r.notify(object, nil, eventtype, reason, message)
+}
+
+func (r *notifyingRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.EventRecorder.Eventf(object, eventtype, reason, messageFmt, args...)
+	r.notify(object, nil, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (r *notifyingRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	r.EventRecorder.AnnotatedEventf(object, annotations, eventtype, reason, messageFmt, args...)
+	r.notify(object, annotations, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (r *notifyingRecorder) notify(object runtime.Object, annotations map[string]string, eventtype, reason, message string) {
+	fufu, ok := object.(*catv1alpha2.Fufu)
+	if !ok {
+		return
+	}
+	if notification := notificationFor(fufu, reason, annotations); notification != "" {
+		r.notifier.Notify(fufu, notification, eventtype, reason, message)
+	}
+}
+
+// driftedAnnotation flags the event of a child put back as applied after someone else changed
+// it, the updates following the spec or the controller's own changes are not drifts
+const driftedAnnotation = "cat.huozj.io/drifted"
+
+// childUpdated records the update of a child, flagged when it had drifted as told by markApplied
+func (r *FufuReconciler) childUpdated(fufu *catv1alpha2.Fufu, drifted bool, reason, messageFmt string, args ...interface{}) {
+	var annotations map[string]string
+	if drifted {
+		annotations = map[string]string{driftedAnnotation: "true"}
+	}
+	r.Recorder.AnnotatedEventf(fufu, annotations, corev1.EventTypeNormal, reason, messageFmt, args...)
+}
+
+// notificationFor tells which notification the event is, if any. The status in memory is the
+// one of the previous reconcile until written back, the Deployment created while no generation
+// was observed is the Fufu's creation
+func notificationFor(fufu *catv1alpha2.Fufu, reason string, annotations map[string]string) string {
+	observed := fufu.Status.ObservedGeneration
+
+	switch reason {
+	case "deploy-created":
+		if observed == 0 {
+			return NotificationCreated
+		}
+	case "fufu-ready":
+		return NotificationReady
+	case "fufu-not-ready":
+		return NotificationNotReady
+	case "auto-rollback", "rolled-back":
+		return NotificationRolledBack
+	case "scaling-limited":
+		return NotificationScalingLimited
+	case "configmap-updated", "deploy-updated", "svc-updated", "pdb-updated", "netpol-updated", "hpa-updated", "monitoring-updated":
+		if annotations[driftedAnnotation] == "true" {
+			return NotificationDrifted
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,363 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/record"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+// sinkServer records the CloudEvents it receives, failing the first requests if told so
+type sinkServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []sinkRequest
+	failures []int
+}
+
+type sinkRequest struct {
+	contentType string
+	header      http.Header
+	events      []CloudEvent
+}
+
+func newSinkServer(t *testing.T, failures ...int) *sinkServer {
+	s := &sinkServer{failures: failures}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if len(s.failures) > 0 {
+			w.WriteHeader(s.failures[0])
+			s.failures = s.failures[1:]
+			return
+		}
+
+		raw, _ := io.ReadAll(req.Body)
+		r := sinkRequest{contentType: req.Header.Get("Content-Type"), header: req.Header}
+		if r.contentType == "application/cloudevents-batch+json" {
+			if err := json.Unmarshal(raw, &r.events); err != nil {
+				t.Errorf("invalid batch: %v", err)
+			}
+		} else {
+			var event CloudEvent
+			if err := json.Unmarshal(raw, &event); err != nil {
+				t.Errorf("invalid event: %v", err)
+			}
+			r.events = []CloudEvent{event}
+		}
+		s.requests = append(s.requests, r)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *sinkServer) received() []sinkRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]sinkRequest(nil), s.requests...)
+}
+
+func (s *sinkServer) events() []CloudEvent {
+	var events []CloudEvent
+	for _, r := range s.received() {
+		events = append(events, r.events...)
+	}
+	return events
+}
+
+// startNotifier runs the notifier until the test ends
+func startNotifier(t *testing.T, sinks ...NotificationSink) (*Notifier, context.CancelFunc) {
+	n := NewNotifier(&NotificationConfig{Sinks: sinks})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		n.Start(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return n, cancel
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func notifiedFufu(namespace string) *catv1alpha2.Fufu {
+	return &catv1alpha2.Fufu{
+		ObjectMeta: metav1.ObjectMeta{Name: "fufu", Namespace: namespace, Generation: 2},
+	}
+}
+
+func TestNotifierBatches(t *testing.T) {
+	srv := newSinkServer(t)
+	n, _ := startNotifier(t, NotificationSink{
+		Name:          "chat",
+		URL:           srv.URL,
+		Headers:       map[string]string{"Authorization": "Bearer fufu"},
+		BatchSize:     3,
+		FlushInterval: metav1.Duration{Duration: time.Hour},
+	})
+
+	fufu := notifiedFufu("default")
+	for _, notification := range []string{NotificationCreated, NotificationNotReady, NotificationReady} {
+		n.Notify(fufu, notification, corev1.EventTypeNormal, "reason", "message")
+	}
+	waitFor(t, "the batch", func() bool { return len(srv.received()) > 0 })
+
+	reqs := srv.received()
+	if len(reqs) != 1 || len(reqs[0].events) != 3 {
+		t.Fatalf("requests %+v, want a single batch of 3", reqs)
+	}
+	if reqs[0].contentType != "application/cloudevents-batch+json" || reqs[0].header.Get("Authorization") != "Bearer fufu" {
+		t.Errorf("content type %q, headers %v", reqs[0].contentType, reqs[0].header)
+	}
+
+	e := reqs[0].events[2]
+	if e.SpecVersion != "1.0" || e.ID == "" || e.Type != "io.huozj.cat.fufu.ready" ||
+		e.Source != "/apis/cat.huozj.io/v1alpha2/namespaces/default/fufus/fufu" || e.Subject != "default/fufu" {
+		t.Errorf("event %+v", e)
+	}
+	if e.Data.Name != "fufu" || e.Data.Generation != 2 || e.Data.Reason != "reason" {
+		t.Errorf("data %+v", e.Data)
+	}
+}
+
+func TestNotifierFlushes(t *testing.T) {
+	srv := newSinkServer(t)
+	n, _ := startNotifier(t, NotificationSink{
+		Name:          "chat",
+		URL:           srv.URL,
+		FlushInterval: metav1.Duration{Duration: 50 * time.Millisecond},
+	})
+
+	// a lone event waits for the flush interval only, and goes structured
+	n.Notify(notifiedFufu("default"), NotificationDrifted, corev1.EventTypeNormal, "deploy-updated", "Deployment updated")
+	waitFor(t, "the flush", func() bool { return len(srv.received()) > 0 })
+	if r := srv.received()[0]; r.contentType != "application/cloudevents+json" || r.events[0].Type != "io.huozj.cat.fufu.drifted" {
+		t.Errorf("request %+v", r)
+	}
+
+	// what is queued is sent on shutdown
+	n, cancel := startNotifier(t, NotificationSink{
+		Name:          "chat",
+		URL:           srv.URL,
+		FlushInterval: metav1.Duration{Duration: time.Hour},
+	})
+	n.Notify(notifiedFufu("default"), NotificationReady, corev1.EventTypeNormal, "fufu-ready", "ready")
+	n.Notify(notifiedFufu("default"), NotificationNotReady, corev1.EventTypeWarning, "fufu-not-ready", "not ready")
+	cancel()
+	waitFor(t, "the last flush", func() bool { return len(srv.events()) == 3 })
+}
+
+func TestNotifierRetries(t *testing.T) {
+	cases := []struct {
+		name     string
+		failures []int
+		retries  int
+		wantSent bool
+	}{
+		{name: "unavailable then fine", failures: []int{503, 429}, retries: 2, wantSent: true},
+		{name: "out of retries", failures: []int{503, 503, 503}, retries: 2},
+		{name: "rejected", failures: []int{400}, retries: 2},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			srv := newSinkServer(t, c.failures...)
+			retries := c.retries
+			sink := NewNotifier(&NotificationConfig{Sinks: []NotificationSink{{
+				Name:         "ops",
+				URL:          srv.URL,
+				MaxRetries:   &retries,
+				RetryBackoff: metav1.Duration{Duration: time.Millisecond},
+			}}}).sinks[0]
+
+			sink.send(context.Background(), []CloudEvent{{SpecVersion: "1.0", ID: "1"}})
+			if sent := len(srv.events()) == 1; sent != c.wantSent {
+				t.Errorf("sent %v, want %v", sent, c.wantSent)
+			}
+		})
+	}
+}
+
+func TestNotifierRoutes(t *testing.T) {
+	chat, ops := newSinkServer(t), newSinkServer(t)
+	n, _ := startNotifier(t,
+		NotificationSink{Name: "chat", URL: chat.URL, Namespaces: []string{"team-a"}, FlushInterval: metav1.Duration{Duration: 10 * time.Millisecond}},
+		NotificationSink{Name: "ops", URL: ops.URL, Types: []string{NotificationRolledBack}, FlushInterval: metav1.Duration{Duration: 10 * time.Millisecond}},
+	)
+
+	n.Notify(notifiedFufu("team-a"), NotificationReady, corev1.EventTypeNormal, "fufu-ready", "ready")
+	n.Notify(notifiedFufu("team-b"), NotificationReady, corev1.EventTypeNormal, "fufu-ready", "ready")
+	n.Notify(notifiedFufu("team-b"), NotificationRolledBack, corev1.EventTypeWarning, "auto-rollback", "rolling back")
+	waitFor(t, "the notifications", func() bool { return len(chat.events()) > 0 && len(ops.events()) > 0 })
+	time.Sleep(50 * time.Millisecond)
+
+	if events := chat.events(); len(events) != 1 || events[0].Data.Namespace != "team-a" {
+		t.Errorf("chat received %+v", events)
+	}
+	if events := ops.events(); len(events) != 1 || events[0].Type != "io.huozj.cat.fufu.rolled-back" {
+		t.Errorf("ops received %+v", events)
+	}
+}
+
+func TestMarkApplied(t *testing.T) {
+	r := &FufuReconciler{}
+	fufu := &catv1alpha2.Fufu{ObjectMeta: metav1.ObjectMeta{Name: "fufu", Namespace: "default"}}
+
+	applied := r.createSvc(fufu)
+	if markApplied(applied, nil) {
+		t.Errorf("created svc reported drifted")
+	}
+
+	// edited by someone else, the controller applies the same again
+	edited := applied.DeepCopy()
+	edited.Spec.Type = corev1.ServiceTypeNodePort
+	edited.ResourceVersion = "42"
+	if !markApplied(r.createSvc(fufu), edited) {
+		t.Errorf("edited svc not reported drifted")
+	}
+
+	// changed by the controller itself, as the Fufu falls asleep
+	fufu.Spec.Hibernation = &catv1alpha2.HibernationSpec{Asleep: true}
+	fufu.Status.Hibernation = &catv1alpha2.HibernationStatus{Asleep: true}
+	if markApplied(r.createSvc(fufu), applied) {
+		t.Errorf("svc following the hibernation reported drifted")
+	}
+
+	// the hpa's bounds edited by hand are put back and notified
+	hpa := r.createHpa(fufu)
+	markApplied(hpa, nil)
+	editedHpa := hpa.DeepCopy()
+	editedHpa.Spec.MaxReplicas = 20
+	if !markApplied(r.createHpa(fufu), editedHpa) {
+		t.Errorf("edited hpa not reported drifted")
+	}
+	if n := notificationFor(fufu, "hpa-updated", map[string]string{driftedAnnotation: "true"}); n != NotificationDrifted {
+		t.Errorf("hpa-updated notified as %q", n)
+	}
+}
+
+func TestNotifyingRecorder(t *testing.T) {
+	srv := newSinkServer(t)
+	n, _ := startNotifier(t, NotificationSink{Name: "chat", URL: srv.URL, FlushInterval: metav1.Duration{Duration: 10 * time.Millisecond}})
+	fake := record.NewFakeRecorder(10)
+	recorder := n.Recorder(fake)
+
+	created := notifiedFufu("default")
+	recorder.Event(created, corev1.EventTypeNormal, "deploy-created", "Deployment created")
+
+	// put back as applied, or following the spec
+	steady := notifiedFufu("default")
+	steady.Status.ObservedGeneration = 2
+	recorder.AnnotatedEventf(steady, map[string]string{driftedAnnotation: "true"}, corev1.EventTypeNormal, "svc-updated", "Service updated")
+	recorder.Event(steady, corev1.EventTypeNormal, "svc-updated", "Service updated")
+	recorder.Eventf(steady, corev1.EventTypeWarning, "scaling-limited", "%s: %s", "TooManyReplicas", "the desired replica count is more than the maximum")
+	recorder.Event(steady, corev1.EventTypeNormal, "birthday", "Happy birthday")
+	recorder.Event(&corev1.Pod{}, corev1.EventTypeNormal, "fufu-ready", "not a fufu")
+
+	if len(fake.Events) != 6 {
+		t.Errorf("%d events recorded, want 6", len(fake.Events))
+	}
+
+	want := []string{"io.huozj.cat.fufu.created", "io.huozj.cat.fufu.drifted", "io.huozj.cat.fufu.scaling-limited"}
+	waitFor(t, "the notifications", func() bool { return len(srv.events()) >= len(want) })
+	time.Sleep(50 * time.Millisecond)
+
+	events := srv.events()
+	if len(events) != len(want) {
+		t.Fatalf("notified %+v, want %v", events, want)
+	}
+	for i, e := range events {
+		if e.Type != want[i] {
+			t.Errorf("notification %d is %s, want %s", i, e.Type, want[i])
+		}
+	}
+	if msg := events[2].Data.Message; msg != "TooManyReplicas: the desired replica count is more than the maximum" {
+		t.Errorf("message %q", msg)
+	}
+}
+
+func TestLoadNotificationConfig(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name: "yaml",
+			content: `sinks:
+- name: chat
+  url: https://chat.example.com/hooks/fufu
+  namespaces: [team-a]
+  types: [ready, not-ready, drifted]
+  batchSize: 20
+  flushInterval: 10s
+`,
+		},
+		{
+			name:    "json",
+			content: `{"sinks":[{"name":"ops","url":"http://ops.example.com"}]}`,
+		},
+		{
+			name:    "missing url",
+			content: "sinks:\n- name: chat\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown type",
+			content: "sinks:\n- name: chat\n  url: http://chat\n  types: [deleted]\n",
+			wantErr: true,
+		},
+		{
+			name:    "duplicated name",
+			content: "sinks:\n- name: chat\n  url: http://a\n- name: chat\n  url: http://b\n",
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "notifications.yaml")
+			if err := os.WriteFile(path, []byte(c.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			cfg, err := LoadNotificationConfig(path)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, c.wantErr)
+			}
+			if c.name == "yaml" {
+				s := NewNotifier(cfg).sinks[0]
+				if s.BatchSize != 20 || s.FlushInterval.Duration != 10*time.Second || *s.MaxRetries != defaultNotificationMaxRetries {
+					t.Errorf("sink %+v", s.NotificationSink)
+				}
+			}
+		})
+	}
+}
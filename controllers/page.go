@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	_ "embed"
+	"regexp"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+// pageTemplate ships with the controller which renders the page, so the page matches the
+// variables of pageEnv. k8s/nginx/index.html.tmpl stays for the controllers already deployed
+//
+//go:embed templates/index.html.tmpl
+var pageTemplate string
+
+var pageVariable = regexp.MustCompile(`\$\{?([A-Za-z_][A-Za-z0-9_]*)\}?`)
+
+// pageEnv returns the variables substituted in the page's template,
+// the age computed into the status by updateAge is used if any
+func pageEnv(fufu *catv1alpha2.Fufu) []corev1.EnvVar {
+	pronounIs, possessive := "They are", "their"
+	switch fufu.Spec.Info.Sex {
+	case "male":
+		pronounIs, possessive = "He is", "his"
+	case "female":
+		pronounIs, possessive = "She is", "her"
+	}
+
+	vaccinationClass, vaccinationStatus := "not-vaccinated", "Not vaccinated"
+	if fufu.Spec.Info.Vaccinated {
+		vaccinationClass, vaccinationStatus = "vaccinated", "Vaccinated"
+	}
+
+	return []corev1.EnvVar{
+		{
+			Name:  "FUR_COLOR",
+			Value: fufu.Spec.Color,
+		},
+		{
+			Name:  "BREED",
+			Value: fufu.Spec.Info.Breed,
+		},
+		{
+			Name:  "AGE",
+			Value: pageAge(fufu),
+		},
+		{
+			Name:  "WEIGHT",
+			Value: pageWeight(fufu.Spec.Weight),
+		},
+		{
+			Name:  "PRONOUN_IS",
+			Value: pronounIs,
+		},
+		{
+			Name:  "POSSESSIVE",
+			Value: possessive,
+		},
+		{
+			Name:  "VACCINATION_CLASS",
+			Value: vaccinationClass,
+		},
+		{
+			Name:  "VACCINATION_STATUS",
+			Value: vaccinationStatus,
+		},
+	}
+}
+
+// pageWeight keeps the unit the former template added to a bare number
+func pageWeight(weight string) string {
+	if _, err := strconv.ParseFloat(weight, 64); err == nil {
+		return weight + " kg"
+	}
+	return weight
+}
+
+// renderPage substitutes only the variables of pageEnv in the page's template, like envsubst
+// given the list of the variables, so any other $ in the page is left untouched. The init
+// container only writes the page down, it needs no package nor egress
+func renderPage(fufu *catv1alpha2.Fufu) string {
+	values := map[string]string{}
+	for _, e := range pageEnv(fufu) {
+		values[e.Name] = e.Value
+	}
+
+	return pageVariable.ReplaceAllStringFunc(pageTemplate, func(v string) string {
+		if value, ok := values[pageVariable.FindStringSubmatch(v)[1]]; ok {
+			return value
+		}
+		return v
+	})
+}
@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files under testdata")
+
+func TestRenderPage(t *testing.T) {
+	cases := []struct {
+		golden string
+		spec   catv1alpha2.FufuSpec
+		status catv1alpha2.FufuStatus
+	}{
+		{
+			golden: "male-vaccinated.html",
+			spec: catv1alpha2.FufuSpec{
+				Color:  "orange",
+				Weight: "5kg",
+				Age:    6,
+				Info:   catv1alpha2.AdditionalInfo{Breed: "stray", Vaccinated: true, Sex: "male"},
+			},
+		},
+		{
+			golden: "female-not-vaccinated.html",
+			spec: catv1alpha2.FufuSpec{
+				Color:  "black",
+				Weight: "3.5kg",
+				Age:    2,
+				Info:   catv1alpha2.AdditionalInfo{Breed: "bombay", Sex: "female"},
+			},
+		},
+		{
+			golden: "unknown-sex.html",
+			spec: catv1alpha2.FufuSpec{
+				Color:  "white",
+				Weight: "4kg",
+				Age:    1,
+				Info:   catv1alpha2.AdditionalInfo{Vaccinated: true},
+			},
+		},
+		{
+			golden: "bare-weight.html",
+			spec: catv1alpha2.FufuSpec{
+				Color:  "calico",
+				Weight: "4.2",
+				Age:    3,
+				Info:   catv1alpha2.AdditionalInfo{Breed: "stray", Sex: "female"},
+			},
+		},
+		{
+			golden: "kitten-birth-date.html",
+			spec: catv1alpha2.FufuSpec{
+				Color:     "grey",
+				Weight:    "1kg",
+				Age:       1,
+				BirthDate: "2022-01-15",
+				Info:      catv1alpha2.AdditionalInfo{Breed: "chartreux", Sex: "female"},
+			},
+			status: catv1alpha2.FufuStatus{
+				Age: &catv1alpha2.AgeStatus{Years: 0, Months: 5},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.golden, func(t *testing.T) {
+			fufu := &catv1alpha2.Fufu{Spec: c.spec, Status: c.status}
+			got := renderPage(fufu)
+
+			path := filepath.Join("testdata", "pages", c.golden)
+			if *updateGolden {
+				if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("rendered page differs from %s, run the test with -update if intended:\n%s", path, got)
+			}
+		})
+	}
+}
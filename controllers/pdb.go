@@ -0,0 +1,97 @@
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrutil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+func (r *FufuReconciler) updatePdb(fufu *catv1alpha2.Fufu, ctx context.Context) error {
+	loggr := log.FromContext(ctx)
+
+	wanted := r.createPdb(fufu)
+
+	had := &policyv1.PodDisruptionBudget{}
+	if err := r.Get(ctx, types.NamespacedName{Name: childName(fufu, catv1alpha2.SuffixPdb), Namespace: fufu.Namespace}, had); err == nil {
+		// a single replica can't be protected without blocking node drains
+		if wanted == nil {
+			loggr.Info("Fufu's floor dropped to 1 replica, delete pdb ...")
+			if err = r.Delete(ctx, had); err != nil {
+				return client.IgnoreNotFound(err)
+			}
+			r.Recorder.Event(fufu, corev1.EventTypeNormal, "pdb-deleted", "PodDisruptionBudget deleted")
+			return nil
+		}
+
+		if !equality.Semantic.DeepDerivative(wanted.Spec, had.Spec) || metadataChanged(wanted, had) {
+			loggr.Info("A diff was found, update pdb ...")
+			drifted := markApplied(wanted, had)
+			ctrutil.SetControllerReference(fufu, wanted, r.Scheme)
+			wanted.ResourceVersion = had.ResourceVersion
+			if err = r.Update(ctx, wanted); err != nil {
+				return err
+			}
+			r.childUpdated(fufu, drifted, "pdb-updated", "PodDisruptionBudget updated")
+		}
+		return nil
+	} else {
+		if err = client.IgnoreNotFound(err); err != nil {
+			return err
+		}
+		if wanted == nil {
+			return nil
+		}
+
+		loggr.Info("Create pdb ...")
+		markApplied(wanted, nil)
+		ctrutil.SetControllerReference(fufu, wanted, r.Scheme)
+		if err = r.Create(ctx, wanted); err != nil {
+			loggr.Error(err, "failed to create pdb")
+		}
+
+		r.Recorder.Event(fufu, corev1.EventTypeNormal, "pdb-created", "PodDisruptionBudget created")
+		return nil
+	}
+}
+
+// createPdb returns nil when the Fufu may run a single replica
+func (r *FufuReconciler) createPdb(fufu *catv1alpha2.Fufu) *policyv1.PodDisruptionBudget {
+	floor := replicasFloor(fufu)
+	if floor <= 1 {
+		return nil
+	}
+
+	// let the pods be evicted one at a time by default
+	minAvailable := intstr.FromInt(int(floor - 1))
+	if fufu.Spec.DisruptionBudget != nil && fufu.Spec.DisruptionBudget.MinAvailable != nil {
+		minAvailable = *fufu.Spec.DisruptionBudget.MinAvailable
+	}
+
+	return &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        childName(fufu, catv1alpha2.SuffixPdb),
+			Namespace:   fufu.Namespace,
+			Labels:      commonLabels(fufu),
+			Annotations: commonAnnotations(fufu),
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			MinAvailable: &minAvailable,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					"app": appLabel(fufu),
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,157 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	corev1 "k8s.io/api/core/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+// updatePodIssues summarizes the failures of the pods of the Fufu, all variants included
+func (r *FufuReconciler) updatePodIssues(fufu *catv1alpha2.Fufu, ctx context.Context) error {
+	pods := &corev1.PodList{}
+	if err := r.List(ctx, pods, client.InNamespace(fufu.Namespace), client.MatchingLabels{"app": appLabel(fufu)}); err != nil {
+		return err
+	}
+
+	fufu.Status.PodIssues = collectPodIssues(pods.Items)
+	return nil
+}
+
+// collectPodIssues groups the failures by container and reason, the message of the newest
+// pod is kept. Sorted so the status only changes with the issues
+func collectPodIssues(pods []corev1.Pod) []catv1alpha2.PodIssue {
+	sort.SliceStable(pods, func(i, j int) bool {
+		return pods[i].CreationTimestamp.Before(&pods[j].CreationTimestamp)
+	})
+
+	type key struct{ container, reason string }
+	found := map[key]*catv1alpha2.PodIssue{}
+	add := func(container, reason, message string) {
+		k := key{container, reason}
+		if found[k] == nil {
+			found[k] = &catv1alpha2.PodIssue{Reason: reason, Container: container}
+		}
+		found[k].Count++
+		found[k].Message = message
+	}
+
+	for _, pod := range pods {
+		if !pod.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == corev1.PodScheduled && cond.Status == corev1.ConditionFalse && cond.Reason == corev1.PodReasonUnschedulable {
+				add("", cond.Reason, cond.Message)
+			}
+		}
+		for i := range pod.Status.InitContainerStatuses {
+			cs := &pod.Status.InitContainerStatuses[i]
+			if reason, message := containerIssue(cs, true); reason != "" {
+				add(cs.Name, reason, message)
+			}
+		}
+		for i := range pod.Status.ContainerStatuses {
+			cs := &pod.Status.ContainerStatuses[i]
+			if reason, message := containerIssue(cs, false); reason != "" {
+				add(cs.Name, reason, message)
+			}
+		}
+	}
+
+	issues := make([]catv1alpha2.PodIssue, 0, len(found))
+	for _, issue := range found {
+		issues = append(issues, *issue)
+	}
+	sort.Slice(issues, func(i, j int) bool {
+		if issues[i].Container != issues[j].Container {
+			return issues[i].Container < issues[j].Container
+		}
+		return issues[i].Reason < issues[j].Reason
+	})
+
+	if len(issues) == 0 {
+		return nil
+	}
+	return issues
+}
+
+// containerIssue tells why the container doesn't run, empty if it does or is starting. An
+// init container exiting with an error is an issue as well, it is retried
+func containerIssue(cs *corev1.ContainerStatus, init bool) (reason, message string) {
+	if last := cs.LastTerminationState.Terminated; last != nil {
+		message = fmt.Sprintf("last exit %s with code %d", last.Reason, last.ExitCode)
+		if last.Reason == "OOMKilled" && cs.State.Running == nil {
+			return last.Reason, message
+		}
+	}
+
+	switch {
+	case cs.State.Waiting != nil:
+		waiting := cs.State.Waiting
+		if waiting.Reason == "ContainerCreating" || waiting.Reason == "PodInitializing" {
+			return "", ""
+		}
+		if message == "" {
+			message = waiting.Message
+		}
+		return waiting.Reason, message
+	case cs.State.Terminated != nil:
+		terminated := cs.State.Terminated
+		if terminated.Reason == "OOMKilled" || (init && terminated.ExitCode != 0) {
+			message = fmt.Sprintf("exit %s with code %d", terminated.Reason, terminated.ExitCode)
+			if terminated.Message != "" {
+				message += ": " + strings.TrimSpace(terminated.Message)
+			}
+			return terminated.Reason, message
+		}
+	}
+
+	return "", ""
+}
+
+// findFufuForPod maps a pod to its Fufu through its instance label, the pods are not owned
+// by the Fufu
+func (r *FufuReconciler) findFufuForPod(obj client.Object) []reconcile.Request {
+	app, instance := obj.GetLabels()["app"], obj.GetLabels()[instanceLabel]
+	if app == "" || instance == "" {
+		return nil
+	}
+
+	fufu := &catv1alpha2.Fufu{}
+	if err := r.Get(context.Background(), types.NamespacedName{Name: instance, Namespace: obj.GetNamespace()}, fufu); err == nil && appLabel(fufu) == app {
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: fufu.Name, Namespace: fufu.Namespace}}}
+	}
+
+	// a long name is truncated in the label, look for the Fufu having it
+	fufus := &catv1alpha2.FufuList{}
+	if err := r.List(context.Background(), fufus, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+	for _, fufu := range fufus.Items {
+		if appLabel(&fufu) == app {
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: fufu.Name, Namespace: fufu.Namespace}}}
+		}
+	}
+
+	return nil
+}
+
+// CacheSelectors restricts the pods cached by the manager to the ones of the Fufus, the
+// controller watches them to report their issues
+func CacheSelectors() cache.SelectorsByObject {
+	return cache.SelectorsByObject{
+		&corev1.Pod{}: {Label: labels.SelectorFromSet(labels.Set{managedByLabel: managedBy})},
+	}
+}
@@ -0,0 +1,98 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+func TestCollectPodIssues(t *testing.T) {
+	t0 := time.Date(2022, time.June, 1, 0, 0, 0, 0, time.UTC)
+	pod := func(name string, age time.Duration, status corev1.PodStatus) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(t0.Add(-age))},
+			Status:     status,
+		}
+	}
+	initFailed := func(msg string) corev1.PodStatus {
+		return corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "prepare-webcontent",
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "Error", ExitCode: 1, Message: msg}},
+			}},
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "web",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "PodInitializing"}},
+			}},
+		}
+	}
+
+	cases := []struct {
+		name string
+		pods []corev1.Pod
+		want []catv1alpha2.PodIssue
+	}{
+		{
+			name: "running",
+			pods: []corev1.Pod{pod("a", 0, corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "web",
+				State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+			}}})},
+		},
+		{
+			name: "init container failing on every pod",
+			pods: []corev1.Pod{
+				pod("new", time.Minute, initFailed("wget: bad address 'raw.githubusercontent.com'\n")),
+				pod("old", time.Hour, initFailed("wget: download timed out")),
+			},
+			want: []catv1alpha2.PodIssue{{
+				Reason:    "Error",
+				Container: "prepare-webcontent",
+				Count:     2,
+				Message:   "exit Error with code 1: wget: bad address 'raw.githubusercontent.com'",
+			}},
+		},
+		{
+			name: "mixed failures",
+			pods: []corev1.Pod{
+				pod("oom", time.Minute, corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+					Name:                 "web",
+					State:                corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+					LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137}},
+				}}}),
+				pod("pull", time.Minute, corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+					Name:  "web",
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: `Back-off pulling image "ngnix"`}},
+				}}}),
+				pod("pending", time.Minute, corev1.PodStatus{
+					Phase: corev1.PodPending,
+					Conditions: []corev1.PodCondition{{
+						Type:    corev1.PodScheduled,
+						Status:  corev1.ConditionFalse,
+						Reason:  corev1.PodReasonUnschedulable,
+						Message: "0/3 nodes are available: 3 Insufficient memory.",
+					}},
+				}),
+			},
+			want: []catv1alpha2.PodIssue{
+				{Reason: "Unschedulable", Count: 1, Message: "0/3 nodes are available: 3 Insufficient memory."},
+				{Reason: "ImagePullBackOff", Container: "web", Count: 1, Message: `Back-off pulling image "ngnix"`},
+				{Reason: "OOMKilled", Container: "web", Count: 1, Message: "last exit OOMKilled with code 137"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := collectPodIssues(c.pods); !equality.Semantic.DeepEqual(got, c.want) {
+				t.Errorf("collectPodIssues() = %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
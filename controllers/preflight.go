@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	authv1 "k8s.io/api/authorization/v1"
+)
+
+// Permission is a verb the manager needs on a kind of resource, cluster wide
+type Permission struct {
+	Group       string
+	Resource    string
+	Subresource string
+	Verb        string
+}
+
+func (p Permission) String() string {
+	res := p.Resource
+	if p.Subresource != "" {
+		res += "/" + p.Subresource
+	}
+	if p.Group != "" {
+		res += "." + p.Group
+	}
+
+	return p.Verb + " " + res
+}
+
+// manageVerbs are needed on every kind owned by the Fufu or the FufuHousehold
+var manageVerbs = []string{"get", "list", "watch", "create", "update", "patch", "delete"}
+
+// requiredPermissions should follow the rbac markers of the reconcilers, TestRequiredPermissions
+// checks them against config/rbac/role.yaml
+var requiredPermissions = func() []Permission {
+	perms := []Permission{
+		{Resource: "events", Verb: "create"},
+		{Resource: "events", Verb: "patch"},
+	}
+
+	for _, verb := range []string{"get", "list", "watch"} {
+		perms = append(perms,
+			Permission{Group: "cat.huozj.io", Resource: "fufuclasses", Verb: verb},
+			Permission{Resource: "pods", Verb: verb},
+		)
+	}
+
+	for _, kind := range []string{"fufus", "fufuhouseholds"} {
+		perms = append(perms,
+			Permission{Group: "cat.huozj.io", Resource: kind, Verb: "get"},
+			Permission{Group: "cat.huozj.io", Resource: kind, Verb: "list"},
+			Permission{Group: "cat.huozj.io", Resource: kind, Verb: "watch"},
+			Permission{Group: "cat.huozj.io", Resource: kind, Verb: "patch"},
+			Permission{Group: "cat.huozj.io", Resource: kind, Subresource: "status", Verb: "update"},
+		)
+	}
+
+	owned := []Permission{
+		{Resource: "configmaps"},
+		{Resource: "services"},
+		{Group: "apps", Resource: "deployments"},
+		{Group: "apps", Resource: "controllerrevisions"},
+		{Group: "autoscaling", Resource: "horizontalpodautoscalers"},
+		{Group: "policy", Resource: "poddisruptionbudgets"},
+		{Group: "networking.k8s.io", Resource: "networkpolicies"},
+		{Group: "networking.k8s.io", Resource: "ingresses"},
+		{Group: "monitoring.coreos.com", Resource: "servicemonitors"},
+		{Group: "monitoring.coreos.com", Resource: "prometheusrules"},
+	}
+	for _, o := range owned {
+		for _, verb := range manageVerbs {
+			perms = append(perms, Permission{Group: o.Group, Resource: o.Resource, Verb: verb})
+		}
+	}
+
+	return perms
+}()
+
+// PermissionChecker makes sure the manager's service account is granted all the
+// permissions the reconciler needs, through SelfSubjectAccessReviews
+type PermissionChecker struct {
+	Client client.Client
+	// RecheckInterval throttles the reviews issued by the readyz check while permissions are missing
+	RecheckInterval time.Duration
+
+	mu        sync.Mutex
+	missing   []Permission
+	checked   bool
+	lastCheck time.Time
+}
+
+func NewPermissionChecker(c client.Client) *PermissionChecker {
+	return &PermissionChecker{
+		Client:          c,
+		RecheckInterval: 30 * time.Second,
+	}
+}
+
+// Check reviews every required permission and returns the missing ones
+func (p *PermissionChecker) Check(ctx context.Context) ([]Permission, error) {
+	var missing []Permission
+	for _, perm := range requiredPermissions {
+		review := &authv1.SelfSubjectAccessReview{
+			Spec: authv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authv1.ResourceAttributes{
+					Group:       perm.Group,
+					Resource:    perm.Resource,
+					Subresource: perm.Subresource,
+					Verb:        perm.Verb,
+				},
+			},
+		}
+		if err := p.Client.Create(ctx, review); err != nil {
+			return nil, err
+		}
+		if !review.Status.Allowed {
+			missing = append(missing, perm)
+		}
+	}
+
+	p.mu.Lock()
+	p.missing, p.checked, p.lastCheck = missing, true, time.Now()
+	p.mu.Unlock()
+
+	return missing, nil
+}
+
+// Preflight runs the check once at startup and logs the missing permissions
+func (p *PermissionChecker) Preflight(ctx context.Context) error {
+	loggr := log.FromContext(ctx)
+
+	missing, err := p.Check(ctx)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		loggr.Info(fmt.Sprintf("Missing RBAC permissions, Fufus won't be reconciled correctly until granted: %s", joinPermissions(missing)))
+		return nil
+	}
+
+	loggr.Info("All the RBAC permissions needed are granted")
+	return nil
+}
+
+// Readyz reports the missing permissions, it is meant to be used as a healthz.Checker
+func (p *PermissionChecker) Readyz(req *http.Request) error {
+	p.mu.Lock()
+	missing, checked, stale := p.missing, p.checked, time.Since(p.lastCheck) > p.RecheckInterval
+	p.mu.Unlock()
+
+	// the role may have been fixed since the last review
+	if !checked || (len(missing) > 0 && stale) {
+		var err error
+		if missing, err = p.Check(req.Context()); err != nil {
+			return err
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing RBAC permissions: %s", joinPermissions(missing))
+	}
+	return nil
+}
+
+func joinPermissions(perms []Permission) string {
+	strs := make([]string, 0, len(perms))
+	for _, perm := range perms {
+		strs = append(strs, perm.String())
+	}
+
+	return strings.Join(strs, ", ")
+}
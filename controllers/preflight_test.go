@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Test permission preflight", func() {
+	Specify("envtest's admin is granted all the permissions", func() {
+		checker := NewPermissionChecker(k8sClient)
+
+		missing, err := checker.Check(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(missing).To(BeEmpty())
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/readyz", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(checker.Readyz(req)).To(Succeed())
+	})
+
+	Specify("missing permissions are reported through readyz", func() {
+		checker := NewPermissionChecker(k8sClient)
+		checker.missing = []Permission{{Group: "apps", Resource: "deployments", Verb: "update"}}
+		checker.checked = true
+		checker.lastCheck = time.Now()
+		checker.RecheckInterval = time.Hour
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/readyz", nil)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(checker.Readyz(req)).To(MatchError(ContainSubstring("update deployments.apps")))
+	})
+})
+
+func TestRequiredPermissions(t *testing.T) {
+	f, err := os.Open(filepath.Join("..", "config", "rbac", "role.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	role := &rbacv1.ClusterRole{}
+	if err := yaml.NewYAMLOrJSONDecoder(f, 4096).Decode(role); err != nil {
+		t.Fatal(err)
+	}
+
+	granted := map[Permission]bool{}
+	for _, rule := range role.Rules {
+		for _, group := range rule.APIGroups {
+			for _, res := range rule.Resources {
+				resource, sub, _ := strings.Cut(res, "/")
+				for _, verb := range rule.Verbs {
+					granted[Permission{Group: group, Resource: resource, Subresource: sub, Verb: verb}] = true
+				}
+			}
+		}
+	}
+
+	required := map[Permission]bool{}
+	for _, perm := range requiredPermissions {
+		required[perm] = true
+		if !granted[perm] {
+			t.Errorf("%s is required but not granted by the rbac markers", perm)
+		}
+	}
+
+	// scaffolded on the Fufu's own kinds, the reconcilers don't use them
+	scaffolded := func(p Permission) bool {
+		switch {
+		case p.Subresource == "finalizers":
+			return true
+		case p.Subresource == "status":
+			return p.Verb == "get" || p.Verb == "patch"
+		case p.Group == "cat.huozj.io":
+			return p.Verb == "create" || p.Verb == "update" || p.Verb == "delete"
+		}
+		return false
+	}
+	for perm := range granted {
+		if !required[perm] && !scaffolded(perm) {
+			t.Errorf("%s is granted by the rbac markers but not checked by the preflight", perm)
+		}
+	}
+}
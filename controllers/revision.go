@@ -0,0 +1,224 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrutil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+const (
+	revisionFufuLabel = catv1alpha2.FufuLabel
+	revisionHashLabel = "cat.huozj.io/revision-hash"
+	// rollbackPodsAnnotation set along the rollback-to annotation restores only what shapes
+	// the pods, it is set by the automatic rollbacks
+	rollbackPodsAnnotation = "cat.huozj.io/rollback-pods"
+
+	defaultRevisionHistoryLimit = 10
+)
+
+// revisionSpec is the part of the spec recorded by a revision, the history limit and the
+// replicas set through the scale subresource are left out so tuning them neither creates a
+// revision nor gets rolled back
+func revisionSpec(spec *catv1alpha2.FufuSpec) *catv1alpha2.FufuSpec {
+	s := spec.DeepCopy()
+	s.RevisionHistoryLimit = nil
+	s.Replicas = nil
+	return s
+}
+
+func hashSpec(spec *catv1alpha2.FufuSpec) (string, []byte, error) {
+	raw, err := json.Marshal(revisionSpec(spec))
+	if err != nil {
+		return "", nil, err
+	}
+	sum := sha256.Sum256(raw)
+
+	return hex.EncodeToString(sum[:])[:10], raw, nil
+}
+
+// newRevision records raw as the revision of the Fufu's spec hashed to hash, the name and
+// the label are truncated like the children's names as the Fufu's name may be long
+func newRevision(fufu *catv1alpha2.Fufu, hash string, raw []byte, revision int64) *appsv1.ControllerRevision {
+	return &appsv1.ControllerRevision{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      catv1alpha2.TruncateName(fufu.Name + "-" + hash),
+			Namespace: fufu.Namespace,
+			Labels: map[string]string{
+				revisionFufuLabel: catv1alpha2.TruncateName(fufu.Name),
+				revisionHashLabel: hash,
+			},
+		},
+		Data:     runtime.RawExtension{Raw: raw},
+		Revision: revision,
+	}
+}
+
+// listRevisions returns the Fufu's revisions sorted from the oldest to the newest
+func (r *FufuReconciler) listRevisions(fufu *catv1alpha2.Fufu, ctx context.Context) ([]appsv1.ControllerRevision, error) {
+	revs := &appsv1.ControllerRevisionList{}
+	if err := r.List(ctx, revs, client.InNamespace(fufu.Namespace), client.MatchingLabels{revisionFufuLabel: catv1alpha2.TruncateName(fufu.Name)}); err != nil {
+		return nil, err
+	}
+	sort.Slice(revs.Items, func(i, j int) bool { return revs.Items[i].Revision < revs.Items[j].Revision })
+
+	return revs.Items, nil
+}
+
+// updateRevision snapshots the applied spec, given as it was before merging the class, into a
+// ControllerRevision and prunes the history. Going back to a recorded spec makes its revision
+// the newest one instead of recording a duplicate, like the StatefulSet controller does
+func (r *FufuReconciler) updateRevision(fufu *catv1alpha2.Fufu, spec *catv1alpha2.FufuSpec, ctx context.Context) error {
+	loggr := log.FromContext(ctx)
+
+	hash, raw, err := hashSpec(spec)
+	if err != nil {
+		return err
+	}
+
+	revs, err := r.listRevisions(fufu, ctx)
+	if err != nil {
+		return err
+	}
+
+	var next int64 = 1
+	var current *appsv1.ControllerRevision
+	for i := range revs {
+		if revs[i].Labels[revisionHashLabel] == hash {
+			current = &revs[i]
+		}
+	}
+	if len(revs) > 0 {
+		next = revs[len(revs)-1].Revision + 1
+	}
+
+	switch {
+	case current == nil:
+		current = newRevision(fufu, hash, raw, next)
+		ctrutil.SetControllerReference(fufu, current, r.Scheme)
+		loggr.Info(fmt.Sprintf("Record revision %d ...", next))
+		if err := r.Create(ctx, current); err != nil {
+			return err
+		}
+		revs = append(revs, *current)
+	case current.Revision != revs[len(revs)-1].Revision:
+		loggr.Info(fmt.Sprintf("Spec back to revision %d, renumber it to %d ...", current.Revision, next))
+		current.Revision = next
+		if err := r.Update(ctx, current); err != nil {
+			return err
+		}
+		sort.Slice(revs, func(i, j int) bool { return revs[i].Revision < revs[j].Revision })
+	}
+	fufu.Status.CurrentRevision = current.Name
+
+	// the current revision is the last one and never pruned
+	limit := defaultRevisionHistoryLimit
+	if fufu.Spec.RevisionHistoryLimit != nil {
+		limit = int(*fufu.Spec.RevisionHistoryLimit)
+	}
+	for i := 0; i < len(revs)-1-limit; i++ {
+		loggr.Info(fmt.Sprintf("Prune revision %d ...", revs[i].Revision))
+		if err := r.Delete(ctx, &revs[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreSpec replaces the spec of the Fufu by the one of a revision. A rollback doesn't wait
+// for the maintenance windows, the restored spec rolls out at once like with rollout-now
+func restoreSpec(fufu *catv1alpha2.Fufu, spec *catv1alpha2.FufuSpec) {
+	spec.RevisionHistoryLimit = fufu.Spec.RevisionHistoryLimit
+	spec.Replicas = fufu.Spec.Replicas
+	fufu.Spec = *spec
+
+	if len(fufu.Spec.MaintenanceWindows) > 0 {
+		if fufu.Annotations == nil {
+			fufu.Annotations = map[string]string{}
+		}
+		fufu.Annotations[catv1alpha2.RolloutNowAnnotation] = "true"
+	}
+}
+
+// outsidePods returns spec with the fields that don't shape the pods taken from other, so
+// an automatic rollback keeps the changes made to them since
+func outsidePods(spec, other *catv1alpha2.FufuSpec) *catv1alpha2.FufuSpec {
+	s, o := spec.DeepCopy(), other.DeepCopy()
+	s.Autoscaling = o.Autoscaling
+	s.Replicas = o.Replicas
+	s.DisruptionBudget = o.DisruptionBudget
+	s.NetworkPolicy = o.NetworkPolicy
+	s.Service = o.Service
+	s.RolloutStrategy = o.RolloutStrategy
+	s.RevisionHistoryLimit = o.RevisionHistoryLimit
+	s.AdoptionPolicy = o.AdoptionPolicy
+	s.Hibernation = o.Hibernation
+	s.MaintenanceWindows = o.MaintenanceWindows
+	return s
+}
+
+// rollback restores the spec of the revision asked by the rollback-to annotation, it tells
+// whether the Fufu was updated, in which case it is reconciled again with the restored spec
+func (r *FufuReconciler) rollback(fufu *catv1alpha2.Fufu, ctx context.Context) (bool, error) {
+	loggr := log.FromContext(ctx)
+
+	to, ok := fufu.Annotations[catv1alpha2.RollbackToAnnotation]
+	if !ok {
+		return false, nil
+	}
+	delete(fufu.Annotations, catv1alpha2.RollbackToAnnotation)
+	pods := fufu.Annotations[rollbackPodsAnnotation] == "true"
+	delete(fufu.Annotations, rollbackPodsAnnotation)
+
+	var target *appsv1.ControllerRevision
+	revision, err := strconv.ParseInt(to, 10, 64)
+	if err == nil {
+		revs, err := r.listRevisions(fufu, ctx)
+		if err != nil {
+			return false, err
+		}
+		for i := range revs {
+			if revs[i].Revision == revision {
+				target = &revs[i]
+			}
+		}
+	}
+
+	spec := &catv1alpha2.FufuSpec{}
+	switch {
+	case target == nil:
+		loggr.Info(fmt.Sprintf("Revision %s not found, drop the rollback", to))
+		r.Recorder.Eventf(fufu, corev1.EventTypeWarning, "rollback-failed", "Revision %s not found", to)
+	case json.Unmarshal(target.Data.Raw, spec) != nil:
+		loggr.Info(fmt.Sprintf("Revision %s can't be decoded, drop the rollback", to))
+		r.Recorder.Eventf(fufu, corev1.EventTypeWarning, "rollback-failed", "Revision %s can't be decoded", to)
+	default:
+		loggr.Info(fmt.Sprintf("Roll back to revision %s ...", to))
+		what := "Spec"
+		if pods {
+			spec, what = outsidePods(spec, &fufu.Spec), "Pods"
+		}
+		restoreSpec(fufu, spec)
+		r.Recorder.Eventf(fufu, corev1.EventTypeNormal, "rolled-back", "%s restored from revision %s", what, to)
+	}
+
+	if err := r.Update(ctx, fufu); err != nil {
+		return false, err
+	}
+	return true, nil
+}
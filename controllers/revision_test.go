@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+func TestHashSpec(t *testing.T) {
+	var limit, replicas int32 = 3, 5
+	base := catv1alpha2.FufuSpec{Color: "orange", Weight: "5kg"}
+	tuned := *base.DeepCopy()
+	tuned.RevisionHistoryLimit = &limit
+	scaled := *base.DeepCopy()
+	scaled.Replicas = &replicas
+	changed := *base.DeepCopy()
+	changed.Color = "black"
+
+	hash := func(spec catv1alpha2.FufuSpec) string {
+		h, _, err := hashSpec(&spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	if hash(base) != hash(tuned) {
+		t.Errorf("the history limit should not be part of the revision")
+	}
+	if hash(base) != hash(scaled) {
+		t.Errorf("scaling should not record a new revision")
+	}
+	if hash(base) == hash(changed) {
+		t.Errorf("a changed color should record a new revision")
+	}
+	if tuned.RevisionHistoryLimit == nil || scaled.Replicas == nil {
+		t.Errorf("hashing should not alter the spec")
+	}
+}
+
+func TestNewRevisionLongName(t *testing.T) {
+	fufu := &catv1alpha2.Fufu{ObjectMeta: metav1.ObjectMeta{Name: strings.Repeat("fufu", 20), Namespace: "default"}}
+
+	rev := newRevision(fufu, "0123456789", []byte("{}"), 1)
+	if errs := validation.IsDNS1123Label(rev.Name); len(errs) > 0 {
+		t.Errorf("name %s: %v", rev.Name, errs)
+	}
+	if errs := validation.IsValidLabelValue(rev.Labels[revisionFufuLabel]); len(errs) > 0 {
+		t.Errorf("label %s: %v", rev.Labels[revisionFufuLabel], errs)
+	}
+	if other := newRevision(fufu, "9876543210", []byte("{}"), 2); other.Name == rev.Name {
+		t.Errorf("revisions of distinct specs share the name %s", rev.Name)
+	}
+}
@@ -27,6 +27,7 @@ import (
 	. "github.com/onsi/gomega"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	"sigs.k8s.io/controller-runtime/pkg/envtest/printer"
@@ -89,7 +90,8 @@ var _ = BeforeSuite(func() {
 	// 以下代码并未自动生成
 	// 将自定义的controller逻辑加入manager
 	k8sMgr, err := ctrl.NewManager(cfg, ctrl.Options{
-		Scheme: scheme.Scheme,
+		Scheme:   scheme.Scheme,
+		NewCache: cache.BuilderWithOptions(cache.Options{SelectorsByObject: CacheSelectors()}),
 	})
 	Expect(err).ToNot(HaveOccurred())
 
@@ -100,6 +102,13 @@ var _ = BeforeSuite(func() {
 	}).SetupWithManager(k8sMgr)
 	Expect(err).ToNot(HaveOccurred())
 
+	err = (&FufuHouseholdReconciler{
+		Client:   k8sMgr.GetClient(),
+		Scheme:   k8sMgr.GetScheme(),
+		Recorder: k8sMgr.GetEventRecorderFor("FufuHousehold"),
+	}).SetupWithManager(k8sMgr)
+	Expect(err).ToNot(HaveOccurred())
+
 	go func() {
 		defer GinkgoRecover()
 		// 在独立的goroutine中启动manager
@@ -2,6 +2,9 @@ package controllers
 
 import (
 	"context"
+	"net"
+	"strconv"
+	"strings"
 
 	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/types"
@@ -23,20 +26,17 @@ func (r *FufuReconciler) updateSvc(fufu *catv1alpha2.Fufu, ctx context.Context)
 
 	had := &corev1.Service{}
 	if err := r.Get(ctx, types.NamespacedName{Name: wanted.ObjectMeta.Name, Namespace: wanted.ObjectMeta.Namespace}, had); err == nil {
-		if len(had.Status.LoadBalancer.String()) > 0 && len(had.Status.LoadBalancer.Ingress) > 0 {
-			fufu.Status.ExternalIP = had.Status.LoadBalancer.Ingress[0].IP
-			if err = r.Status().Update(ctx, fufu); err != nil {
-				return err
-			}
-		}
+		fufu.Status.Endpoints, fufu.Status.ExternalIP = svcEndpoints(had)
 
-		if !equality.Semantic.DeepDerivative(wanted.Spec.Selector, had.Spec.Selector) || !equality.Semantic.DeepDerivative(wanted.Spec.Ports[0].Port, had.Spec.Ports[0].Port) {
+		if len(wanted.Spec.Selector) != len(had.Spec.Selector) || !equality.Semantic.DeepDerivative(wanted.Spec.Selector, had.Spec.Selector) || wanted.Spec.Type != had.Spec.Type || len(wanted.Spec.Ports) != len(had.Spec.Ports) ||
+			!equality.Semantic.DeepDerivative(wanted.Spec.Ports, had.Spec.Ports) || metadataChanged(wanted, had) {
 			loggr.Info("A diff was found, update svc ...")
+			drifted := markApplied(wanted, had)
 			ctrutil.SetControllerReference(fufu, wanted, r.Scheme)
 			if err = r.Update(ctx, wanted); err != nil {
 				return err
 			}
-			r.Recorder.Event(fufu, corev1.EventTypeNormal, "svc-updated", "Service updated")
+			r.childUpdated(fufu, drifted, "svc-updated", "Service updated")
 			//loggr.Info("Service updated")
 		}
 		return nil
@@ -45,7 +45,16 @@ func (r *FufuReconciler) updateSvc(fufu *catv1alpha2.Fufu, ctx context.Context)
 			return err
 		}
 
+		// the LoadBalancer went away with the svc
+		fufu.Status.Endpoints, fufu.Status.ExternalIP = nil, ""
+
+		// a renamed LoadBalancer is a new one, the clients have to follow its address
+		if prev := fufu.Status.Resources; prev != nil && prev.Service != "" && prev.Service != wanted.Name && wanted.Spec.Type == corev1.ServiceTypeLoadBalancer {
+			r.Recorder.Eventf(fufu, corev1.EventTypeWarning, "svc-renamed", "Service renamed from %s to %s, the LoadBalancer gets a new address, the previous one is released once it serves", prev.Service, wanted.Name)
+		}
+
 		loggr.Info("Create svc ...")
+		markApplied(wanted, nil)
 		ctrutil.SetControllerReference(fufu, wanted, r.Scheme)
 		if err = r.Create(ctx, wanted); err != nil {
 			loggr.Error(err, "failed to create svc")
@@ -57,27 +66,113 @@ func (r *FufuReconciler) updateSvc(fufu *catv1alpha2.Fufu, ctx context.Context)
 	}
 }
 
+// svcEndpoints lists the addresses of the LoadBalancer, all of them for dual-stack ones, with
+// the web port. Nothing is left once the LoadBalancer is gone
+func svcEndpoints(svc *corev1.Service) ([]catv1alpha2.Endpoint, string) {
+	if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return nil, ""
+	}
+
+	var port int32 = 80
+	for _, p := range svc.Spec.Ports {
+		if p.Name == webPortName {
+			port = p.Port
+		}
+	}
+
+	var endpoints []catv1alpha2.Endpoint
+	var externalIP, externalHostname string
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		host := ingress.IP
+		if host == "" {
+			host = ingress.Hostname
+		}
+		if host == "" {
+			continue
+		}
+
+		url := "http://" + net.JoinHostPort(host, strconv.Itoa(int(port))) + "/"
+		if port == 80 {
+			url = "http://" + host + "/"
+			if strings.Contains(host, ":") {
+				url = "http://[" + host + "]/"
+			}
+		}
+
+		endpoint := catv1alpha2.Endpoint{Port: port, URL: url}
+		if ingress.IP != "" {
+			endpoint.IP = ingress.IP
+			if externalIP == "" {
+				externalIP = ingress.IP
+			}
+		} else {
+			endpoint.Hostname = ingress.Hostname
+			if externalHostname == "" {
+				externalHostname = ingress.Hostname
+			}
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+
+	if externalIP == "" {
+		externalIP = externalHostname
+	}
+	return endpoints, externalIP
+}
+
 func (r *FufuReconciler) createSvc(fufu *catv1alpha2.Fufu) *corev1.Service {
-	name := fufu.Name + "-svc"
-	selectName := fufu.Name + "-deploy"
-	labels := map[string]string{
+	name := childName(fufu, catv1alpha2.SuffixService)
+	selectName := appLabel(fufu)
+	svcType := corev1.ServiceTypeLoadBalancer
+	if fufu.Spec.Service.Type != "" {
+		svcType = fufu.Spec.Service.Type
+	}
+
+	// a blue-green Fufu is served by its active colour only, and by the plain pods until
+	// the first switch as the preview shares their app label
+	selector := map[string]string{
 		"app": selectName,
 	}
+	if color := activeColor(fufu); color != "" {
+		selector[colorLabel] = color
+	} else if switchingToBlueGreen(fufu) {
+		selector[trackLabel] = stableTrack
+	}
+	// the placeholder page answers while the Fufu sleeps, and until its pods are available
+	if asleep(fufu) || wakingUp(fufu) {
+		selector = map[string]string{
+			"app": placeholderLabel(fufu),
+		}
+	}
 
-	return &corev1.Service{
+	svc := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      name,
-			Namespace: fufu.Namespace,
+			Name:        name,
+			Namespace:   fufu.Namespace,
+			Labels:      commonLabels(fufu),
+			Annotations: commonAnnotations(fufu),
 		},
 		Spec: corev1.ServiceSpec{
-			Selector: labels,
+			Selector: selector,
 			Ports: []corev1.ServicePort{
 				{
+					Name:       webPortName,
 					Port:       80,
 					TargetPort: intstr.FromInt(80),
 				},
 			},
-			Type: corev1.ServiceTypeLoadBalancer,
+			Type: svcType,
 		},
 	}
+
+	// expose the exporter's port for the ServiceMonitor
+	if fufu.Spec.Monitoring.Enabled {
+		svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{
+			Name:       metricsPortName,
+			Port:       metricsPort,
+			TargetPort: intstr.FromString(metricsPortName),
+		})
+	}
+
+	return svc
 }
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+func TestSvcEndpoints(t *testing.T) {
+	lb := func(ports []corev1.ServicePort, ingress ...corev1.LoadBalancerIngress) *corev1.Service {
+		svc := &corev1.Service{}
+		svc.Spec.Type = corev1.ServiceTypeLoadBalancer
+		svc.Spec.Ports = ports
+		svc.Status.LoadBalancer.Ingress = ingress
+		return svc
+	}
+	web := []corev1.ServicePort{{Name: webPortName, Port: 80}}
+
+	cases := []struct {
+		name           string
+		svc            *corev1.Service
+		want           []catv1alpha2.Endpoint
+		wantExternalIP string
+	}{
+		{
+			name: "no ingress yet",
+			svc:  lb(web),
+		},
+		{
+			name: "cluster ip",
+			svc: func() *corev1.Service {
+				svc := lb(web, corev1.LoadBalancerIngress{IP: "10.10.10.10"})
+				svc.Spec.Type = corev1.ServiceTypeClusterIP
+				return svc
+			}(),
+		},
+		{
+			name:           "ip",
+			svc:            lb(web, corev1.LoadBalancerIngress{IP: "10.10.10.10"}),
+			want:           []catv1alpha2.Endpoint{{IP: "10.10.10.10", Port: 80, URL: "http://10.10.10.10/"}},
+			wantExternalIP: "10.10.10.10",
+		},
+		{
+			name:           "hostname",
+			svc:            lb(web, corev1.LoadBalancerIngress{Hostname: "fufu.elb.example.com"}),
+			want:           []catv1alpha2.Endpoint{{Hostname: "fufu.elb.example.com", Port: 80, URL: "http://fufu.elb.example.com/"}},
+			wantExternalIP: "fufu.elb.example.com",
+		},
+		{
+			name: "dual-stack",
+			svc:  lb(web, corev1.LoadBalancerIngress{IP: "2001:db8::10"}, corev1.LoadBalancerIngress{IP: "10.10.10.10"}),
+			want: []catv1alpha2.Endpoint{
+				{IP: "2001:db8::10", Port: 80, URL: "http://[2001:db8::10]/"},
+				{IP: "10.10.10.10", Port: 80, URL: "http://10.10.10.10/"},
+			},
+			wantExternalIP: "2001:db8::10",
+		},
+		{
+			name: "hostname before ip",
+			svc:  lb(web, corev1.LoadBalancerIngress{Hostname: "fufu.elb.example.com"}, corev1.LoadBalancerIngress{IP: "10.10.10.10"}),
+			want: []catv1alpha2.Endpoint{
+				{Hostname: "fufu.elb.example.com", Port: 80, URL: "http://fufu.elb.example.com/"},
+				{IP: "10.10.10.10", Port: 80, URL: "http://10.10.10.10/"},
+			},
+			wantExternalIP: "10.10.10.10",
+		},
+		{
+			name:           "other port",
+			svc:            lb([]corev1.ServicePort{{Name: webPortName, Port: 8080}}, corev1.LoadBalancerIngress{IP: "2001:db8::10"}),
+			want:           []catv1alpha2.Endpoint{{IP: "2001:db8::10", Port: 8080, URL: "http://[2001:db8::10]:8080/"}},
+			wantExternalIP: "2001:db8::10",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			endpoints, externalIP := svcEndpoints(c.svc)
+			if !reflect.DeepEqual(endpoints, c.want) {
+				t.Errorf("endpoints %+v, want %+v", endpoints, c.want)
+			}
+			if externalIP != c.wantExternalIP {
+				t.Errorf("external ip %q, want %q", externalIP, c.wantExternalIP)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+const dateLayout = "2006-01-02"
+
+// vaccineDue is the date the latest shot of a vaccine expires
+type vaccineDue struct {
+	Name string
+	Due  time.Time
+}
+
+// vaccinationSchedule returns the due date of each vaccine, sorted by date, computed
+// from its latest administration
+func vaccinationSchedule(vaccinations []catv1alpha2.Vaccination) ([]vaccineDue, error) {
+	latest := map[string]vaccineDue{}
+	for _, v := range vaccinations {
+		date, err := time.Parse(dateLayout, v.Date)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date of vaccination %s: %w", v.Name, err)
+		}
+
+		due := date.AddDate(0, int(v.ValidityMonths), 0)
+		if prev, ok := latest[v.Name]; !ok || due.After(prev.Due) {
+			latest[v.Name] = vaccineDue{Name: v.Name, Due: due}
+		}
+	}
+
+	schedule := make([]vaccineDue, 0, len(latest))
+	for _, v := range latest {
+		schedule = append(schedule, v)
+	}
+	sort.Slice(schedule, func(i, j int) bool {
+		if schedule[i].Due.Equal(schedule[j].Due) {
+			return schedule[i].Name < schedule[j].Name
+		}
+		return schedule[i].Due.Before(schedule[j].Due)
+	})
+
+	return schedule, nil
+}
+
+// updateVaccinations reflects the vaccination schedule into Fufu's status, it returns the delay
+// until the next vaccination falls due, 0 if none is upcoming
+func (r *FufuReconciler) updateVaccinations(fufu *catv1alpha2.Fufu, now time.Time) (time.Duration, error) {
+	if len(fufu.Spec.Info.Vaccinations) == 0 {
+		fufu.Status.NextVaccinationDue = nil
+		meta.RemoveStatusCondition(&fufu.Status.Conditions, catv1alpha2.ConditionVaccinationOverdue)
+		return 0, nil
+	}
+
+	schedule, err := vaccinationSchedule(fufu.Spec.Info.Vaccinations)
+	if err != nil {
+		return 0, err
+	}
+
+	next := metav1.NewTime(schedule[0].Due)
+	fufu.Status.NextVaccinationDue = &next
+
+	var overdue []string
+	var requeueAfter time.Duration
+	for _, v := range schedule {
+		if !v.Due.After(now) {
+			overdue = append(overdue, fmt.Sprintf("%s (due %s)", v.Name, v.Due.Format(dateLayout)))
+			continue
+		}
+		requeueAfter = v.Due.Sub(now)
+		break
+	}
+
+	cond := metav1.Condition{
+		Type:               catv1alpha2.ConditionVaccinationOverdue,
+		Status:             metav1.ConditionFalse,
+		Reason:             "UpToDate",
+		Message:            "All vaccinations are up to date",
+		ObservedGeneration: fufu.Generation,
+	}
+	if len(overdue) > 0 {
+		cond.Status = metav1.ConditionTrue
+		cond.Reason = "PastDue"
+		cond.Message = "Vaccinations past due: " + strings.Join(overdue, ", ")
+
+		if !meta.IsStatusConditionTrue(fufu.Status.Conditions, catv1alpha2.ConditionVaccinationOverdue) {
+			r.Recorder.Event(fufu, corev1.EventTypeWarning, "vaccination-overdue", cond.Message)
+		}
+	}
+	meta.SetStatusCondition(&fufu.Status.Conditions, cond)
+
+	return requeueAfter, nil
+}
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/tools/record"
+
+	catv1alpha2 "github.com/ZhengjunHUO/kubebuilder/api/v1alpha2"
+)
+
+func TestUpdateVaccinations(t *testing.T) {
+	now := time.Date(2022, time.June, 1, 12, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name         string
+		vaccinations []catv1alpha2.Vaccination
+		wantNext     string
+		wantOverdue  bool
+		wantRequeue  time.Duration
+	}{
+		{
+			name: "no vaccination",
+		},
+		{
+			name: "up to date",
+			vaccinations: []catv1alpha2.Vaccination{
+				{Name: "rabies", Date: "2021-06-02", ValidityMonths: 12},
+				{Name: "typhus", Date: "2022-01-01", ValidityMonths: 36},
+			},
+			wantNext:    "2022-06-02",
+			wantRequeue: 12 * time.Hour,
+		},
+		{
+			name: "latest shot is tracked",
+			vaccinations: []catv1alpha2.Vaccination{
+				{Name: "rabies", Date: "2020-01-01", ValidityMonths: 12},
+				{Name: "rabies", Date: "2022-01-01", ValidityMonths: 12},
+			},
+			wantNext:    "2023-01-01",
+			wantRequeue: time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC).Sub(now),
+		},
+		{
+			name: "past due",
+			vaccinations: []catv1alpha2.Vaccination{
+				{Name: "rabies", Date: "2021-01-01", ValidityMonths: 12},
+				{Name: "typhus", Date: "2022-01-01", ValidityMonths: 12},
+			},
+			wantNext:    "2022-01-01",
+			wantOverdue: true,
+			wantRequeue: time.Date(2023, time.January, 1, 0, 0, 0, 0, time.UTC).Sub(now),
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			r := &FufuReconciler{Recorder: recorder}
+			fufu := &catv1alpha2.Fufu{}
+			fufu.Spec.Info.Vaccinations = c.vaccinations
+
+			requeue, err := r.updateVaccinations(fufu, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if requeue != c.wantRequeue {
+				t.Errorf("requeue after %v, want %v", requeue, c.wantRequeue)
+			}
+
+			next := ""
+			if fufu.Status.NextVaccinationDue != nil {
+				next = fufu.Status.NextVaccinationDue.Format(dateLayout)
+			}
+			if next != c.wantNext {
+				t.Errorf("next vaccination due %q, want %q", next, c.wantNext)
+			}
+
+			if overdue := meta.IsStatusConditionTrue(fufu.Status.Conditions, catv1alpha2.ConditionVaccinationOverdue); overdue != c.wantOverdue {
+				t.Errorf("overdue %v, want %v", overdue, c.wantOverdue)
+			}
+			if c.wantOverdue && len(recorder.Events) != 1 {
+				t.Errorf("expected a warning event when overdue")
+			}
+		})
+	}
+}
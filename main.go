@@ -17,8 +17,10 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -28,6 +30,7 @@ import (
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
@@ -52,8 +55,12 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var notificationConfig string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
+	flag.StringVar(&notificationConfig, "notification-config", "",
+		"The file listing the sinks the Fufu's lifecycle events are sent to as CloudEvents. "+
+			"No notification is sent if empty.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
@@ -72,6 +79,8 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "546401d8.huozj.io",
+		// 只缓存Fufu的pod, 而不是集群中所有的pod
+		NewCache: cache.BuilderWithOptions(cache.Options{SelectorsByObject: controllers.CacheSelectors()}),
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -89,13 +98,43 @@ func main() {
 		os.Exit(1)
 	}
 
+	// 配置了通知时, 由leader将Fufu的生命周期事件以CloudEvents发送到各个sink
+	var notifier *controllers.Notifier
+	if notificationConfig != "" {
+		cfg, err := controllers.LoadNotificationConfig(notificationConfig)
+		if err != nil {
+			setupLog.Error(err, "unable to load the notification config")
+			os.Exit(1)
+		}
+		notifier = controllers.NewNotifier(cfg)
+		if err := mgr.Add(notifier); err != nil {
+			setupLog.Error(err, "unable to set up notifier")
+			os.Exit(1)
+		}
+	}
+
 	if err = (&controllers.FufuReconciler{
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Notifier: notifier,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Fufu")
+		os.Exit(1)
+	}
+	if err = (&controllers.FufuHouseholdReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Fufu")
+		setupLog.Error(err, "unable to create controller", "controller", "FufuHousehold")
 		os.Exit(1)
 	}
+	// 本地运行(make run)且没有证书时, 可以设置ENABLE_WEBHOOKS=false跳过webhook
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&catv1alpha2.Fufu{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Fufu")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -107,6 +146,18 @@ func main() {
 		os.Exit(1)
 	}
 
+	// 启动前检查service account是否具备所有需要的RBAC权限
+	permChecker := controllers.NewPermissionChecker(mgr.GetClient())
+	preflightCtx, cancelPreflight := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := permChecker.Preflight(ctrl.LoggerInto(preflightCtx, setupLog)); err != nil {
+		setupLog.Error(err, "unable to run the permission preflight check")
+	}
+	cancelPreflight()
+	if err := mgr.AddReadyzCheck("permissions", permChecker.Readyz); err != nil {
+		setupLog.Error(err, "unable to set up permission check")
+		os.Exit(1)
+	}
+
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")